@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+)
+
+type client struct {
+	quote   pb.QuoteServiceClient
+	order   pb.OrderServiceClient
+	printer printer
+}
+
+// command is a subcommand, args documents its numeric arguments.
+type command struct {
+	args string
+	run  func(ctx context.Context, c *client, ids []int32) error
+}
+
+var commands = map[string]command{
+	"quote get":    {"<customer-id>", getQuote},
+	"quote add":    {"<customer-id> <product-id> <quantity>", addProduct},
+	"quote update": {"<customer-id> <product-id> <quantity>", updateQuantity},
+	"quote remove": {"<customer-id> <product-id>", removeProduct},
+	"order place":  {"<customer-id>", placeOrder},
+	"order get":    {"<order-id>", getOrder},
+	"order list":   {"<customer-id>", listOrders},
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getQuote(ctx context.Context, c *client, ids []int32) error {
+	quote, err := c.quote.GetQuote(ctx, &pb.CustomerId{Id: ids[0]})
+	if err != nil {
+		return err
+	}
+	return c.printer.quote(quote)
+}
+
+func addProduct(ctx context.Context, c *client, ids []int32) error {
+	quote, err := c.quote.AddProduct(ctx, &pb.ProductRequest{CustomerId: ids[0], ProductId: ids[1], Quantity: ids[2]})
+	if err != nil {
+		return err
+	}
+	return c.printer.quote(quote)
+}
+
+func updateQuantity(ctx context.Context, c *client, ids []int32) error {
+	quote, err := c.quote.UpdateQuantity(ctx, &pb.ProductRequest{CustomerId: ids[0], ProductId: ids[1], Quantity: ids[2]})
+	if err != nil {
+		return err
+	}
+	return c.printer.quote(quote)
+}
+
+func removeProduct(ctx context.Context, c *client, ids []int32) error {
+	quote, err := c.quote.RemoveProduct(ctx, &pb.ProductRequest{CustomerId: ids[0], ProductId: ids[1]})
+	if err != nil {
+		return err
+	}
+	return c.printer.quote(quote)
+}
+
+// placeOrder prints every status of the checkout as soon as it arrives.
+func placeOrder(ctx context.Context, c *client, ids []int32) error {
+	stream, err := c.order.PlaceOrder(ctx, &pb.CustomerId{Id: ids[0]})
+	if err != nil {
+		return err
+	}
+	for {
+		processStatus, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.printer.processStatus(processStatus); err != nil {
+			return err
+		}
+	}
+}
+
+func getOrder(ctx context.Context, c *client, ids []int32) error {
+	order, err := c.order.GetOrder(ctx, &pb.OrderId{Id: ids[0]})
+	if err != nil {
+		return err
+	}
+	return c.printer.order(order)
+}
+
+func listOrders(ctx context.Context, c *client, ids []int32) error {
+	orders, err := c.order.GetOrders(ctx, &pb.CustomerId{Id: ids[0]})
+	if err != nil {
+		return err
+	}
+	return c.printer.orders(orders)
+}
@@ -0,0 +1,145 @@
+// Command salesctl calls the quote and order services of the sale server, to debug
+// the service without writing a client.
+//
+//	salesctl [flags] quote get <customer-id>
+//	salesctl [flags] quote add <customer-id> <product-id> <quantity>
+//	salesctl [flags] quote update <customer-id> <product-id> <quantity>
+//	salesctl [flags] quote remove <customer-id> <product-id>
+//	salesctl [flags] order place <customer-id>
+//	salesctl [flags] order get <order-id>
+//	salesctl [flags] order list <customer-id>
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sale/internal"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// errUsage reports invalid arguments, the usage has already been printed.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, os.Getenv))
+}
+
+// run executes the command line and returns the exit code: 1 when the call failed, 2 on invalid usage.
+func run(args []string, stdout io.Writer, stderr io.Writer, getenv func(string) string) int {
+	flags := flag.NewFlagSet("salesctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	address := flags.String("address", envOr(getenv, "SALESCTL_ADDRESS", "localhost:50052"), "Address of the sale gRPC server")
+	token := flags.String("token", getenv("SALESCTL_TOKEN"), "Bearer token sent with every call")
+	output := flags.String("output", "table", "Output format, table or json")
+	timeout := flags.Duration("timeout", 30*time.Second, "Deadline of the whole command")
+	useTLS := flags.Bool("tls", false, "Connect with TLS")
+	caFile := flags.String("ca-file", "", "CA of the server certificate, the system roots by default")
+	serverName := flags.String("server-name", "", "Name expected in the server certificate")
+	priceToken := flags.String("price-token", "", "Price token of a PRICE_CHANGED failure, order place accepts the new prices")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: salesctl [flags] <command> <args>\n\nCommands:\n")
+		for _, name := range commandNames() {
+			fmt.Fprintf(stderr, "  %s %s\n", name, commands[name].args)
+		}
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	printer, err := newPrinter(*output, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	command, ids, err := parseCommand(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		flags.Usage()
+		return 2
+	}
+
+	creds := insecure.NewCredentials()
+	if *useTLS {
+		tlsConfig, err := internal.NewClientTLSConfig(internal.TLSConfig{Enabled: true, CAFile: *caFile, ServerName: *serverName, ReloadInterval: time.Hour})
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to load TLS configuration: %v\n", err)
+			return 1
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(*address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to connect to %s: %v\n", *address, err)
+		return 1
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if *token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+	}
+	if *priceToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, internal.PriceTokenMetadata, *priceToken)
+	}
+	c := &client{
+		quote:   pb.NewQuoteServiceClient(conn),
+		order:   pb.NewOrderServiceClient(conn),
+		printer: printer,
+	}
+	if err := command.run(ctx, c, ids); err != nil {
+		printError(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func envOr(getenv func(string) string, name string, fallback string) string {
+	if value := getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseCommand finds the command named by the first two arguments, the remaining
+// arguments are its numeric IDs and quantities.
+func parseCommand(args []string) (command, []int32, error) {
+	if len(args) < 2 {
+		return command{}, nil, fmt.Errorf("missing command")
+	}
+	name := args[0] + " " + args[1]
+	cmd, exists := commands[name]
+	if !exists {
+		return command{}, nil, fmt.Errorf("unknown command %q", name)
+	}
+	values := args[2:]
+	if len(values) != len(strings.Fields(cmd.args)) {
+		return command{}, nil, fmt.Errorf("usage: salesctl %s %s", name, cmd.args)
+	}
+	ids := make([]int32, 0, len(values))
+	for _, value := range values {
+		id, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return command{}, nil, fmt.Errorf("invalid number %q", value)
+		}
+		ids = append(ids, int32(id))
+	}
+	return cmd, ids, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"sale/internal"
+	"strings"
+	"testing"
+
+	pbc "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/catalog"
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// startServer serves the quote and order servers on a local port and returns its address.
+func startServer(t *testing.T) string {
+	catalogClient := internal.NewMockCatalogClient()
+	catalogClient.On("GetProductInfo", mock.Anything).Return(&pbc.Product{Price: 10.0}, nil)
+	quoteServer, quoteStorage := internal.NewQuoteServer(internal.QuoteConfig{}, catalogClient, nil)
+	orderServer := internal.NewOrderServer(internal.OrderConfig{}, quoteStorage, catalogClient, nil)
+
+	s := grpc.NewServer()
+	pb.RegisterQuoteServiceServer(s, quoteServer)
+	pb.RegisterOrderServiceServer(s, orderServer)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func runCommand(t *testing.T, address string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	getenv := func(name string) string {
+		if name == "SALESCTL_ADDRESS" {
+			return address
+		}
+		return ""
+	}
+	code := run(args, &stdout, &stderr, getenv)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_QuoteAndOrders(t *testing.T) {
+	address := startServer(t)
+
+	code, out, _ := runCommand(t, address, "quote", "add", "1", "101", "2")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "PRODUCT  QUANTITY  PRICE  SUBTOTAL\n101      2         10.00  20.00\n                   TOTAL  20.00\n", out)
+
+	code, out, _ = runCommand(t, address, "-output", "json", "quote", "update", "1", "101", "3")
+	assert.Equal(t, 0, code)
+	var quote map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(out), &quote))
+	assert.Equal(t, float64(1), quote["customerId"])
+
+	code, out, _ = runCommand(t, address, "quote", "get", "1")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "30.00")
+
+	code, out, _ = runCommand(t, address, "order", "place", "1")
+	assert.Equal(t, 0, code)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "1       STARTED    Order processing started.", lines[0])
+	assert.Equal(t, "1       COMPLETED  Order has been completed.", lines[3])
+
+	code, out, _ = runCommand(t, address, "order", "list", "1")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "ORDER  CUSTOMER  ITEMS  TOTAL\n1      1         1      30.00\n", out)
+
+	code, out, _ = runCommand(t, address, "-output", "json", "order", "get", "1")
+	assert.Equal(t, 0, code)
+	var order map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(out), &order))
+	assert.Equal(t, float64(1), order["id"])
+
+	// The checkout has emptied the quote.
+	code, out, _ = runCommand(t, address, "quote", "remove", "1", "101")
+	assert.Equal(t, 1, code, out)
+}
+
+func TestRun_PlaceOrderJSONLines(t *testing.T) {
+	address := startServer(t)
+	runCommand(t, address, "quote", "add", "1", "101", "1")
+
+	code, out, _ := runCommand(t, address, "-output", "json", "order", "place", "1")
+	assert.Equal(t, 0, code)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 4)
+	for _, line := range lines {
+		var processStatus map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &processStatus))
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	address := startServer(t)
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStderr string
+	}{
+		{"Missing command", nil, 2, "missing command"},
+		{"Unknown command", []string{"quote", "clear", "1"}, 2, `unknown command "quote clear"`},
+		{"Missing argument", []string{"quote", "add", "1", "101"}, 2, "usage: salesctl quote add <customer-id> <product-id> <quantity>"},
+		{"Invalid number", []string{"order", "get", "one"}, 2, `invalid number "one"`},
+		{"Unknown output", []string{"-output", "yaml", "order", "get", "1"}, 2, "unknown output format"},
+		{"Not found", []string{"order", "get", "42"}, 1, "error: NotFound: order with id 42 not found\n  reason: ORDER_NOT_FOUND"},
+		{"Quote not found", []string{"quote", "update", "7", "101", "1"}, 1, "reason: QUOTE_NOT_FOUND"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, stderr := runCommand(t, address, test.args...)
+			assert.Equal(t, test.wantCode, code)
+			assert.Contains(t, stderr, test.wantStderr)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type printer interface {
+	quote(quote *pb.Quote) error
+	order(order *pb.Order) error
+	orders(orders *pb.OrderList) error
+	processStatus(processStatus *pb.ProcessStatus) error
+}
+
+func newPrinter(format string, w io.Writer) (printer, error) {
+	switch format {
+	case "table":
+		return tablePrinter{w}, nil
+	case "json":
+		return jsonPrinter{w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, use table or json", format)
+}
+
+// jsonPrinter prints the responses in the protobuf JSON mapping. Stream messages are
+// printed one per line so the output can be piped to jq.
+type jsonPrinter struct {
+	w io.Writer
+}
+
+func (p jsonPrinter) print(message proto.Message, multiline bool) error {
+	options := protojson.MarshalOptions{EmitUnpopulated: true}
+	if multiline {
+		options.Indent = "  "
+	}
+	data, err := options.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.w, string(data))
+	return err
+}
+
+func (p jsonPrinter) quote(quote *pb.Quote) error {
+	return p.print(quote, true)
+}
+
+func (p jsonPrinter) order(order *pb.Order) error {
+	return p.print(order, true)
+}
+
+func (p jsonPrinter) orders(orders *pb.OrderList) error {
+	return p.print(orders, true)
+}
+
+func (p jsonPrinter) processStatus(processStatus *pb.ProcessStatus) error {
+	return p.print(processStatus, false)
+}
+
+type tablePrinter struct {
+	w io.Writer
+}
+
+func (p tablePrinter) quote(quote *pb.Quote) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "PRODUCT\tQUANTITY\tPRICE\tSUBTOTAL\n")
+	var total float32
+	for _, item := range quote.Items {
+		subtotal := item.Price * float32(item.Quantity)
+		total += subtotal
+		fmt.Fprintf(tw, "%d\t%d\t%.2f\t%.2f\n", item.ProductId, item.Quantity, item.Price, subtotal)
+	}
+	fmt.Fprintf(tw, "\t\tTOTAL\t%.2f\n", total)
+	return tw.Flush()
+}
+
+func (p tablePrinter) order(order *pb.Order) error {
+	fmt.Fprintf(p.w, "Order %d of customer %d\n", order.Id, order.CustomerId)
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "PRODUCT\tQUANTITY\tPRICE\tSUBTOTAL\n")
+	for _, item := range order.Items {
+		fmt.Fprintf(tw, "%d\t%d\t%.2f\t%.2f\n", item.ProductId, item.Quantity, item.Price, item.Price*float32(item.Quantity))
+	}
+	fmt.Fprintf(tw, "\t\tTOTAL\t%.2f\n", orderTotal(order))
+	return tw.Flush()
+}
+
+func (p tablePrinter) orders(orders *pb.OrderList) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ORDER\tCUSTOMER\tITEMS\tTOTAL\n")
+	for _, order := range orders.Orders {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%.2f\n", order.Id, order.CustomerId, len(order.Items), orderTotal(order))
+	}
+	return tw.Flush()
+}
+
+// processStatus prints fixed width columns, a tabwriter would hold the rows back until the stream ends.
+func (p tablePrinter) processStatus(processStatus *pb.ProcessStatus) error {
+	_, err := fmt.Fprintf(p.w, "%-7d %-10s %s\n", processStatus.OrderId, processStatus.Status, processStatus.Message)
+	return err
+}
+
+func orderTotal(order *pb.Order) float32 {
+	var total float32
+	for _, item := range order.Items {
+		total += item.Price * float32(item.Quantity)
+	}
+	return total
+}
+
+// printError prints the status of a failed call with the reason and field violations of its details.
+func printError(w io.Writer, err error) {
+	st := status.Convert(err)
+	fmt.Fprintf(w, "error: %s: %s\n", st.Code(), st.Message())
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			fmt.Fprintf(w, "  reason: %s\n", d.Reason)
+			keys := make([]string, 0, len(d.Metadata))
+			for key := range d.Metadata {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(w, "  %s: %s\n", key, d.Metadata[key])
+			}
+		case *errdetails.BadRequest:
+			for _, violation := range d.FieldViolations {
+				fmt.Fprintf(w, "  field %s: %s\n", violation.Field, violation.Description)
+			}
+		}
+	}
+}
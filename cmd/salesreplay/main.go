@@ -0,0 +1,266 @@
+// Command salesreplay replays the event log of the sale server to debug how a quote
+// evolved and why an order ended in its state. It prints the matching events and the
+// quotes and orders rebuilt from them.
+//
+//	salesreplay [flags] <event-log-file>
+//	salesreplay -customer 7 /var/lib/sale/events.jsonl
+//	salesreplay -order 12 -until 3400 -output json /var/lib/sale/events.jsonl
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sale/internal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// errStop ends the replay at the -until sequence number.
+var errStop = errors.New("stop")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// filter selects the events and the state printed, zero values match everything.
+type filter struct {
+	customerId int32
+	orderId    int32
+}
+
+func (f filter) event(event internal.Event) bool {
+	if f.orderId != 0 && event.OrderId != f.orderId {
+		return false
+	}
+	return f.customerId == 0 || event.CustomerId == f.customerId
+}
+
+// run replays the log and returns the exit code: 1 when the files could not be read, 2 on invalid usage.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("salesreplay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	snapshot := flags.String("snapshot", "", "Snapshot to start from, the events before it are not printed")
+	until := flags.Int64("until", 0, "Stop after the event with this sequence number, 0 replays the whole log")
+	customerId := flags.Int("customer", 0, "Only the events and state of this customer")
+	orderId := flags.Int("order", 0, "Only the events and state of this order")
+	output := flags.String("output", "table", "Output format, table or json")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: salesreplay [flags] <event-log-file>\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "unknown output format %q, use table or json\n", *output)
+		return 2
+	}
+
+	state := internal.NewEventState()
+	if *snapshot != "" {
+		var err error
+		if state, err = internal.NewSnapshotStore(*snapshot).Load(); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to open event log: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	f := filter{customerId: int32(*customerId), orderId: int32(*orderId)}
+	events := make([]internal.Event, 0)
+	err = internal.ReadEvents(file, func(event internal.Event, _ int64) error {
+		if event.Sequence <= state.Sequence {
+			return nil
+		}
+		if *until > 0 && event.Sequence > *until {
+			return errStop
+		}
+		state.Apply(&event)
+		if f.event(event) {
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		fmt.Fprintf(stderr, "failed to read event log: %v\n", err)
+		return 1
+	}
+
+	result := newReplay(events, state, f)
+	if *output == "json" {
+		err = printJSON(stdout, result)
+	} else {
+		err = printTable(stdout, result)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+type replayJSON struct {
+	Sequence int64            `json:"sequence"`
+	Events   []internal.Event `json:"events"`
+	Quotes   []quoteJSON      `json:"quotes"`
+	Orders   []orderJSON      `json:"orders"`
+}
+
+type quoteJSON struct {
+	CustomerId int32                `json:"customer_id"`
+	Items      []internal.EventItem `json:"items"`
+}
+
+type orderJSON struct {
+	Id         int32                `json:"id"`
+	CustomerId int32                `json:"customer_id"`
+	Status     string               `json:"status"`
+	CreatedAt  time.Time            `json:"created_at"`
+	Items      []internal.EventItem `json:"items"`
+}
+
+// newReplay collects the events and the quotes and orders of the state that match the filter.
+func newReplay(events []internal.Event, state *internal.EventState, f filter) replayJSON {
+	result := replayJSON{
+		Sequence: state.Sequence,
+		Events:   events,
+		Quotes:   make([]quoteJSON, 0),
+		Orders:   make([]orderJSON, 0),
+	}
+	if f.orderId == 0 {
+		for customerId, quote := range state.Quotes {
+			if f.customerId != 0 && customerId != f.customerId {
+				continue
+			}
+			q := quoteJSON{CustomerId: customerId, Items: make([]internal.EventItem, 0, len(quote.Items))}
+			for lineId, item := range quote.Items {
+				q.Items = append(q.Items, internal.EventItem{
+					LineId:    lineId,
+					ProductId: item.ProductID,
+					Variant:   item.Variant,
+					Options:   item.Options,
+					Quantity:  item.Quantity,
+					Price:     item.Price,
+				})
+			}
+			sort.Slice(q.Items, func(i, j int) bool { return q.Items[i].LineId < q.Items[j].LineId })
+			result.Quotes = append(result.Quotes, q)
+		}
+	}
+	for customerId, orders := range state.Orders {
+		if f.customerId != 0 && customerId != f.customerId {
+			continue
+		}
+		for orderId, order := range orders {
+			if f.orderId != 0 && orderId != f.orderId {
+				continue
+			}
+			o := orderJSON{Id: orderId, CustomerId: customerId, Status: order.Status.String(), CreatedAt: order.CreatedAt}
+			for _, item := range order.SortedItems() {
+				o.Items = append(o.Items, internal.EventItem{
+					LineId:    item.LineID,
+					ProductId: item.ProductID,
+					Variant:   item.Variant,
+					Options:   item.Options,
+					Quantity:  item.Quantity,
+					Price:     item.Price,
+				})
+			}
+			result.Orders = append(result.Orders, o)
+		}
+	}
+	sort.Slice(result.Quotes, func(i, j int) bool { return result.Quotes[i].CustomerId < result.Quotes[j].CustomerId })
+	sort.Slice(result.Orders, func(i, j int) bool { return result.Orders[i].Id < result.Orders[j].Id })
+	return result
+}
+
+func printJSON(w io.Writer, result replayJSON) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// printTable prints the events, then the quotes and the orders as they are after them.
+func printTable(w io.Writer, result replayJSON) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEQ\tTIME\tEVENT\tCUSTOMER\tORDER\tDETAILS")
+	for _, event := range result.Events {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", event.Sequence, event.Time.Format(time.RFC3339), event.Type, event.CustomerId, orderColumn(event.OrderId), details(event))
+	}
+	fmt.Fprintf(tw, "\nQUOTES AT %d\n", result.Sequence)
+	fmt.Fprintln(tw, "CUSTOMER\tLINE\tPRODUCT\tVARIANT\tQUANTITY\tPRICE")
+	for _, quote := range result.Quotes {
+		for _, item := range quote.Items {
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%d\t%.2f\n", quote.CustomerId, item.LineId, item.ProductId, item.Variant, item.Quantity, item.Price)
+		}
+	}
+	fmt.Fprintf(tw, "\nORDERS AT %d\n", result.Sequence)
+	fmt.Fprintln(tw, "ORDER\tCUSTOMER\tSTATUS\tCREATED\tITEMS\tTOTAL")
+	for _, order := range result.Orders {
+		var total float32
+		for _, item := range order.Items {
+			total += item.Price * float32(item.Quantity)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%d\t%.2f\n", order.Id, order.CustomerId, order.Status, order.CreatedAt.Format(time.RFC3339), len(order.Items), total)
+	}
+	return tw.Flush()
+}
+
+func orderColumn(orderId int32) string {
+	if orderId == 0 {
+		return "-"
+	}
+	return fmt.Sprint(orderId)
+}
+
+// details describes what the event changed in a few words.
+func details(event internal.Event) string {
+	var parts []string
+	switch event.Type {
+	case internal.EventItemAdded:
+		parts = append(parts, fmt.Sprintf("line %d: %d x product %d at %.2f", event.Item.LineId, event.Item.Quantity, event.Item.ProductId, event.Item.Price))
+		if event.Item.Variant != "" {
+			parts = append(parts, "variant "+event.Item.Variant)
+		}
+	case internal.EventQuantityChanged:
+		parts = append(parts, fmt.Sprintf("line %d: quantity %d at %.2f", event.Item.LineId, event.Item.Quantity, event.Item.Price))
+	case internal.EventItemRemoved:
+		parts = append(parts, fmt.Sprintf("line %d: product %d", event.Item.LineId, event.Item.ProductId))
+	case internal.EventOrderPlaced:
+		parts = append(parts, fmt.Sprintf("%d lines, %s", len(event.Items), event.Status))
+	case internal.EventStatusChanged:
+		parts = append(parts, event.Status)
+	case internal.EventReturnRequested:
+		parts = append(parts, fmt.Sprintf("return %d: %d lines, %s", event.ReturnId, len(event.Items), event.Status))
+	case internal.EventReturnStatusChanged:
+		parts = append(parts, fmt.Sprintf("return %d: %s", event.ReturnId, event.Status))
+	}
+	if event.Reason != "" {
+		parts = append(parts, "reason: "+event.Reason)
+	}
+	if event.Note != "" {
+		parts = append(parts, "note: "+event.Note)
+	}
+	return strings.Join(parts, ", ")
+}
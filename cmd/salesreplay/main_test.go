@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"sale/internal"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLog records the history of two quotes and an order in an event log file and returns its path.
+func writeLog(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := internal.OpenFileEventLog(path)
+	require.NoError(t, err)
+	defer log.Close()
+
+	placed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, log.Append(
+		&internal.Event{Time: placed, Type: internal.EventItemAdded, CustomerId: 1, Item: &internal.EventItem{LineId: 1, ProductId: 101, Quantity: 2, Price: 10}},
+		&internal.Event{Time: placed, Type: internal.EventItemAdded, CustomerId: 1, Item: &internal.EventItem{LineId: 2, ProductId: 102, Variant: "red", Quantity: 1, Price: 5}},
+		&internal.Event{Time: placed, Type: internal.EventQuantityChanged, CustomerId: 1, Item: &internal.EventItem{LineId: 1, ProductId: 101, Quantity: 3, Price: 10}},
+		&internal.Event{Time: placed, Type: internal.EventItemAdded, CustomerId: 2, Item: &internal.EventItem{LineId: 1, ProductId: 201, Quantity: 1, Price: 7}},
+		&internal.Event{Time: placed, Type: internal.EventItemRemoved, CustomerId: 1, Item: &internal.EventItem{LineId: 2, ProductId: 102}},
+		&internal.Event{Time: placed, Type: internal.EventOrderPlaced, CustomerId: 1, OrderId: 1, Status: "STARTED", Items: []internal.EventItem{{LineId: 1, ProductId: 101, Quantity: 3, Price: 10}}},
+		&internal.Event{Time: placed, Type: internal.EventQuoteCleared, CustomerId: 1, Reason: "checkout"},
+		&internal.Event{Time: placed, Type: internal.EventStatusChanged, CustomerId: 1, OrderId: 1, Status: "ERROR", Reason: "payment declined"},
+	))
+	return path
+}
+
+func runReplay(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Table(t *testing.T) {
+	path := writeLog(t)
+
+	code, out, _ := runReplay("-customer", "1", path)
+	assert.Equal(t, 0, code)
+	lines := strings.Split(out, "\n")
+	assert.Equal(t, "SEQ  TIME                  EVENT            CUSTOMER  ORDER  DETAILS", lines[0])
+	assert.Equal(t, "1    2024-05-01T12:00:00Z  ItemAdded        1         -      line 1: 2 x product 101 at 10.00", lines[1])
+	assert.Contains(t, lines[2], "variant red")
+	assert.Contains(t, lines[3], "line 1: quantity 3 at 10.00")
+	assert.Contains(t, lines[4], "ItemRemoved")
+	assert.Contains(t, lines[7], "ERROR, reason: payment declined")
+	assert.NotContains(t, out, "product 201")
+	assert.Contains(t, out, "QUOTES AT 8\n")
+	assert.Contains(t, out, "1      1         ERROR   2024-05-01T12:00:00Z  1      30.00\n")
+
+	code, out, _ = runReplay("-until", "3", path)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "QUOTES AT 3\n")
+	assert.NotContains(t, out, "ItemRemoved")
+}
+
+func TestRun_JSON(t *testing.T) {
+	path := writeLog(t)
+
+	code, out, _ := runReplay("-output", "json", path)
+	assert.Equal(t, 0, code)
+	var result replayJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &result))
+	assert.Equal(t, int64(8), result.Sequence)
+	assert.Len(t, result.Events, 8)
+	require.Len(t, result.Quotes, 1)
+	assert.Equal(t, int32(2), result.Quotes[0].CustomerId)
+	require.Len(t, result.Orders, 1)
+	assert.Equal(t, "ERROR", result.Orders[0].Status)
+
+	code, out, _ = runReplay("-output", "json", "-order", "1", "-until", "6", path)
+	assert.Equal(t, 0, code)
+	result = replayJSON{}
+	require.NoError(t, json.Unmarshal([]byte(out), &result))
+	require.Len(t, result.Events, 1)
+	assert.Equal(t, internal.EventOrderPlaced, result.Events[0].Type)
+	assert.Empty(t, result.Quotes)
+	assert.Equal(t, "STARTED", result.Orders[0].Status)
+}
+
+func TestRun_Snapshot(t *testing.T) {
+	path := writeLog(t)
+	log, err := internal.OpenFileEventLog(path)
+	require.NoError(t, err)
+	defer log.Close()
+	snapshot := filepath.Join(t.TempDir(), "snapshot.json")
+	state := internal.NewEventState()
+	require.NoError(t, internal.NewSnapshotter(log, internal.NewSnapshotStore(snapshot), state, time.Minute).Snapshot())
+
+	code, out, _ := runReplay("-output", "json", "-snapshot", snapshot, path)
+	assert.Equal(t, 0, code)
+	var result replayJSON
+	require.NoError(t, json.Unmarshal([]byte(out), &result))
+	assert.Empty(t, result.Events)
+	assert.Len(t, result.Quotes, 1)
+	assert.Len(t, result.Orders, 1)
+}
+
+func TestRun_Errors(t *testing.T) {
+	path := writeLog(t)
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"Missing log", nil, 2},
+		{"Unknown output", []string{"-output", "xml", path}, 2},
+		{"Unknown flag", []string{"-unknown", path}, 2},
+		{"Log not found", []string{"/does/not/exist.jsonl"}, 1},
+		{"Snapshot not readable", []string{"-snapshot", path, path}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, _ := runReplay(test.args...)
+			assert.Equal(t, test.code, code)
+		})
+	}
+}
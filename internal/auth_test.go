@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+)
+
+const testHMACSecret = "test-secret"
+
+func signHMAC(t *testing.T, claims jwt.Claims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testHMACSecret))
+	require.NoError(t, err)
+	return token
+}
+
+func customerToken(t *testing.T, customerId int32, expiresIn time.Duration) string {
+	return signHMAC(t, customerClaims{
+		CustomerId: customerId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "customer",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		},
+	})
+}
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	set := map[string][]map[string]string{
+		"keys": {{
+			"kid": kid,
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwksFile := writeJWKS(t, "key-1", &rsaKey.PublicKey)
+
+	signRSA := func(kid string, claims jwt.Claims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(rsaKey)
+		require.NoError(t, err)
+		return signed
+	}
+	valid := customerClaims{
+		CustomerId: 7,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-7",
+			Issuer:    "shop",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	noExpiry := valid
+	noExpiry.ExpiresAt = nil
+	otherIssuer := valid
+	otherIssuer.Issuer = "someone"
+	noSubject := valid
+	noSubject.Subject = ""
+
+	tests := []struct {
+		name        string
+		token       string
+		expectError bool
+	}{
+		{"HMAC", signHMAC(t, valid), false},
+		{"RSA", signRSA("key-1", valid), false},
+		{"RSA with unknown key", signRSA("key-2", valid), true},
+		{"Expired", signHMAC(t, expired), true},
+		{"Without expiry", signHMAC(t, noExpiry), true},
+		{"Other issuer", signHMAC(t, otherIssuer), true},
+		{"Without subject", signHMAC(t, noSubject), true},
+		{"Garbage", "not.a.token", true},
+	}
+
+	authenticator, err := NewAuthenticator(AuthConfig{Enabled: true, HMACSecret: testHMACSecret, JWKSFile: jwksFile, Issuer: "shop"})
+	require.NoError(t, err)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := authenticator.Authenticate(test.token)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, &Principal{Subject: "user-7", CustomerId: 7, Roles: []string{RoleCustomer}}, principal)
+		})
+	}
+}
+
+func TestAuthenticator_RejectsHMACWithoutSecret(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	authenticator, err := NewAuthenticator(AuthConfig{Enabled: true, JWKSFile: writeJWKS(t, "key-1", &rsaKey.PublicKey)})
+	require.NoError(t, err)
+
+	_, err = authenticator.Authenticate(customerToken(t, 1, time.Hour))
+	assert.Error(t, err)
+}
+
+func TestAuthenticator_UnaryServerInterceptor(t *testing.T) {
+	authenticator, err := NewAuthenticator(AuthConfig{Enabled: true, HMACSecret: testHMACSecret})
+	require.NoError(t, err)
+	interceptor := authenticator.UnaryServerInterceptor()
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		principal, _ := PrincipalFromContext(ctx)
+		return principal, nil
+	}
+	call := func(method string, authorization string) (interface{}, error) {
+		ctx := context.Background()
+		if authorization != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", authorization))
+		}
+		return interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+
+	principal, err := call("/sale.QuoteService/GetQuote", "Bearer "+customerToken(t, 3, time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), principal.(*Principal).CustomerId)
+
+	_, err = call("/sale.QuoteService/GetQuote", "")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = call("/sale.QuoteService/GetQuote", "Basic dXNlcjpwYXNz")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = call("/sale.QuoteService/GetQuote", "Bearer "+customerToken(t, 3, -time.Hour))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = call("/grpc.health.v1.Health/Check", "")
+	assert.NoError(t, err)
+}
+
+func TestAuthenticator_StreamServerInterceptor(t *testing.T) {
+	authenticator, err := NewAuthenticator(AuthConfig{Enabled: true, HMACSecret: testHMACSecret})
+	require.NoError(t, err)
+	interceptor := authenticator.StreamServerInterceptor()
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+customerToken(t, 5, time.Hour)))
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(ctx)
+
+	var principal *Principal
+	err = interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/sale.OrderService/PlaceOrder"}, func(srv interface{}, ss grpc.ServerStream) error {
+		principal, _ = PrincipalFromContext(ss.Context())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(5), principal.CustomerId)
+}
+
+func TestAuthorization_QuoteAndOrderServers(t *testing.T) {
+	ctx := ContextWithPrincipal(context.Background(), &Principal{Subject: "customer", CustomerId: 1, Roles: []string{RoleCustomer}})
+
+	quoteServer, _ := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	_, err := quoteServer.GetQuote(ctx, &pb.CustomerId{Id: 1})
+	assert.NoError(t, err)
+	_, err = quoteServer.GetQuote(ctx, &pb.CustomerId{Id: 2})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 2, ProductId: 101, Quantity: 1})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	orderServer := &OrderServer{
+		orders: map[int32]map[int32]*Order{
+			2: {1: {ID: 1, CustomerId: 2, Items: map[int32]*OrderItem{}}},
+		},
+		customerOrderMap: map[int32]int32{1: 2},
+		orderLock:        sync.RWMutex{},
+	}
+	_, err = orderServer.GetOrder(ctx, &pb.OrderId{Id: 1})
+	assert.EqualError(t, err, "order with id 1 not found")
+	_, err = orderServer.GetOrders(ctx, &pb.CustomerId{Id: 2})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(ctx)
+	err = orderServer.PlaceOrder(&pb.CustomerId{Id: 2}, stream)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	stream.AssertNotCalled(t, "Send", mock.Anything)
+}
@@ -1,10 +1,15 @@
 package internal
 
 import (
+	"context"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 
 	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/catalog"
 )
@@ -25,7 +30,7 @@ func TestCatalogClient_GetProductList(t *testing.T) {
 
 	mockClient.On("GetProductList", mock.Anything, &pb.Empty{}).Return(expectedProductList, nil)
 
-	productList, err := catalogClient.GetProductList()
+	productList, err := catalogClient.GetProductList(context.Background())
 	assert.Nil(t, err)
 	assert.Equal(t, expectedProductList, productList)
 	mockClient.AssertExpectations(t)
@@ -41,8 +46,29 @@ func TestCatalogClient_GetProductInfo(t *testing.T) {
 	expectedProduct := &pb.Product{Id: 1, Name: "Product1", Price: 100.0}
 	mockClient.On("GetProductInfo", mock.Anything, &pb.ProductId{Id: 1}).Return(expectedProduct, nil)
 
-	product, err := catalogClient.GetProductInfo(1)
+	product, err := catalogClient.GetProductInfo(context.Background(), 1)
 	assert.Nil(t, err)
 	assert.Equal(t, expectedProduct, product)
 	mockClient.AssertExpectations(t)
 }
+
+func TestCatalogClient_Ping(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := grpc.NewServer()
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	catalogClient := &CatalogClient{conn: conn, c: pb.NewProductInfoClient(conn)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, catalogClient.Ping(ctx))
+
+	assert.Nil(t, conn.Close())
+	assert.Error(t, catalogClient.Ping(ctx))
+}
@@ -2,43 +2,84 @@ package internal
 
 import (
 	"context"
-	"os"
+	"fmt"
 	"time"
 
 	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/catalog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 type CatalogClient struct {
-	conn *grpc.ClientConn
-	c    pb.ProductInfoClient
+	conn    *grpc.ClientConn
+	c       pb.ProductInfoClient
+	timeout time.Duration
 }
 
 type CatalogClientInterface interface {
-	GetProductList() (*pb.ProductList, error)
-	GetProductInfo(id uint64) (*pb.Product, error)
+	GetProductList(ctx context.Context) (*pb.ProductList, error)
+	GetProductInfo(ctx context.Context, id uint64) (*pb.Product, error)
 }
 
-func NewCatalogClient() (*CatalogClient, error) {
-	addr := os.Getenv("CATALOG_GRPC_SERVER")
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewCatalogClient connects to the catalog, options add to the transport credentials
+// derived from the config, e.g. interceptors.
+func NewCatalogClient(config CatalogConfig, options ...grpc.DialOption) (*CatalogClient, error) {
+	creds := insecure.NewCredentials()
+	if config.TLS.Enabled {
+		tlsConfig, err := NewClientTLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	options = append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, options...)
+	conn, err := grpc.NewClient(config.Address, options...)
 
 	if err != nil {
 		return nil, err
 	}
 	c := pb.NewProductInfoClient(conn)
-	return &CatalogClient{conn, c}, nil
+	return &CatalogClient{conn, c, config.Timeout}, nil
 }
 
-func (c *CatalogClient) GetProductList() (*pb.ProductList, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+// callContext limits a catalog call to the configured timeout, one second if none is set.
+// The call stays part of the trace of the parent context.
+func (c *CatalogClient) callContext(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+func (c *CatalogClient) GetProductList(ctx context.Context) (*pb.ProductList, error) {
+	ctx, cancel := c.callContext(ctx)
 	defer cancel()
 	return c.c.GetProductList(ctx, &pb.Empty{})
 }
 
-func (c *CatalogClient) GetProductInfo(id uint64) (*pb.Product, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func (c *CatalogClient) GetProductInfo(ctx context.Context, id uint64) (*pb.Product, error) {
+	ctx, cancel := c.callContext(ctx)
 	defer cancel()
 	return c.c.GetProductInfo(ctx, &pb.ProductId{Id: id})
 }
+
+// Ping waits until the connection to the catalog is ready or the context expires.
+func (c *CatalogClient) Ping(ctx context.Context) error {
+	c.conn.Connect()
+	for state := c.conn.GetState(); state != connectivity.Ready; state = c.conn.GetState() {
+		if state == connectivity.Shutdown {
+			return fmt.Errorf("catalog connection is closed")
+		}
+		if !c.conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("catalog connection is %s: %v", state, ctx.Err())
+		}
+	}
+	return nil
+}
+
+func (c *CatalogClient) Close() error {
+	return c.conn.Close()
+}
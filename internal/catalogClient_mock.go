@@ -19,7 +19,7 @@ func NewMockCatalogClient() *MockCatalogClient {
 }
 
 // GetProductList simulates fetching the product list
-func (m *MockCatalogClient) GetProductList() (*pb.ProductList, error) {
+func (m *MockCatalogClient) GetProductList(ctx context.Context) (*pb.ProductList, error) {
 	args := m.Called()
 	if args.Get(0) != nil {
 		return args.Get(0).(*pb.ProductList), args.Error(1)
@@ -28,7 +28,7 @@ func (m *MockCatalogClient) GetProductList() (*pb.ProductList, error) {
 }
 
 // GetProductInfo simulates fetching product information by product ID
-func (m *MockCatalogClient) GetProductInfo(id uint64) (*pb.Product, error) {
+func (m *MockCatalogClient) GetProductInfo(ctx context.Context, id uint64) (*pb.Product, error) {
 	args := m.Called(id)
 	if args.Get(0) != nil {
 		return args.Get(0).(*pb.Product), args.Error(1)
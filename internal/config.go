@@ -0,0 +1,621 @@
+package internal
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+	// ClientAuth makes the server require client certificates signed by CAFile.
+	ClientAuth bool `yaml:"client_auth"`
+	// ServerName overrides the name the client expects in the server certificate.
+	ServerName string `yaml:"server_name"`
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+type ServerConfig struct {
+	Port                int           `yaml:"port"`
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout"`
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	HealthCheckTimeout  time.Duration `yaml:"health_check_timeout"`
+	// RequestTimeout bounds unary calls and PlaceOrderTimeout checkout streams, callers
+	// may set earlier deadlines of their own.
+	RequestTimeout    time.Duration `yaml:"request_timeout"`
+	PlaceOrderTimeout time.Duration `yaml:"place_order_timeout"`
+	TLS               TLSConfig     `yaml:"tls"`
+	// Reflection lets tools like grpcurl discover the services, it requires auth like any other RPC.
+	Reflection bool `yaml:"reflection"`
+}
+
+type CatalogConfig struct {
+	Address string        `yaml:"address"`
+	Timeout time.Duration `yaml:"timeout"`
+	TLS     TLSConfig     `yaml:"tls"`
+}
+
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// HMACSecret verifies HS256/384/512 signed tokens.
+	HMACSecret string `yaml:"hmac_secret"`
+	// JWKSFile is a local JSON Web Key Set with the RSA keys of RS256/384/512 signed tokens.
+	JWKSFile string `yaml:"jwks_file"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
+
+type RBACConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Policies maps full gRPC method names to the roles allowed to call them.
+	// Entries from the file are merged into DefaultPolicies.
+	Policies map[string][]string `yaml:"policies"`
+}
+
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Address is where the HTTP server with the /metrics endpoint listens.
+	Address string `yaml:"address"`
+}
+
+type TracingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Exporter is "stdout" or "json_file". Both write one span per line as JSON in the
+	// format of the OpenTelemetry stdout exporter, which is not OTLP.
+	Exporter string `yaml:"exporter"`
+	// File is where the "json_file" exporter appends the spans.
+	File        string `yaml:"file"`
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the share of new traces that are recorded, from 0 to 1.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type LoggingConfig struct {
+	// Format is "text" or "json".
+	Format string `yaml:"format"`
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string `yaml:"level"`
+}
+
+type CORSConfig struct {
+	// AllowedOrigins lists the origins browsers may call the gateway from, "*" allows any.
+	AllowedOrigins []string      `yaml:"allowed_origins"`
+	AllowedHeaders []string      `yaml:"allowed_headers"`
+	MaxAge         time.Duration `yaml:"max_age"`
+}
+
+type GatewayConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Address is where the REST/JSON gateway listens.
+	Address string     `yaml:"address"`
+	CORS    CORSConfig `yaml:"cors"`
+}
+
+// EventLogConfig turns on the event log of the quote and order changes. The storages
+// are rebuilt from the snapshot and the log on start.
+type EventLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// File is where the events are appended as JSON lines, they are kept in memory when empty.
+	File string `yaml:"file"`
+	// SnapshotFile keeps the latest snapshot, taken every SnapshotInterval and on shutdown.
+	SnapshotFile     string        `yaml:"snapshot_file"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+}
+
+type StorageConfig struct {
+	Backend  string         `yaml:"backend"`
+	EventLog EventLogConfig `yaml:"event_log"`
+}
+
+type QuoteConfig struct {
+	// MaxLines limits the number of lines in a quote, 0 means no limit.
+	MaxLines int `yaml:"max_lines"`
+	// MaxLineQuantity limits the quantity of a single line, 0 means no limit.
+	MaxLineQuantity int32 `yaml:"max_line_quantity"`
+}
+
+type OrderConfig struct {
+	// StepDelay is the pause between the simulated checkout steps.
+	StepDelay time.Duration `yaml:"step_delay"`
+	// MaxCheckoutsPerCustomer limits the checkouts a customer runs at once, 0 means no limit.
+	MaxCheckoutsPerCustomer int `yaml:"max_checkouts_per_customer"`
+	// ProgressRetention is how long the progress of a finished checkout is kept for
+	// streaming, 0 keeps it forever.
+	ProgressRetention time.Duration `yaml:"progress_retention"`
+}
+
+type NATSConfig struct {
+	URL string `yaml:"url"`
+	// JetStream waits for the stream to store each message, core NATS only for the server to receive it.
+	JetStream bool `yaml:"jetstream"`
+	// Timeout bounds connecting and publishing a single message.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// OutboxConfig turns on publishing the order changes to other services. The messages
+// are stored with the changes and published by a relay, at least once and in order.
+type OutboxConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Publisher is "memory", "file" or "nats".
+	Publisher string `yaml:"publisher"`
+	// File is where the "file" publisher appends the messages as JSON lines.
+	File string     `yaml:"file"`
+	NATS NATSConfig `yaml:"nats"`
+	// CursorFile keeps the last message published from a file event log, so restarts
+	// continue after it.
+	CursorFile string `yaml:"cursor_file"`
+	// BatchSize is how many messages the relay takes from the outbox at once.
+	BatchSize int `yaml:"batch_size"`
+	// PollInterval is how often the relay looks for messages it was not woken up for.
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// A failed publish is retried after RetryInitial, doubled up to RetryMax.
+	RetryInitial time.Duration `yaml:"retry_initial"`
+	RetryMax     time.Duration `yaml:"retry_max"`
+}
+
+// RateLimit allows Rate calls per second on average and bursts of up to Burst calls.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Default applies to the methods without a limit of their own.
+	Default RateLimit `yaml:"default"`
+	// Methods maps full gRPC method names to their limits.
+	Methods map[string]RateLimit `yaml:"methods"`
+}
+
+// Config holds every tunable of the service. Values are taken from the defaults,
+// then the YAML file, then the environment and finally the command line flags,
+// each source overriding the previous one.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Catalog   CatalogConfig   `yaml:"catalog"`
+	Auth      AuthConfig      `yaml:"auth"`
+	RBAC      RBACConfig      `yaml:"rbac"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Gateway   GatewayConfig   `yaml:"gateway"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Quote     QuoteConfig     `yaml:"quote"`
+	Order     OrderConfig     `yaml:"order"`
+	Outbox    OutboxConfig    `yaml:"outbox"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:                50052,
+			ShutdownTimeout:     30 * time.Second,
+			HealthCheckInterval: 10 * time.Second,
+			HealthCheckTimeout:  2 * time.Second,
+			RequestTimeout:      10 * time.Second,
+			PlaceOrderTimeout:   time.Minute,
+			TLS: TLSConfig{
+				ReloadInterval: time.Minute,
+			},
+		},
+		Catalog: CatalogConfig{
+			Timeout: time.Second,
+			TLS: TLSConfig{
+				ReloadInterval: time.Minute,
+			},
+		},
+		RBAC: RBACConfig{
+			Policies: DefaultPolicies(),
+		},
+		Metrics: MetricsConfig{
+			Address: ":9090",
+		},
+		RateLimit: RateLimitConfig{
+			Default: RateLimit{Rate: 10, Burst: 20},
+			Methods: map[string]RateLimit{
+				"/sale.QuoteService/AddProduct": {Rate: 5, Burst: 10},
+				"/sale.OrderService/PlaceOrder": {Rate: 0.2, Burst: 3},
+			},
+		},
+		Tracing: TracingConfig{
+			Exporter:    "stdout",
+			ServiceName: "sale",
+			SampleRatio: 1,
+		},
+		Logging: LoggingConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		Gateway: GatewayConfig{
+			Address: ":8080",
+			CORS: CORSConfig{
+				AllowedHeaders: []string{"Authorization", "Content-Type", "X-Request-Id", "Last-Event-ID"},
+				MaxAge:         10 * time.Minute,
+			},
+		},
+		Storage: StorageConfig{
+			Backend: "memory",
+			EventLog: EventLogConfig{
+				SnapshotInterval: 5 * time.Minute,
+			},
+		},
+		Order: OrderConfig{
+			StepDelay:               2 * time.Second,
+			MaxCheckoutsPerCustomer: 2,
+			ProgressRetention:       10 * time.Minute,
+		},
+		Outbox: OutboxConfig{
+			Publisher: "memory",
+			NATS: NATSConfig{
+				URL:     "nats://localhost:4222",
+				Timeout: 5 * time.Second,
+			},
+			BatchSize:    100,
+			PollInterval: 5 * time.Second,
+			RetryInitial: 100 * time.Millisecond,
+			RetryMax:     time.Minute,
+		},
+	}
+}
+
+// LoadConfig builds the configuration from the command line arguments (without the
+// program name) and the environment looked up with getenv.
+func LoadConfig(args []string, getenv func(string) string) (*Config, error) {
+	flags := flag.NewFlagSet("sale", flag.ContinueOnError)
+	configFile := flags.String("config", "", "Path to the YAML configuration file")
+	port := flags.Int("port", 0, "The server port")
+	catalogAddress := flags.String("catalog-address", "", "Address of the catalog gRPC server")
+	storageBackend := flags.String("storage", "", "Storage backend")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 0, "How long in-flight checkouts may run on shutdown")
+	healthCheckInterval := flags.Duration("health-interval", 0, "How often the dependencies are checked")
+	healthCheckTimeout := flags.Duration("health-timeout", 0, "Timeout of a single dependency check")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+
+	path := *configFile
+	if path == "" {
+		path = getenv("SALE_CONFIG")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+		}
+	}
+
+	if err := config.applyEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			config.Server.Port = *port
+		case "catalog-address":
+			config.Catalog.Address = *catalogAddress
+		case "storage":
+			config.Storage.Backend = *storageBackend
+		case "shutdown-timeout":
+			config.Server.ShutdownTimeout = *shutdownTimeout
+		case "health-interval":
+			config.Server.HealthCheckInterval = *healthCheckInterval
+		case "health-timeout":
+			config.Server.HealthCheckTimeout = *healthCheckTimeout
+		}
+	})
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) applyEnv(getenv func(string) string) error {
+	if value := getenv("SALE_PORT"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid SALE_PORT %q: %v", value, err)
+		}
+		c.Server.Port = port
+	}
+
+	texts := []struct {
+		name   string
+		target *string
+	}{
+		{"CATALOG_GRPC_SERVER", &c.Catalog.Address},
+		{"SALE_STORAGE_BACKEND", &c.Storage.Backend},
+		{"SALE_TLS_CERT_FILE", &c.Server.TLS.CertFile},
+		{"SALE_TLS_KEY_FILE", &c.Server.TLS.KeyFile},
+		{"SALE_TLS_CA_FILE", &c.Server.TLS.CAFile},
+		{"SALE_CATALOG_TLS_CERT_FILE", &c.Catalog.TLS.CertFile},
+		{"SALE_CATALOG_TLS_KEY_FILE", &c.Catalog.TLS.KeyFile},
+		{"SALE_CATALOG_TLS_CA_FILE", &c.Catalog.TLS.CAFile},
+		{"SALE_AUTH_HMAC_SECRET", &c.Auth.HMACSecret},
+		{"SALE_AUTH_JWKS_FILE", &c.Auth.JWKSFile},
+		{"SALE_AUTH_ISSUER", &c.Auth.Issuer},
+		{"SALE_AUTH_AUDIENCE", &c.Auth.Audience},
+		{"SALE_METRICS_ADDRESS", &c.Metrics.Address},
+		{"SALE_TRACING_EXPORTER", &c.Tracing.Exporter},
+		{"SALE_TRACING_FILE", &c.Tracing.File},
+		{"SALE_LOG_FORMAT", &c.Logging.Format},
+		{"SALE_LOG_LEVEL", &c.Logging.Level},
+		{"SALE_GATEWAY_ADDRESS", &c.Gateway.Address},
+		{"SALE_EVENT_LOG_FILE", &c.Storage.EventLog.File},
+		{"SALE_EVENT_SNAPSHOT_FILE", &c.Storage.EventLog.SnapshotFile},
+		{"SALE_OUTBOX_PUBLISHER", &c.Outbox.Publisher},
+		{"SALE_OUTBOX_FILE", &c.Outbox.File},
+		{"SALE_OUTBOX_CURSOR_FILE", &c.Outbox.CursorFile},
+		{"SALE_NATS_URL", &c.Outbox.NATS.URL},
+	}
+	for _, t := range texts {
+		if value := getenv(t.name); value != "" {
+			*t.target = value
+		}
+	}
+
+	bools := []struct {
+		name   string
+		target *bool
+	}{
+		{"SALE_REFLECTION_ENABLED", &c.Server.Reflection},
+		{"SALE_TLS_ENABLED", &c.Server.TLS.Enabled},
+		{"SALE_TLS_CLIENT_AUTH", &c.Server.TLS.ClientAuth},
+		{"SALE_CATALOG_TLS_ENABLED", &c.Catalog.TLS.Enabled},
+		{"SALE_AUTH_ENABLED", &c.Auth.Enabled},
+		{"SALE_RBAC_ENABLED", &c.RBAC.Enabled},
+		{"SALE_METRICS_ENABLED", &c.Metrics.Enabled},
+		{"SALE_RATE_LIMIT_ENABLED", &c.RateLimit.Enabled},
+		{"SALE_TRACING_ENABLED", &c.Tracing.Enabled},
+		{"SALE_GATEWAY_ENABLED", &c.Gateway.Enabled},
+		{"SALE_EVENT_LOG_ENABLED", &c.Storage.EventLog.Enabled},
+		{"SALE_OUTBOX_ENABLED", &c.Outbox.Enabled},
+		{"SALE_NATS_JETSTREAM", &c.Outbox.NATS.JetStream},
+	}
+	for _, b := range bools {
+		value := getenv(b.name)
+		if value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", b.name, value, err)
+		}
+		*b.target = enabled
+	}
+
+	if value := getenv("SALE_GATEWAY_CORS_ORIGINS"); value != "" {
+		c.Gateway.CORS.AllowedOrigins = strings.Split(value, ",")
+	}
+
+	durations := []struct {
+		name   string
+		target *time.Duration
+	}{
+		{"SALE_SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout},
+		{"SALE_HEALTH_CHECK_INTERVAL", &c.Server.HealthCheckInterval},
+		{"SALE_HEALTH_CHECK_TIMEOUT", &c.Server.HealthCheckTimeout},
+		{"SALE_REQUEST_TIMEOUT", &c.Server.RequestTimeout},
+		{"SALE_PLACE_ORDER_TIMEOUT", &c.Server.PlaceOrderTimeout},
+		{"SALE_CATALOG_TIMEOUT", &c.Catalog.Timeout},
+		{"SALE_ORDER_STEP_DELAY", &c.Order.StepDelay},
+		{"SALE_ORDER_PROGRESS_RETENTION", &c.Order.ProgressRetention},
+		{"SALE_EVENT_SNAPSHOT_INTERVAL", &c.Storage.EventLog.SnapshotInterval},
+		{"SALE_OUTBOX_POLL_INTERVAL", &c.Outbox.PollInterval},
+		{"SALE_NATS_TIMEOUT", &c.Outbox.NATS.Timeout},
+	}
+	for _, d := range durations {
+		value := getenv(d.name)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", d.name, value, err)
+		}
+		*d.target = duration
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("invalid server port %d", c.Server.Port)
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("server shutdown timeout must be positive")
+	}
+	if c.Server.HealthCheckInterval <= 0 || c.Server.HealthCheckTimeout <= 0 {
+		return fmt.Errorf("health check interval and timeout must be positive")
+	}
+	if c.Server.RequestTimeout <= 0 || c.Server.PlaceOrderTimeout <= 0 {
+		return fmt.Errorf("request and place order timeouts must be positive")
+	}
+	if c.Catalog.Address == "" {
+		return fmt.Errorf("catalog address is not set")
+	}
+	if c.Catalog.Timeout <= 0 {
+		return fmt.Errorf("catalog timeout must be positive")
+	}
+	if err := c.Server.TLS.validate("server", true); err != nil {
+		return err
+	}
+	if err := c.Catalog.TLS.validate("catalog", false); err != nil {
+		return err
+	}
+	if c.Auth.Enabled && c.Auth.HMACSecret == "" && c.Auth.JWKSFile == "" {
+		return fmt.Errorf("authentication needs an HMAC secret or a JWKS file")
+	}
+	if c.RBAC.Enabled && !c.Auth.Enabled {
+		return fmt.Errorf("role based access control needs authentication")
+	}
+	for method, roles := range c.RBAC.Policies {
+		for _, role := range roles {
+			if !knownRoles[role] {
+				return fmt.Errorf("unknown role %q in policy of %s", role, method)
+			}
+		}
+	}
+	if c.Metrics.Enabled && c.Metrics.Address == "" {
+		return fmt.Errorf("metrics address is not set")
+	}
+	if err := c.RateLimit.validate(); err != nil {
+		return err
+	}
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+	if c.Gateway.Enabled && c.Gateway.Address == "" {
+		return fmt.Errorf("gateway address is not set")
+	}
+	if _, err := NewLogger(c.Logging, io.Discard); err != nil {
+		return err
+	}
+	if c.Storage.Backend != "memory" {
+		return fmt.Errorf("unknown storage backend %q", c.Storage.Backend)
+	}
+	if err := c.Storage.EventLog.validate(); err != nil {
+		return err
+	}
+	if err := c.Outbox.validate(c.Storage.EventLog); err != nil {
+		return err
+	}
+	if c.Quote.MaxLines < 0 || c.Quote.MaxLineQuantity < 0 {
+		return fmt.Errorf("quote limits must not be negative")
+	}
+	if c.Order.StepDelay < 0 {
+		return fmt.Errorf("order step delay must not be negative")
+	}
+	if c.Server.PlaceOrderTimeout <= checkoutSteps*c.Order.StepDelay {
+		return fmt.Errorf("place order timeout %s is too short for %d checkout steps of %s", c.Server.PlaceOrderTimeout, checkoutSteps, c.Order.StepDelay)
+	}
+	if c.Order.MaxCheckoutsPerCustomer < 0 {
+		return fmt.Errorf("max checkouts per customer must not be negative")
+	}
+	if c.Order.ProgressRetention < 0 {
+		return fmt.Errorf("order progress retention must not be negative")
+	}
+	return nil
+}
+
+func (t TLSConfig) validate(name string, server bool) error {
+	if !t.Enabled {
+		return nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("%s TLS needs both a certificate and a key file", name)
+	}
+	if server && t.CertFile == "" {
+		return fmt.Errorf("%s TLS needs a certificate and a key file", name)
+	}
+	if t.ClientAuth && t.CAFile == "" {
+		return fmt.Errorf("%s TLS client authentication needs a CA file", name)
+	}
+	if t.ReloadInterval <= 0 {
+		return fmt.Errorf("%s TLS reload interval must be positive", name)
+	}
+	return nil
+}
+
+func (r RateLimitConfig) validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	if r.Default.Rate <= 0 || r.Default.Burst < 1 {
+		return fmt.Errorf("default rate limit needs a positive rate and burst")
+	}
+	for method, limit := range r.Methods {
+		if limit.Rate <= 0 || limit.Burst < 1 {
+			return fmt.Errorf("rate limit of %s needs a positive rate and burst", method)
+		}
+	}
+	return nil
+}
+
+func (t TracingConfig) validate() error {
+	if !t.Enabled {
+		return nil
+	}
+	switch t.Exporter {
+	case "stdout":
+	case "json_file":
+		if t.File == "" {
+			return fmt.Errorf("json_file trace exporter needs a file")
+		}
+	default:
+		return fmt.Errorf("unknown trace exporter %q", t.Exporter)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return fmt.Errorf("trace sample ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+func (e EventLogConfig) validate() error {
+	if !e.Enabled {
+		return nil
+	}
+	if e.SnapshotFile != "" && e.File == "" {
+		return fmt.Errorf("event snapshots need an event log file")
+	}
+	if e.SnapshotInterval <= 0 {
+		return fmt.Errorf("event snapshot interval must be positive")
+	}
+	return nil
+}
+
+func (o OutboxConfig) validate(eventLog EventLogConfig) error {
+	if !o.Enabled {
+		return nil
+	}
+	switch o.Publisher {
+	case "memory":
+	case "file":
+		if o.File == "" {
+			return fmt.Errorf("file outbox publisher needs a file")
+		}
+	case "nats":
+		if o.NATS.URL == "" {
+			return fmt.Errorf("nats outbox publisher needs a server URL")
+		}
+		if o.NATS.Timeout <= 0 {
+			return fmt.Errorf("nats timeout must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown outbox publisher %q", o.Publisher)
+	}
+	fileLog := eventLog.Enabled && eventLog.File != ""
+	if o.CursorFile != "" && !fileLog {
+		return fmt.Errorf("outbox cursor file needs an event log file")
+	}
+	if fileLog && o.CursorFile == "" {
+		return fmt.Errorf("outbox of an event log file needs a cursor file")
+	}
+	if o.BatchSize <= 0 {
+		return fmt.Errorf("outbox batch size must be positive")
+	}
+	if o.PollInterval <= 0 || o.RetryInitial <= 0 {
+		return fmt.Errorf("outbox poll interval and retry delay must be positive")
+	}
+	if o.RetryMax < o.RetryInitial {
+		return fmt.Errorf("outbox maximum retry delay %s is shorter than the initial one %s", o.RetryMax, o.RetryInitial)
+	}
+	return nil
+}
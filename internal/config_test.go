@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func envMap(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	config, err := LoadConfig(nil, envMap(map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051"}))
+	assert.NoError(t, err)
+
+	expected := DefaultConfig()
+	expected.Catalog.Address = "catalog:50051"
+	assert.Equal(t, &expected, config)
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  port: 6000
+  shutdown_timeout: 5s
+catalog:
+  address: file:50051
+  timeout: 3s
+quote:
+  max_lines: 10
+order:
+  step_delay: 100ms
+`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		port     int
+		address  string
+		shutdown time.Duration
+	}{
+		{"File only", []string{"-config", path}, map[string]string{}, 6000, "file:50051", 5 * time.Second},
+		{"Config path from env", nil, map[string]string{"SALE_CONFIG": path}, 6000, "file:50051", 5 * time.Second},
+		{
+			"Env overrides file",
+			[]string{"-config", path},
+			map[string]string{"SALE_PORT": "7000", "CATALOG_GRPC_SERVER": "env:50051", "SALE_SHUTDOWN_TIMEOUT": "1m"},
+			7000, "env:50051", time.Minute,
+		},
+		{
+			"Flags override env",
+			[]string{"-config", path, "-port", "8000", "-catalog-address", "flag:50051", "-shutdown-timeout", "2s"},
+			map[string]string{"SALE_PORT": "7000", "CATALOG_GRPC_SERVER": "env:50051"},
+			8000, "flag:50051", 2 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := LoadConfig(test.args, envMap(test.env))
+			assert.NoError(t, err)
+			assert.Equal(t, test.port, config.Server.Port)
+			assert.Equal(t, test.address, config.Catalog.Address)
+			assert.Equal(t, test.shutdown, config.Server.ShutdownTimeout)
+			assert.Equal(t, 3*time.Second, config.Catalog.Timeout)
+			assert.Equal(t, 10, config.Quote.MaxLines)
+			assert.Equal(t, 100*time.Millisecond, config.Order.StepDelay)
+			assert.Equal(t, 10*time.Second, config.Server.HealthCheckInterval)
+		})
+	}
+}
+
+func TestLoadConfig_TLSFromEnv(t *testing.T) {
+	config, err := LoadConfig(nil, envMap(map[string]string{
+		"CATALOG_GRPC_SERVER":      "catalog:50051",
+		"SALE_TLS_ENABLED":         "true",
+		"SALE_TLS_CERT_FILE":       "server.pem",
+		"SALE_TLS_KEY_FILE":        "server-key.pem",
+		"SALE_TLS_CA_FILE":         "ca.pem",
+		"SALE_TLS_CLIENT_AUTH":     "true",
+		"SALE_CATALOG_TLS_ENABLED": "true",
+		"SALE_CATALOG_TLS_CA_FILE": "catalog-ca.pem",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, TLSConfig{
+		Enabled:        true,
+		CertFile:       "server.pem",
+		KeyFile:        "server-key.pem",
+		CAFile:         "ca.pem",
+		ClientAuth:     true,
+		ReloadInterval: time.Minute,
+	}, config.Server.TLS)
+	assert.True(t, config.Catalog.TLS.Enabled)
+	assert.Equal(t, "catalog-ca.pem", config.Catalog.TLS.CAFile)
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	catalog := map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051"}
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"Missing catalog address", nil, map[string]string{}},
+		{"Missing config file", []string{"-config", "/does/not/exist.yaml"}, catalog},
+		{"Invalid file", []string{"-config", writeConfigFile(t, "server: [")}, catalog},
+		{"Unknown flag", []string{"-unknown"}, catalog},
+		{"Invalid env port", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_PORT": "port"}},
+		{"Invalid env duration", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_CATALOG_TIMEOUT": "soon"}},
+		{"Port out of range", []string{"-port", "70000"}, catalog},
+		{"Unknown storage backend", []string{"-storage", "redis"}, catalog},
+		{"Negative limit", []string{"-config", writeConfigFile(t, "quote:\n  max_lines: -1\n")}, catalog},
+		{"Non-positive timeout", []string{"-health-timeout", "0s"}, catalog},
+		{"Server TLS without certificate", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_TLS_ENABLED": "true"}},
+		{
+			"Client auth without CA",
+			nil,
+			map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_TLS_ENABLED": "true", "SALE_TLS_CERT_FILE": "cert.pem", "SALE_TLS_KEY_FILE": "key.pem", "SALE_TLS_CLIENT_AUTH": "true"},
+		},
+		{"Catalog TLS certificate without key", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_CATALOG_TLS_ENABLED": "true", "SALE_CATALOG_TLS_CERT_FILE": "cert.pem"}},
+		{"Invalid env bool", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_TLS_ENABLED": "sure"}},
+		{"JSON file trace exporter without file", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_TRACING_ENABLED": "true", "SALE_TRACING_EXPORTER": "json_file"}},
+		{"Unknown trace exporter", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_TRACING_ENABLED": "true", "SALE_TRACING_EXPORTER": "zipkin"}},
+		{"Unknown log format", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_LOG_FORMAT": "xml"}},
+		{"RBAC without auth", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_RBAC_ENABLED": "true"}},
+		{"Unknown role", []string{"-config", writeConfigFile(t, "rbac:\n  policies:\n    /sale.QuoteService/GetQuote: [guest]\n")}, catalog},
+		{"Rate limit without burst", []string{"-config", writeConfigFile(t, "rate_limit:\n  enabled: true\n  methods:\n    /sale.QuoteService/GetQuote: {rate: 1}\n")}, catalog},
+		{"Non-positive request timeout", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_REQUEST_TIMEOUT": "0s"}},
+		{"Place order timeout shorter than checkout", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_PLACE_ORDER_TIMEOUT": "5s"}},
+		{"Negative checkout limit", []string{"-config", writeConfigFile(t, "order:\n  max_checkouts_per_customer: -1\n")}, catalog},
+		{"Negative progress retention", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_ORDER_PROGRESS_RETENTION": "-1m"}},
+		{"Event snapshots without log file", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_EVENT_LOG_ENABLED": "true", "SALE_EVENT_SNAPSHOT_FILE": "snapshot.json"}},
+		{"Unknown outbox publisher", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_OUTBOX_ENABLED": "true", "SALE_OUTBOX_PUBLISHER": "kafka"}},
+		{"File outbox publisher without file", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_OUTBOX_ENABLED": "true", "SALE_OUTBOX_PUBLISHER": "file"}},
+		{
+			"Outbox of event log file without cursor",
+			nil,
+			map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_OUTBOX_ENABLED": "true", "SALE_EVENT_LOG_ENABLED": "true", "SALE_EVENT_LOG_FILE": "events.jsonl"},
+		},
+		{"Outbox cursor without event log", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_OUTBOX_ENABLED": "true", "SALE_OUTBOX_CURSOR_FILE": "cursor"}},
+		{"Outbox retry delays reversed", []string{"-config", writeConfigFile(t, "outbox:\n  enabled: true\n  retry_initial: 1m\n  retry_max: 1s\n")}, catalog},
+		{"Non-positive snapshot interval", nil, map[string]string{"CATALOG_GRPC_SERVER": "catalog:50051", "SALE_EVENT_LOG_ENABLED": "true", "SALE_EVENT_SNAPSHOT_INTERVAL": "0s"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadConfig(test.args, envMap(test.env))
+			assert.Error(t, err)
+		})
+	}
+}
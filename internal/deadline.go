@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Deadlines bounds how long the server works on a call. Unary calls get the request
+// timeout and checkout streams the place order timeout, unless the caller has set an
+// earlier deadline. Other streams, like health watches, are meant to stay open.
+type Deadlines struct {
+	request time.Duration
+	streams map[string]time.Duration
+}
+
+func NewDeadlines(config ServerConfig) *Deadlines {
+	return &Deadlines{
+		request: config.RequestTimeout,
+		streams: map[string]time.Duration{
+			"/sale.OrderService/PlaceOrder": config.PlaceOrderTimeout,
+		},
+	}
+}
+
+// timeout returns the timeout of a call of the method, false for streams that stay open.
+// The gateway applies it to the REST routes too, a nil *Deadlines sets none.
+func (d *Deadlines) timeout(method string, stream bool) (time.Duration, bool) {
+	if d == nil {
+		return 0, false
+	}
+	if !stream {
+		return d.request, true
+	}
+	timeout, exists := d.streams[method]
+	return timeout, exists
+}
+
+func (d *Deadlines) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		timeout, _ := d.timeout(info.FullMethod, false)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+func (d *Deadlines) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		timeout, exists := d.timeout(info.FullMethod, true)
+		if !exists {
+			return handler(srv, ss)
+		}
+		ctx, cancel := context.WithTimeout(ss.Context(), timeout)
+		defer cancel()
+		return handler(srv, &contextServerStream{ss, ctx})
+	}
+}
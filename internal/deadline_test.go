@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func remaining(t *testing.T, ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	return time.Until(deadline)
+}
+
+func TestDeadlines_UnaryServerInterceptor(t *testing.T) {
+	interceptor := NewDeadlines(ServerConfig{RequestTimeout: time.Minute}).UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/sale.QuoteService/GetQuote"}
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		expected time.Duration
+	}{
+		{"Default deadline", 0, time.Minute},
+		{"Earlier deadline of the caller", time.Second, time.Second},
+		{"Later deadline of the caller", time.Hour, time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				assert.InDelta(t, test.expected, remaining(t, ctx), float64(time.Second))
+				return nil, nil
+			})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestDeadlines_StreamServerInterceptor(t *testing.T) {
+	interceptor := NewDeadlines(ServerConfig{PlaceOrderTimeout: time.Minute}).StreamServerInterceptor()
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(context.Background())
+
+	err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/sale.OrderService/PlaceOrder"}, func(srv interface{}, ss grpc.ServerStream) error {
+		assert.InDelta(t, time.Minute, remaining(t, ss.Context()), float64(time.Second))
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/grpc.health.v1.Health/Watch"}, func(srv interface{}, ss grpc.ServerStream) error {
+		_, ok := ss.Context().Deadline()
+		assert.False(t, ok)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestGateway_Deadlines(t *testing.T) {
+	deadlines := NewDeadlines(ServerConfig{RequestTimeout: time.Minute, PlaceOrderTimeout: time.Hour})
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	gateway := NewGateway(DefaultConfig().Gateway, quoteServer, orderServer, NewReturnServer(orderServer), nil, nil, nil, deadlines)
+
+	tests := []struct {
+		name     string
+		policy   string
+		stream   bool
+		expected time.Duration
+	}{
+		{"Unary route", "/sale.QuoteService/GetQuote", false, time.Minute},
+		{"Place order", "/sale.OrderService/PlaceOrder", true, time.Hour},
+		{"Event stream", "/sale.OrderService/GetOrder", true, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			handler := gateway.wrap(route{"GET /v1/test", test.policy, test.stream, func(w http.ResponseWriter, r *http.Request) error {
+				called = true
+				if test.expected == 0 {
+					_, ok := r.Context().Deadline()
+					assert.False(t, ok)
+				} else {
+					assert.InDelta(t, test.expected, remaining(t, r.Context()), float64(time.Second))
+				}
+				return nil
+			}})
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/test", nil))
+			assert.True(t, called)
+		})
+	}
+}
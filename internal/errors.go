@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errorDomain is reported in the ErrorInfo details of every domain error.
+const errorDomain = "sale"
+
+type ErrorKind int
+
+const (
+	KindNotFound ErrorKind = iota + 1
+	KindInvalidArgument
+	KindFailedPrecondition
+	KindUnavailable
+	KindResourceExhausted
+	KindInternal
+)
+
+// errorCodes maps the kinds of domain errors to gRPC status codes.
+var errorCodes = map[ErrorKind]codes.Code{
+	KindNotFound:           codes.NotFound,
+	KindInvalidArgument:    codes.InvalidArgument,
+	KindFailedPrecondition: codes.FailedPrecondition,
+	KindUnavailable:        codes.Unavailable,
+	KindResourceExhausted:  codes.ResourceExhausted,
+	KindInternal:           codes.Internal,
+}
+
+// Error is a domain error. Reason is a stable UPPER_SNAKE_CASE identifier clients can
+// match on, Field names the offending request field of InvalidArgument errors and
+// RetryAfter tells clients of ResourceExhausted errors when to try again.
+type Error struct {
+	Kind       ErrorKind
+	Reason     string
+	Field      string
+	Message    string
+	Metadata   map[string]string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus is the mapping of domain errors to gRPC statuses, grpc-go calls it for
+// every error returned by a handler, even when it is wrapped.
+func (e *Error) GRPCStatus() *status.Status {
+	code, exists := errorCodes[e.Kind]
+	if !exists {
+		code = codes.Unknown
+	}
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: e.Reason, Domain: errorDomain, Metadata: e.Metadata}}
+	if e.Field != "" {
+		details = append(details, &errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: e.Field, Description: e.Message}},
+		})
+	}
+	if e.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)})
+	}
+	st := status.New(code, e.Error())
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+func NotFound(reason string, format string, args ...interface{}) *Error {
+	return &Error{Kind: KindNotFound, Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+func InvalidArgument(field string, reason string, format string, args ...interface{}) *Error {
+	return &Error{Kind: KindInvalidArgument, Reason: reason, Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+func FailedPrecondition(reason string, format string, args ...interface{}) *Error {
+	return &Error{Kind: KindFailedPrecondition, Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// Unavailable reports a failing dependency, err is the cause.
+func Unavailable(reason string, err error, format string, args ...interface{}) *Error {
+	return &Error{Kind: KindUnavailable, Reason: reason, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// ResourceExhausted reports a caller over its limits, it may try again after retryAfter.
+func ResourceExhausted(reason string, retryAfter time.Duration, format string, args ...interface{}) *Error {
+	return &Error{Kind: KindResourceExhausted, Reason: reason, Message: fmt.Sprintf(format, args...), RetryAfter: retryAfter}
+}
+
+// Internal reports a bug of the service. The message is returned to callers, so it
+// must not carry the details of the failure, those belong in the log.
+func Internal(reason string, format string, args ...interface{}) *Error {
+	return &Error{Kind: KindInternal, Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// retryAfterMetadata is the retry-after header of a ResourceExhausted error, in whole
+// seconds rounded up. It is empty for other errors.
+func retryAfterMetadata(err error) metadata.MD {
+	var domainErr *Error
+	if !errors.As(err, &domainErr) || domainErr.RetryAfter <= 0 {
+		return nil
+	}
+	seconds := int64(math.Ceil(domainErr.RetryAfter.Seconds()))
+	return metadata.Pairs("retry-after", strconv.FormatInt(seconds, 10))
+}
+
+// IsKind reports whether err is, or wraps, a domain error of the kind.
+func IsKind(err error, kind ErrorKind) bool {
+	var domainErr *Error
+	return errors.As(err, &domainErr) && domainErr.Kind == kind
+}
+
+// catalogError reports a failed product lookup. A product unknown to the catalog is
+// the caller's mistake, every other failure means the catalog is unavailable.
+func catalogError(productId int32, err error) *Error {
+	if status.Code(err) == codes.NotFound {
+		return NotFound("PRODUCT_NOT_FOUND", "product %d not found", productId)
+	}
+	return Unavailable("CATALOG_UNAVAILABLE", err, "failed to get product info for product %d", productId)
+}
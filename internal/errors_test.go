@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func errorInfo(t *testing.T, err error) *errdetails.ErrorInfo {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	t.Fatalf("no ErrorInfo in %v", err)
+	return nil
+}
+
+func TestError_GRPCStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		code     codes.Code
+		reason   string
+		message  string
+		badField string
+	}{
+		{"Not found", NotFound("ORDER_NOT_FOUND", "order with id %d not found", 3), codes.NotFound, "ORDER_NOT_FOUND", "order with id 3 not found", ""},
+		{"Invalid argument", InvalidArgument("cursor", "INVALID_CURSOR", "invalid cursor"), codes.InvalidArgument, "INVALID_CURSOR", "invalid cursor", "cursor"},
+		{"Failed precondition", FailedPrecondition("QUOTE_EMPTY", "quote is empty"), codes.FailedPrecondition, "QUOTE_EMPTY", "quote is empty", ""},
+		{"Unavailable", Unavailable("CATALOG_UNAVAILABLE", errors.New("timeout"), "catalog down"), codes.Unavailable, "CATALOG_UNAVAILABLE", "catalog down: timeout", ""},
+		{"Resource exhausted", ResourceExhausted("RATE_LIMITED", time.Second, "slow down"), codes.ResourceExhausted, "RATE_LIMITED", "slow down", ""},
+		{"Internal", Internal("PANIC", "internal error"), codes.Internal, "PANIC", "internal error", ""},
+		{"Wrapped", fmt.Errorf("checkout: %w", NotFound("QUOTE_NOT_FOUND", "quote not found")), codes.NotFound, "QUOTE_NOT_FOUND", "checkout: quote not found", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st := status.Convert(test.err)
+			assert.Equal(t, test.code, st.Code())
+			assert.Equal(t, test.message, st.Message())
+
+			info := errorInfo(t, test.err)
+			assert.Equal(t, test.reason, info.Reason)
+			assert.Equal(t, "sale", info.Domain)
+
+			var badRequest *errdetails.BadRequest
+			for _, detail := range st.Details() {
+				if br, ok := detail.(*errdetails.BadRequest); ok {
+					badRequest = br
+				}
+			}
+			if test.badField == "" {
+				assert.Nil(t, badRequest)
+			} else {
+				assert.Equal(t, test.badField, badRequest.FieldViolations[0].Field)
+			}
+		})
+	}
+}
+
+func TestRetryAfterMetadata(t *testing.T) {
+	assert.Equal(t, metadata.Pairs("retry-after", "2"), retryAfterMetadata(ResourceExhausted("RATE_LIMITED", 1100*time.Millisecond, "slow down")))
+	assert.Equal(t, metadata.Pairs("retry-after", "1"), retryAfterMetadata(fmt.Errorf("wrapped: %w", ResourceExhausted("RATE_LIMITED", 10*time.Millisecond, "slow down"))))
+	assert.Nil(t, retryAfterMetadata(NotFound("ORDER_NOT_FOUND", "not found")))
+}
+
+func TestError_IsKind(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", FailedPrecondition("QUOTE_EMPTY", "quote is empty"))
+	assert.True(t, IsKind(err, KindFailedPrecondition))
+	assert.False(t, IsKind(err, KindNotFound))
+	assert.False(t, IsKind(errors.New("plain"), KindNotFound))
+}
+
+func TestPriceChangedError_GRPCStatus(t *testing.T) {
+	err := &PriceChangedError{Changes: []PriceChange{
+		{LineID: 1, ProductID: 101, OldPrice: 10, NewPrice: 15},
+		{LineID: 2, ProductID: 101, OldPrice: 12, NewPrice: 11.5},
+	}, Token: "abc"}
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	info := errorInfo(t, err)
+	assert.Equal(t, "PRICE_CHANGED", info.Reason)
+	assert.Equal(t, map[string]string{"line_1": "10.00 -> 15.00", "line_2": "12.00 -> 11.50", "price_token": "abc"}, info.Metadata)
+}
+
+func TestCatalogError(t *testing.T) {
+	assert.Equal(t, codes.NotFound, status.Code(catalogError(1, status.Error(codes.NotFound, "no such product"))))
+	assert.Equal(t, codes.Unavailable, status.Code(catalogError(1, status.Error(codes.DeadlineExceeded, "slow"))))
+}
+
+func TestServers_ErrorCodes(t *testing.T) {
+	quoteServer, _ := NewQuoteServer(QuoteConfig{MaxLineQuantity: 2}, newPriceCatalogClient(10.0), nil)
+	_, err := quoteServer.RemoveProduct(context.Background(), &pb.ProductRequest{CustomerId: 1, ProductId: 101})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = quoteServer.AddProduct(context.Background(), &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 3})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	orderServer := &OrderServer{orders: map[int32]map[int32]*Order{}, orderLock: sync.RWMutex{}}
+	_, err = orderServer.GetOrder(context.Background(), &pb.OrderId{Id: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = orderServer.GetOrders(context.Background(), &pb.CustomerId{Id: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	orderServer = NewOrderServer(OrderConfig{}, &QuoteStorage{quotes: map[int32]*Quote{}}, nil, nil)
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Send", mock.Anything).Return(nil)
+	stream.On("Context").Return(context.Background())
+	err = orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	stream.AssertCalled(t, "Send", &pb.ProcessStatus{Status: pb.OrderStatus_ERROR, Message: "quote is empty"})
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// eventIndexInterval is the number of events between the offsets kept in the index.
+const eventIndexInterval = 256
+
+// FileEventLog appends the events to a file as JSON lines. Every Append is synced to
+// disk before it returns, a line torn by a crash is dropped when the file is opened.
+type FileEventLog struct {
+	path     string
+	file     *os.File
+	sequence int64
+	// size is the length of the complete lines, Replay does not read past it.
+	size int64
+	// index holds the offset of every eventIndexInterval-th line, index[i] is where the
+	// event i*eventIndexInterval+1 starts. Sequence numbers are line numbers, so Replay
+	// seeks close to the events it is asked for instead of reading the whole file.
+	index []int64
+	// closed is set by Close, failed holds the error of the last append while it failed.
+	closed bool
+	failed error
+	lock   sync.RWMutex
+}
+
+func OpenFileEventLog(path string) (*FileEventLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening event log: %v", err)
+	}
+	l := &FileEventLog{path: path, file: file, index: []int64{0}}
+	err = ReadEvents(file, func(event Event, end int64) error {
+		l.sequence = event.Sequence
+		l.size = end
+		l.indexEvent(event.Sequence, end)
+		return nil
+	})
+	if err == nil {
+		err = file.Truncate(l.size)
+	}
+	if err == nil {
+		_, err = file.Seek(l.size, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("error reading event log %s: %v", path, err)
+	}
+	return l, nil
+}
+
+// OpenEventLog opens the event log of the configuration, in memory when it has no file.
+func OpenEventLog(config EventLogConfig) (EventLog, error) {
+	if config.File == "" {
+		return NewMemoryEventLog(), nil
+	}
+	return OpenFileEventLog(config.File)
+}
+
+func (l *FileEventLog) Append(events ...*Event) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.closed {
+		return errEventLogClosed
+	}
+	var buf bytes.Buffer
+	ends := make([]int64, len(events))
+	for i, event := range events {
+		event.Sequence = l.sequence + int64(i) + 1
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		ends[i] = l.size + int64(buf.Len())
+	}
+	_, err := l.file.Write(buf.Bytes())
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		// Drop whatever part of the events made it to the file, so none of them is replayed.
+		if truncateErr := l.file.Truncate(l.size); truncateErr == nil {
+			_, _ = l.file.Seek(l.size, io.SeekStart)
+		}
+		l.failed = err
+		return err
+	}
+	l.failed = nil
+	for i, event := range events {
+		l.indexEvent(event.Sequence, ends[i])
+	}
+	l.sequence += int64(len(events))
+	l.size += int64(buf.Len())
+	return nil
+}
+
+// indexEvent adds the offset after the event to the index when the next event starts an interval.
+func (l *FileEventLog) indexEvent(sequence int64, end int64) {
+	if sequence%eventIndexInterval == 0 {
+		l.index = append(l.index, end)
+	}
+}
+
+func (l *FileEventLog) Replay(after int64, fn func(event Event) error) error {
+	l.lock.RLock()
+	size := l.size
+	start := l.index[min(max(after, 0)/eventIndexInterval, int64(len(l.index)-1))]
+	l.lock.RUnlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	return ReadEvents(io.LimitReader(file, size-start), func(event Event, _ int64) error {
+		if event.Sequence <= after {
+			return nil
+		}
+		return fn(event)
+	})
+}
+
+func (l *FileEventLog) Sequence() int64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.sequence
+}
+
+// Ping fails while the log is closed, the last append failed or the file is gone from
+// its path, so restarts would not replay the events appended from now on.
+func (l *FileEventLog) Ping() error {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.closed {
+		return errEventLogClosed
+	}
+	if l.failed != nil {
+		return fmt.Errorf("last append failed: %v", l.failed)
+	}
+	open, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	stored, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(open, stored) {
+		return fmt.Errorf("event log %s was replaced", l.path)
+	}
+	return nil
+}
+
+func (l *FileEventLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.closed = true
+	return l.file.Close()
+}
+
+// ReadEvents calls fn with the events of a JSON lines log and the offset where each of
+// them ends. A last line without a newline is an append cut short and is skipped.
+func ReadEvents(r io.Reader, fn func(event Event, end int64) error) error {
+	reader := bufio.NewReader(r)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(data))
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := fn(event, offset); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func replaySequences(t *testing.T, log EventLog, after int64) []int64 {
+	sequences := make([]int64, 0)
+	require.NoError(t, log.Replay(after, func(event Event) error {
+		sequences = append(sequences, event.Sequence)
+		return nil
+	}))
+	return sequences
+}
+
+func TestFileEventLog_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := OpenFileEventLog(path)
+	require.NoError(t, err)
+	require.NoError(t, log.Append(itemAdded(1, EventItem{LineId: 1, ProductId: 101, Options: map[string]string{"size": "M"}, Quantity: 2, Price: 10})))
+	require.NoError(t, log.Append(quoteCleared(1, "checkout"), quoteCleared(2, "cleared")))
+	require.NoError(t, log.Close())
+
+	log, err = OpenFileEventLog(path)
+	require.NoError(t, err)
+	defer log.Close()
+	require.NoError(t, log.Append(quoteCleared(3, "cleared")))
+	assert.Equal(t, []int64{1, 2, 3, 4}, replaySequences(t, log, 0))
+	assert.Equal(t, []int64{3, 4}, replaySequences(t, log, 2))
+
+	var first Event
+	require.NoError(t, log.Replay(0, func(event Event) error {
+		if event.Sequence == 1 {
+			first = event
+		}
+		return nil
+	}))
+	assert.Equal(t, EventItemAdded, first.Type)
+	assert.Equal(t, &EventItem{LineId: 1, ProductId: 101, Options: map[string]string{"size": "M"}, Quantity: 2, Price: 10}, first.Item)
+}
+
+func TestFileEventLog_ReplaySeeksIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := OpenFileEventLog(path)
+	require.NoError(t, err)
+	total := int64(2*eventIndexInterval + 10)
+	for i := int64(0); i < total; i += 3 {
+		require.NoError(t, log.Append(quoteCleared(1, "cleared"), quoteCleared(2, "cleared"), quoteCleared(3, "cleared")))
+	}
+	total = log.Sequence()
+	require.Len(t, log.index, 3)
+	require.NoError(t, log.Close())
+
+	// The index is rebuilt on open the same as it was kept while appending.
+	reopened, err := OpenFileEventLog(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, log.index, reopened.index)
+
+	for _, after := range []int64{0, eventIndexInterval - 1, eventIndexInterval, eventIndexInterval + 1, 2 * eventIndexInterval, total - 1, total} {
+		sequences := replaySequences(t, reopened, after)
+		require.Len(t, sequences, int(total-after))
+		if len(sequences) > 0 {
+			assert.Equal(t, after+1, sequences[0])
+		}
+	}
+}
+
+func TestFileEventLog_DropsTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := OpenFileEventLog(path)
+	require.NoError(t, err)
+	require.NoError(t, log.Append(quoteCleared(1, "cleared")))
+	require.NoError(t, log.Close())
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"sequence":2,"type":"Quote`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	log, err = OpenFileEventLog(path)
+	require.NoError(t, err)
+	defer log.Close()
+	require.NoError(t, log.Append(quoteCleared(2, "cleared")))
+	assert.Equal(t, []int64{1, 2}, replaySequences(t, log, 0))
+}
+
+func TestFileEventLog_CorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"sequence\":1}\nnot json\n"), 0o644))
+
+	_, err := OpenFileEventLog(path)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestFileEventLog_Ping(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	log, err := OpenFileEventLog(path)
+	require.NoError(t, err)
+	assert.NoError(t, log.Ping())
+
+	require.NoError(t, os.Rename(path, filepath.Join(dir, "moved.jsonl")))
+	assert.Error(t, log.Ping(), "appends would not be replayed from the path")
+	require.NoError(t, os.Rename(filepath.Join(dir, "moved.jsonl"), path))
+	assert.NoError(t, log.Ping())
+
+	require.NoError(t, log.Close())
+	assert.ErrorIs(t, log.Ping(), errEventLogClosed)
+	assert.ErrorIs(t, log.Append(quoteCleared(1, "cleared")), errEventLogClosed)
+}
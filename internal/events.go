@@ -0,0 +1,426 @@
+package internal
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+)
+
+type EventType string
+
+// Domain events of the quotes, orders and returns. Together they are the history of
+// every cart, order and return, the state of the storages is what replaying them yields.
+const (
+	EventItemAdded           EventType = "ItemAdded"
+	EventQuantityChanged     EventType = "QuantityChanged"
+	EventItemRemoved         EventType = "ItemRemoved"
+	EventQuoteCleared        EventType = "QuoteCleared"
+	EventOrderPlaced         EventType = "OrderPlaced"
+	EventStatusChanged       EventType = "StatusChanged"
+	EventReturnRequested     EventType = "ReturnRequested"
+	EventReturnStatusChanged EventType = "ReturnStatusChanged"
+)
+
+// EventItem is a quote or order line in an event.
+type EventItem struct {
+	LineId    int32             `json:"line_id"`
+	ProductId int32             `json:"product_id"`
+	Variant   string            `json:"variant,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+	Quantity  int32             `json:"quantity"`
+	Price     float32           `json:"price"`
+}
+
+// Event is a change of a quote, an order or a return. Which fields are set depends on
+// the type, Reason tells why a quote was cleared, an order changed its status or a return
+// was requested.
+type Event struct {
+	Sequence   int64       `json:"sequence"`
+	Time       time.Time   `json:"time"`
+	Type       EventType   `json:"type"`
+	CustomerId int32       `json:"customer_id"`
+	OrderId    int32       `json:"order_id,omitempty"`
+	ReturnId   int32       `json:"return_id,omitempty"`
+	Item       *EventItem  `json:"item,omitempty"`
+	Items      []EventItem `json:"items,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+	Note       string      `json:"note,omitempty"`
+}
+
+// EventLog is the append-only log of the domain events.
+type EventLog interface {
+	// Append numbers the events with the next sequence numbers and stores them, either
+	// all of them or none.
+	Append(events ...*Event) error
+	// Replay calls fn with the events after the sequence number, in order.
+	Replay(after int64, fn func(event Event) error) error
+	// Sequence returns the sequence number of the last event, 0 when the log is empty.
+	Sequence() int64
+	// Ping reports why events cannot be appended, nil when they can.
+	Ping() error
+	Close() error
+}
+
+// errEventLogClosed fails the appends after the log was closed.
+var errEventLogClosed = errors.New("event log is closed")
+
+func itemAdded(customerId int32, item EventItem) *Event {
+	item.Options = copyOptions(item.Options)
+	return &Event{Time: time.Now(), Type: EventItemAdded, CustomerId: customerId, Item: &item}
+}
+
+func quantityChanged(customerId int32, lineId int32, item *QuoteItem, quantity int32, price float32) *Event {
+	return &Event{Time: time.Now(), Type: EventQuantityChanged, CustomerId: customerId, Item: &EventItem{
+		LineId:    lineId,
+		ProductId: item.ProductID,
+		Quantity:  quantity,
+		Price:     price,
+	}}
+}
+
+func itemRemoved(customerId int32, lineId int32, item *QuoteItem) *Event {
+	return &Event{Time: time.Now(), Type: EventItemRemoved, CustomerId: customerId, Item: &EventItem{
+		LineId:    lineId,
+		ProductId: item.ProductID,
+	}}
+}
+
+func quoteCleared(customerId int32, reason string) *Event {
+	return &Event{Time: time.Now(), Type: EventQuoteCleared, CustomerId: customerId, Reason: reason}
+}
+
+func orderPlaced(orderId int32, customerId int32, items map[int32]*OrderItem) *Event {
+	event := &Event{
+		Time:       time.Now(),
+		Type:       EventOrderPlaced,
+		CustomerId: customerId,
+		OrderId:    orderId,
+		Status:     pb.OrderStatus_STARTED.String(),
+	}
+	for _, item := range (&Order{Items: items}).SortedItems() {
+		event.Items = append(event.Items, EventItem{
+			LineId:    item.LineID,
+			ProductId: item.ProductID,
+			Variant:   item.Variant,
+			Options:   copyOptions(item.Options),
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
+	}
+	return event
+}
+
+func statusChanged(order *Order, status pb.OrderStatus, reason string) *Event {
+	return &Event{
+		Time:       time.Now(),
+		Type:       EventStatusChanged,
+		CustomerId: order.CustomerId,
+		OrderId:    order.ID,
+		Status:     status.String(),
+		Reason:     reason,
+	}
+}
+
+func returnRequested(ret *Return, note string, at time.Time) *Event {
+	event := &Event{
+		Time:       at,
+		Type:       EventReturnRequested,
+		CustomerId: ret.CustomerId,
+		OrderId:    ret.OrderID,
+		ReturnId:   ret.ID,
+		Status:     ReturnRequested.String(),
+		Reason:     ret.Reason.String(),
+		Note:       note,
+	}
+	for _, lineId := range returnLineIDs(ret.Items) {
+		item := ret.Items[lineId]
+		event.Items = append(event.Items, EventItem{
+			LineId:    item.LineID,
+			ProductId: item.ProductID,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
+	}
+	return event
+}
+
+func returnStatusChanged(ret *Return, status ReturnStatus, note string, at time.Time) *Event {
+	return &Event{
+		Time:       at,
+		Type:       EventReturnStatusChanged,
+		CustomerId: ret.CustomerId,
+		OrderId:    ret.OrderID,
+		ReturnId:   ret.ID,
+		Status:     status.String(),
+		Note:       note,
+	}
+}
+
+// diffQuote returns the events that turn the quote before into the quote after.
+func diffQuote(before *Quote, after *Quote) []*Event {
+	events := make([]*Event, 0)
+	for _, lineId := range lineIDs(after.Items) {
+		item := after.Items[lineId]
+		old, exists := before.Items[lineId]
+		if !exists {
+			events = append(events, itemAdded(after.CustomerId, EventItem{
+				LineId:    lineId,
+				ProductId: item.ProductID,
+				Variant:   item.Variant,
+				Options:   item.Options,
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			}))
+		} else if old.Quantity != item.Quantity || old.Price != item.Price {
+			events = append(events, quantityChanged(after.CustomerId, lineId, old, item.Quantity, item.Price))
+		}
+	}
+	for _, lineId := range lineIDs(before.Items) {
+		if _, exists := after.Items[lineId]; !exists {
+			events = append(events, itemRemoved(before.CustomerId, lineId, before.Items[lineId]))
+		}
+	}
+	return events
+}
+
+func lineIDs(items map[int32]*QuoteItem) []int32 {
+	ids := make([]int32, 0, len(items))
+	for lineId := range items {
+		ids = append(ids, lineId)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// applyQuoteEvent changes the quotes as the event says. The storage and the rebuild
+// from the log share it, so both end up in the same state.
+func applyQuoteEvent(quotes map[int32]*Quote, event *Event) {
+	if event.Type == EventQuoteCleared {
+		delete(quotes, event.CustomerId)
+		return
+	}
+	quote, exists := quotes[event.CustomerId]
+	if !exists {
+		quote = &Quote{CustomerId: event.CustomerId, Items: make(map[int32]*QuoteItem)}
+		quotes[event.CustomerId] = quote
+	}
+	switch event.Type {
+	case EventItemAdded:
+		quote.Items[event.Item.LineId] = &QuoteItem{
+			LineID:    event.Item.LineId,
+			ProductID: event.Item.ProductId,
+			Variant:   event.Item.Variant,
+			Options:   copyOptions(event.Item.Options),
+			Quantity:  event.Item.Quantity,
+			Price:     event.Item.Price,
+		}
+		quote.lastLineID = max(quote.lastLineID, event.Item.LineId)
+	case EventQuantityChanged:
+		if item, exists := quote.Items[event.Item.LineId]; exists {
+			item.Quantity = event.Item.Quantity
+			item.Price = event.Item.Price
+		}
+	case EventItemRemoved:
+		delete(quote.Items, event.Item.LineId)
+	}
+}
+
+// applyOrderEvent is applyQuoteEvent for the orders and the index of their customers.
+func applyOrderEvent(orders map[int32]map[int32]*Order, orderCustomers map[int32]int32, event *Event) {
+	status := pb.OrderStatus(pb.OrderStatus_value[event.Status])
+	switch event.Type {
+	case EventOrderPlaced:
+		order := &Order{
+			ID:         event.OrderId,
+			CustomerId: event.CustomerId,
+			Items:      make(map[int32]*OrderItem, len(event.Items)),
+			Status:     status,
+			CreatedAt:  event.Time,
+		}
+		for _, item := range event.Items {
+			order.Items[item.LineId] = &OrderItem{
+				LineID:    item.LineId,
+				ProductID: item.ProductId,
+				Variant:   item.Variant,
+				Options:   copyOptions(item.Options),
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			}
+		}
+		if orders[event.CustomerId] == nil {
+			orders[event.CustomerId] = make(map[int32]*Order)
+		}
+		orders[event.CustomerId][event.OrderId] = order
+		orderCustomers[event.OrderId] = event.CustomerId
+	case EventStatusChanged:
+		if order, exists := orders[event.CustomerId][event.OrderId]; exists {
+			order.Status = status
+		}
+	}
+}
+
+// applyReturnEvent is applyQuoteEvent for the returns and the index of the returns of
+// each order.
+func applyReturnEvent(returns map[int32]*Return, orderReturns map[int32][]int32, event *Event) {
+	status := parseReturnStatus(event.Status)
+	switch event.Type {
+	case EventReturnRequested:
+		// Unknown reasons are OTHER, the reason does not change what a return allows.
+		reason, _ := parseReturnReason(event.Reason)
+		ret := &Return{
+			ID:         event.ReturnId,
+			OrderID:    event.OrderId,
+			CustomerId: event.CustomerId,
+			Items:      make(map[int32]*ReturnItem, len(event.Items)),
+			Reason:     reason,
+			Status:     status,
+			History:    []ReturnEvent{{Status: status, Note: event.Note, At: event.Time}},
+		}
+		for _, item := range event.Items {
+			ret.Items[item.LineId] = &ReturnItem{
+				LineID:    item.LineId,
+				ProductID: item.ProductId,
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			}
+		}
+		returns[ret.ID] = ret
+		orderReturns[ret.OrderID] = append(orderReturns[ret.OrderID], ret.ID)
+	case EventReturnStatusChanged:
+		ret, exists := returns[event.ReturnId]
+		if !exists {
+			return
+		}
+		if status == ReturnRefunded {
+			ret.RefundAmount = ret.Total()
+		}
+		ret.Status = status
+		ret.History = append(ret.History, ReturnEvent{Status: status, Note: event.Note, At: event.Time})
+	}
+}
+
+// EventState is the state of the quotes, orders and returns rebuilt from the event log,
+// up to and including the event numbered Sequence.
+type EventState struct {
+	Sequence       int64
+	Quotes         map[int32]*Quote
+	Orders         map[int32]map[int32]*Order
+	OrderCustomers map[int32]int32
+	Returns        map[int32]*Return
+	OrderReturns   map[int32][]int32
+}
+
+func NewEventState() *EventState {
+	return &EventState{
+		Quotes:         make(map[int32]*Quote),
+		Orders:         make(map[int32]map[int32]*Order),
+		OrderCustomers: make(map[int32]int32),
+		Returns:        make(map[int32]*Return),
+		OrderReturns:   make(map[int32][]int32),
+	}
+}
+
+func (s *EventState) Apply(event *Event) {
+	switch event.Type {
+	case EventOrderPlaced, EventStatusChanged:
+		applyOrderEvent(s.Orders, s.OrderCustomers, event)
+	case EventReturnRequested, EventReturnStatusChanged:
+		applyReturnEvent(s.Returns, s.OrderReturns, event)
+	default:
+		applyQuoteEvent(s.Quotes, event)
+	}
+	s.Sequence = event.Sequence
+}
+
+// copy returns a deep copy of the state, the storages restored from it do not share
+// anything with it.
+func (s *EventState) copy() *EventState {
+	c := NewEventState()
+	c.Sequence = s.Sequence
+	for customerId, quote := range s.Quotes {
+		c.Quotes[customerId] = copyQuote(quote)
+	}
+	for customerId, orders := range s.Orders {
+		c.Orders[customerId] = make(map[int32]*Order, len(orders))
+		for orderId, order := range orders {
+			c.Orders[customerId][orderId] = copyOrder(order)
+		}
+	}
+	for orderId, customerId := range s.OrderCustomers {
+		c.OrderCustomers[orderId] = customerId
+	}
+	for returnId, ret := range s.Returns {
+		c.Returns[returnId] = copyReturn(ret)
+	}
+	for orderId, returnIds := range s.OrderReturns {
+		c.OrderReturns[orderId] = append([]int32(nil), returnIds...)
+	}
+	return c
+}
+
+// MemoryEventLog keeps the events in memory, they are lost on restart.
+type MemoryEventLog struct {
+	events []Event
+	closed bool
+	lock   sync.RWMutex
+}
+
+func NewMemoryEventLog() *MemoryEventLog {
+	return &MemoryEventLog{}
+}
+
+func (l *MemoryEventLog) Append(events ...*Event) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return errEventLogClosed
+	}
+	for _, event := range events {
+		event.Sequence = int64(len(l.events) + 1)
+		l.events = append(l.events, *event)
+	}
+	return nil
+}
+
+func (l *MemoryEventLog) Replay(after int64, fn func(event Event) error) error {
+	l.lock.RLock()
+	events := l.events[min(max(after, 0), int64(len(l.events))):]
+	l.lock.RUnlock()
+	for _, event := range events {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *MemoryEventLog) Sequence() int64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return int64(len(l.events))
+}
+
+func (l *MemoryEventLog) Ping() error {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.closed {
+		return errEventLogClosed
+	}
+	return nil
+}
+
+func (l *MemoryEventLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.closed = true
+	return nil
+}
+
+// eventLogError reports a change that could not be recorded, it has not been made.
+func eventLogError(err error) error {
+	return Unavailable("EVENT_LOG_UNAVAILABLE", err, "failed to record the change")
+}
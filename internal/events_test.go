@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEventServers returns quote, order and return servers recording their changes in
+// the log, restored from the events already in it.
+func newEventServers(t *testing.T, log EventLog) (*QuoteServer, *OrderServer, *ReturnServer) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, fixedPriceCatalog{10}, nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, fixedPriceCatalog{10}, nil)
+	returnServer := NewReturnServer(orderServer)
+	state, err := LoadEventState(log, nil)
+	require.NoError(t, err)
+	require.NoError(t, AttachEventLog(log, state, quoteServer, orderServer, returnServer))
+	return quoteServer, orderServer, returnServer
+}
+
+// failingEventLog rejects the appends with an event of the type, or every append
+// when failType is empty.
+type failingEventLog struct {
+	MemoryEventLog
+	failType EventType
+}
+
+func (l *failingEventLog) Append(events ...*Event) error {
+	for _, event := range events {
+		if l.failType == "" || event.Type == l.failType {
+			return errors.New("disk full")
+		}
+	}
+	return l.MemoryEventLog.Append(events...)
+}
+
+func TestDiffQuote(t *testing.T) {
+	before := &Quote{CustomerId: 1, Items: map[int32]*QuoteItem{
+		1: {LineID: 1, ProductID: 101, Quantity: 1, Price: 10},
+		2: {LineID: 2, ProductID: 102, Quantity: 1, Price: 10},
+		3: {LineID: 3, ProductID: 103, Quantity: 1, Price: 10},
+	}, lastLineID: 3}
+	tests := []struct {
+		name     string
+		change   func(quote *Quote)
+		expected []EventType
+	}{
+		{"unchanged", func(quote *Quote) {}, []EventType{}},
+		{"quantity", func(quote *Quote) { quote.Items[1].Quantity = 2 }, []EventType{EventQuantityChanged}},
+		{"price", func(quote *Quote) { quote.Items[2].Price = 12 }, []EventType{EventQuantityChanged}},
+		{"removed", func(quote *Quote) { delete(quote.Items, 3) }, []EventType{EventItemRemoved}},
+		{"added", func(quote *Quote) { quote.addItem(104, "", nil, 1, 10) }, []EventType{EventItemAdded}},
+		{
+			"several",
+			func(quote *Quote) {
+				delete(quote.Items, 1)
+				quote.Items[2].Quantity = 5
+				quote.addItem(104, "", nil, 1, 10)
+			},
+			[]EventType{EventQuantityChanged, EventItemAdded, EventItemRemoved},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			after := copyQuote(before)
+			test.change(after)
+			events := diffQuote(before, after)
+			types := make([]EventType, 0, len(events))
+			for _, event := range events {
+				types = append(types, event.Type)
+			}
+			assert.Equal(t, test.expected, types)
+
+			rebuilt := map[int32]*Quote{1: copyQuote(before)}
+			for _, event := range events {
+				applyQuoteEvent(rebuilt, event)
+			}
+			assert.Equal(t, after.Items, rebuilt[1].Items)
+		})
+	}
+}
+
+func TestEventLog_RebuildsLiveState(t *testing.T) {
+	log := NewMemoryEventLog()
+	quoteServer, orderServer, _ := newEventServers(t, log)
+	ctx := context.Background()
+
+	for _, product := range []int32{101, 102, 103} {
+		_, err := quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: product, Quantity: 1})
+		require.NoError(t, err)
+	}
+	_, err := quoteServer.UpdateQuantity(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 102, Quantity: 4})
+	require.NoError(t, err)
+	_, err = quoteServer.RemoveProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 101})
+	require.NoError(t, err)
+	require.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: ctx}))
+	_, err = quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 104, Quantity: 2})
+	require.NoError(t, err)
+	_, err = quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 2, ProductId: 101, Quantity: 1})
+	require.NoError(t, err)
+
+	types := make([]EventType, 0)
+	require.NoError(t, log.Replay(0, func(event Event) error {
+		types = append(types, event.Type)
+		return nil
+	}))
+	assert.Equal(t, []EventType{
+		EventItemAdded, EventItemAdded, EventItemAdded, EventQuantityChanged, EventItemRemoved,
+		EventOrderPlaced, EventQuoteCleared, EventStatusChanged, EventStatusChanged,
+		EventItemAdded, EventItemAdded,
+	}, types)
+
+	restoredQuotes, restoredOrders, _ := newEventServers(t, log)
+	for _, customerId := range []int32{1, 2} {
+		assert.Equal(t, storedQuote(quoteServer.qouteStorage, customerId), storedQuote(restoredQuotes.qouteStorage, customerId))
+	}
+	order, err := orderServer.getOrder(ctx, 1)
+	require.NoError(t, err)
+	restored, err := restoredOrders.getOrder(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, order, restored)
+	assert.Equal(t, pb.OrderStatus_COMPLETED, restored.Status)
+
+	// Line and order IDs continue where the log left off.
+	quote, err := restoredQuotes.qouteStorage.AddLine(1, 105, "", nil, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int32(105), quote.Items[2].ProductID)
+	require.NoError(t, restoredOrders.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: ctx}))
+	_, err = restoredOrders.getOrder(ctx, 2)
+	assert.NoError(t, err)
+}
+
+func TestEventLog_FailedAppendChangesNothing(t *testing.T) {
+	quoteServer, _, _ := newEventServers(t, &failingEventLog{})
+	ctx := context.Background()
+
+	_, err := quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 1})
+	assert.True(t, IsKind(err, KindUnavailable))
+	assert.Empty(t, storedQuote(quoteServer.qouteStorage, 1).Items)
+}
+
+func TestQuoteStorage_PingChecksEventLog(t *testing.T) {
+	log := NewMemoryEventLog()
+	quoteServer, _, _ := newEventServers(t, log)
+	quoteStorage := quoteServer.qouteStorage
+	assert.NoError(t, quoteStorage.Ping(context.Background()))
+
+	require.NoError(t, log.Close())
+	assert.ErrorIs(t, quoteStorage.Ping(context.Background()), errEventLogClosed)
+}
+
+func TestEventLog_CheckoutIsOneAppend(t *testing.T) {
+	log := &failingEventLog{failType: EventQuoteCleared}
+	quoteServer, orderServer, _ := newEventServers(t, log)
+	outbox := NewMemoryOutbox()
+	AttachOutbox(outbox, orderServer)
+	ctx := context.Background()
+	_, err := quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 1})
+	require.NoError(t, err)
+
+	// The order is not placed without the quote being cleared.
+	err = orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: ctx})
+	assert.True(t, IsKind(err, KindUnavailable))
+	_, err = orderServer.getOrder(ctx, 1)
+	assert.Error(t, err)
+	assert.Len(t, storedQuote(quoteServer.qouteStorage, 1).Items, 1)
+	pending, err := outbox.Pending(10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	state, err := LoadEventState(log, nil)
+	require.NoError(t, err)
+	assert.Empty(t, state.Orders)
+	assert.Len(t, state.Quotes[1].Items, 1)
+
+	log.failType = "none"
+	require.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: ctx}))
+	types := make([]EventType, 0)
+	require.NoError(t, log.Replay(1, func(event Event) error {
+		types = append(types, event.Type)
+		return nil
+	}))
+	require.GreaterOrEqual(t, len(types), 2)
+	assert.Equal(t, []EventType{EventOrderPlaced, EventQuoteCleared}, types[:2])
+}
+
+func TestEventLog_RestoresReturns(t *testing.T) {
+	log := &failingEventLog{failType: "none"}
+	quoteServer, orderServer, returnServer := newEventServers(t, log)
+	ctx := context.Background()
+	_, err := quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 2})
+	require.NoError(t, err)
+	require.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: ctx}))
+
+	first, err := returnServer.RequestReturn(ctx, 1, map[int32]int32{1: 1}, ReasonDamaged, "cracked")
+	require.NoError(t, err)
+	_, err = returnServer.ApproveReturn(ctx, first.ID, "")
+	require.NoError(t, err)
+	_, err = returnServer.ReceiveReturn(ctx, first.ID, "")
+	require.NoError(t, err)
+	first, err = returnServer.RefundReturn(ctx, first.ID, "refunded to card")
+	require.NoError(t, err)
+
+	// A status change the log rejects is not made.
+	second, err := returnServer.RequestReturn(ctx, 1, map[int32]int32{1: 1}, ReasonOther, "")
+	require.NoError(t, err)
+	log.failType = EventReturnStatusChanged
+	_, err = returnServer.ApproveReturn(ctx, second.ID, "")
+	assert.True(t, IsKind(err, KindUnavailable))
+	log.failType = "none"
+	got, err := returnServer.GetReturn(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, second, got)
+
+	_, _, restored := newEventServers(t, log)
+	for _, ret := range []*Return{first, second} {
+		got, err := restored.GetReturn(ctx, ret.ID)
+		require.NoError(t, err)
+		assert.Equal(t, ret, got)
+	}
+	returns, err := restored.GetOrderReturns(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{first.ID, second.ID}, []int32{returns[0].ID, returns[1].ID})
+	assert.Equal(t, float32(10), returns[0].RefundAmount)
+
+	// Return IDs continue where the log left off.
+	_, err = restored.RejectReturn(ctx, second.ID, "")
+	require.NoError(t, err)
+	third, err := restored.RequestReturn(ctx, 1, map[int32]int32{1: 1}, ReasonOther, "")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), third.ID)
+}
+
+func TestMemoryEventLog_Replay(t *testing.T) {
+	log := NewMemoryEventLog()
+	require.NoError(t, log.Append(quoteCleared(1, "a"), quoteCleared(2, "b")))
+	require.NoError(t, log.Append(quoteCleared(3, "c")))
+
+	tests := []struct {
+		name     string
+		after    int64
+		expected []int64
+	}{
+		{"all", 0, []int64{1, 2, 3}},
+		{"after", 2, []int64{3}},
+		{"end", 3, []int64{}},
+		{"past end", 10, []int64{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sequences := make([]int64, 0)
+			require.NoError(t, log.Replay(test.after, func(event Event) error {
+				sequences = append(sequences, event.Sequence)
+				return nil
+			}))
+			assert.Equal(t, test.expected, sequences)
+		})
+	}
+}
@@ -0,0 +1,616 @@
+package internal
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// openAPIDocument describes the routes of the gateway, keep it in sync with routes.
+//
+//go:embed openapi.yaml
+var openAPIDocument []byte
+
+// httpStatusCodes maps gRPC status codes to the HTTP status of the JSON error body.
+var httpStatusCodes = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusPreconditionFailed,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// route is a REST operation. Policy names the gRPC method whose RBAC policy, rate limit
+// and deadline apply, stream routes get the deadline of the gRPC stream, if any.
+type route struct {
+	pattern string
+	policy  string
+	stream  bool
+	handler func(w http.ResponseWriter, r *http.Request) error
+}
+
+// Gateway is the REST/JSON front end of the quote, order and return servers. It calls
+// the servers in-process, with the same authentication and authorization as gRPC.
+type Gateway struct {
+	quoteServer   *QuoteServer
+	orderServer   *OrderServer
+	returnServer  *ReturnServer
+	authenticator *Authenticator
+	authorizer    *Authorizer
+	limiter       *RateLimiter
+	deadlines     *Deadlines
+	cors          CORSConfig
+	handler       http.Handler
+}
+
+// NewGateway creates the gateway, authenticator, authorizer and limiter are nil when
+// authentication, RBAC or rate limiting are disabled, deadlines is nil for no deadlines.
+func NewGateway(config GatewayConfig, quoteServer *QuoteServer, orderServer *OrderServer, returnServer *ReturnServer, authenticator *Authenticator, authorizer *Authorizer, limiter *RateLimiter, deadlines *Deadlines) *Gateway {
+	g := &Gateway{
+		quoteServer:   quoteServer,
+		orderServer:   orderServer,
+		returnServer:  returnServer,
+		authenticator: authenticator,
+		authorizer:    authorizer,
+		limiter:       limiter,
+		deadlines:     deadlines,
+		cors:          config.CORS,
+	}
+	mux := http.NewServeMux()
+	for _, route := range g.routes() {
+		mux.Handle(route.pattern, g.wrap(route))
+	}
+	mux.HandleFunc("GET /openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(openAPIDocument)
+	})
+	g.handler = g.withCORS(mux)
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.handler.ServeHTTP(w, r)
+}
+
+func (g *Gateway) routes() []route {
+	return []route{
+		{"GET /v1/customers/{customerId}/quote", "/sale.QuoteService/GetQuote", false, g.getQuote},
+		{"POST /v1/customers/{customerId}/quote/items", "/sale.QuoteService/AddProduct", false, g.addQuoteItem},
+		{"PUT /v1/customers/{customerId}/quote/items/{productId}", "/sale.QuoteService/UpdateQuantity", false, g.updateQuoteItem},
+		{"DELETE /v1/customers/{customerId}/quote/items/{productId}", "/sale.QuoteService/RemoveProduct", false, g.removeQuoteItem},
+		{"PUT /v1/customers/{customerId}/quote/lines/{lineId}", "/sale.QuoteService/UpdateQuantity", false, g.updateQuoteLine},
+		{"DELETE /v1/customers/{customerId}/quote/lines/{lineId}", "/sale.QuoteService/RemoveProduct", false, g.removeQuoteLine},
+		{"GET /v1/customers/{customerId}/orders", "/sale.OrderService/GetOrders", false, g.listOrders},
+		{"POST /v1/customers/{customerId}/orders", "/sale.OrderService/PlaceOrder", true, g.placeOrder},
+		{"GET /v1/orders/{orderId}", "/sale.OrderService/GetOrder", false, g.getOrder},
+		{"GET /v1/orders/{orderId}/events", "/sale.OrderService/GetOrderEvents", true, g.orderEvents},
+		{"GET /v1/orders/{orderId}/returns", "/sale.ReturnService/GetOrderReturns", false, g.listOrderReturns},
+		{"POST /v1/orders/{orderId}/returns", "/sale.ReturnService/RequestReturn", false, g.requestReturn},
+		{"GET /v1/returns/{returnId}", "/sale.ReturnService/GetReturn", false, g.getReturn},
+		{"POST /v1/returns/{returnId}/approve", "/sale.ReturnService/ApproveReturn", false, g.returnTransition(g.returnServer.ApproveReturn)},
+		{"POST /v1/returns/{returnId}/reject", "/sale.ReturnService/RejectReturn", false, g.returnTransition(g.returnServer.RejectReturn)},
+		{"POST /v1/returns/{returnId}/receive", "/sale.ReturnService/ReceiveReturn", false, g.returnTransition(g.returnServer.ReceiveReturn)},
+		{"POST /v1/returns/{returnId}/refund", "/sale.ReturnService/RefundReturn", false, g.returnTransition(g.returnServer.RefundReturn)},
+	}
+}
+
+// wrap runs a route with a request logger, authentication, rate limiting, authorization,
+// panic recovery and error mapping.
+func (g *Gateway) wrap(route route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := contextWithLogger(r.Context(), slog.Default().With("request_id", id, "method", route.pattern))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		authCtx, err := g.authenticateContext(ctx, r)
+		if err == nil {
+			ctx = authCtx
+		}
+		if err == nil && g.limiter != nil {
+			err = g.limiter.allow(ctx, route.policy, remoteIP(r))
+		}
+		if err == nil && g.authorizer != nil {
+			err = g.authorizer.authorize(ctx, route.policy)
+		}
+		if timeout, exists := g.deadlines.timeout(route.policy, route.stream); err == nil && exists {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if err == nil {
+			err = serveRoute(route, recorder, r.WithContext(ctx))
+		}
+		if err != nil && !recorder.written {
+			writeError(recorder, err)
+		}
+		loggerFromContext(ctx).Info("http request finished", "duration", time.Since(start), "status", recorder.status, "code", status.Code(err).String())
+	})
+}
+
+// serveRoute runs the handler of the route, a panic fails the request like on gRPC.
+func serveRoute(route route, w http.ResponseWriter, r *http.Request) (err error) {
+	defer recoverPanic(r.Context(), route.pattern, &err)
+	return route.handler(w, r)
+}
+
+// authenticateContext adds the principal of the bearer token to the context, the token
+// is passed as gRPC metadata so it is validated exactly like on the gRPC API.
+func (g *Gateway) authenticateContext(ctx context.Context, r *http.Request) (context.Context, error) {
+	if g.authenticator == nil {
+		return ctx, nil
+	}
+	authorization := r.Header.Get("Authorization")
+	// EventSource cannot set headers, event streams may pass the token in the query.
+	if token := r.URL.Query().Get("access_token"); authorization == "" && token != "" && r.Header.Get("Accept") == "text/event-stream" {
+		authorization = "Bearer " + token
+	}
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", authorization))
+	return g.authenticator.authenticateContext(ctx)
+}
+
+// withCORS answers preflight requests and adds the CORS headers for allowed origins.
+func (g *Gateway) withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !g.originAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id, Price-Token")
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(g.cors.AllowedHeaders, ", "))
+		if g.cors.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(g.cors.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (g *Gateway) originAllowed(origin string) bool {
+	for _, allowed := range g.cors.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRecorder remembers the status of the response and whether it was started.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.written = true
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.written = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	flush(r.ResponseWriter)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes the JSON error body, the HTTP status is derived from the gRPC code.
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	body := errorJSON{Code: st.Code().String(), Message: st.Message()}
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			body.Reason = d.Reason
+			body.Metadata = d.Metadata
+		case *errdetails.BadRequest:
+			for _, violation := range d.FieldViolations {
+				body.FieldViolations = append(body.FieldViolations, fieldViolationJSON{Field: violation.Field, Description: violation.Description})
+			}
+		}
+	}
+	if retryAfter := retryAfterMetadata(err).Get("retry-after"); len(retryAfter) > 0 {
+		w.Header().Set("Retry-After", retryAfter[0])
+	}
+	httpStatus, exists := httpStatusCodes[st.Code()]
+	if !exists {
+		httpStatus = http.StatusInternalServerError
+	}
+	_ = writeJSON(w, httpStatus, errorResponse{Error: body})
+}
+
+// remoteIP is the IP address of the client, proxy headers are not trusted.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func pathID(r *http.Request, name string) (int32, error) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 32)
+	if err != nil {
+		return 0, InvalidArgument(name, "INVALID_ID", "invalid %s %q", name, r.PathValue(name))
+	}
+	return int32(id), nil
+}
+
+func decodeBody(r *http.Request, body interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(body); err != nil {
+		return InvalidArgument("body", "INVALID_BODY", "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func (g *Gateway) getQuote(w http.ResponseWriter, r *http.Request) error {
+	customerId, err := pathID(r, "customerId")
+	if err != nil {
+		return err
+	}
+	quote, err := g.quoteServer.getQuote(r.Context(), customerId)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, quoteToJSON(quote))
+}
+
+func (g *Gateway) addQuoteItem(w http.ResponseWriter, r *http.Request) error {
+	customerId, err := pathID(r, "customerId")
+	if err != nil {
+		return err
+	}
+	var body addItemRequest
+	if err := decodeBody(r, &body); err != nil {
+		return err
+	}
+	quote, err := g.quoteServer.addLine(r.Context(), customerId, body.ProductID, body.Variant, body.Options, body.Quantity)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, quoteToJSON(quote))
+}
+
+func (g *Gateway) updateQuoteItem(w http.ResponseWriter, r *http.Request) error {
+	customerId, err := pathID(r, "customerId")
+	if err != nil {
+		return err
+	}
+	productId, err := pathID(r, "productId")
+	if err != nil {
+		return err
+	}
+	var body updateItemRequest
+	if err := decodeBody(r, &body); err != nil {
+		return err
+	}
+	quote, err := g.quoteServer.updateQuantity(r.Context(), customerId, productId, body.Quantity)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, quoteToJSON(quote))
+}
+
+// updateQuoteLine sets the quantity of a line by the line_id of the quote, the
+// product routes only reach the lines without variant and options.
+func (g *Gateway) updateQuoteLine(w http.ResponseWriter, r *http.Request) error {
+	customerId, err := pathID(r, "customerId")
+	if err != nil {
+		return err
+	}
+	lineId, err := pathID(r, "lineId")
+	if err != nil {
+		return err
+	}
+	var body updateItemRequest
+	if err := decodeBody(r, &body); err != nil {
+		return err
+	}
+	quote, err := g.quoteServer.updateLineQuantity(r.Context(), customerId, lineId, body.Quantity)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, quoteToJSON(quote))
+}
+
+func (g *Gateway) removeQuoteItem(w http.ResponseWriter, r *http.Request) error {
+	customerId, err := pathID(r, "customerId")
+	if err != nil {
+		return err
+	}
+	productId, err := pathID(r, "productId")
+	if err != nil {
+		return err
+	}
+	quote, err := g.quoteServer.removeProduct(r.Context(), customerId, productId)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, quoteToJSON(quote))
+}
+
+func (g *Gateway) removeQuoteLine(w http.ResponseWriter, r *http.Request) error {
+	customerId, err := pathID(r, "customerId")
+	if err != nil {
+		return err
+	}
+	lineId, err := pathID(r, "lineId")
+	if err != nil {
+		return err
+	}
+	quote, err := g.quoteServer.removeLine(r.Context(), customerId, lineId)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, quoteToJSON(quote))
+}
+
+// orderListRequest reads the filters and the page of the order list from the query.
+func orderListRequest(r *http.Request, customerId int32) (OrderListRequest, error) {
+	query := r.URL.Query()
+	request := OrderListRequest{CustomerId: customerId, Cursor: query.Get("cursor")}
+	for _, name := range query["status"] {
+		value, exists := pb.OrderStatus_value[strings.ToUpper(name)]
+		if !exists {
+			return request, InvalidArgument("status", "INVALID_STATUS", "unknown order status %q", name)
+		}
+		request.Filter.Statuses = append(request.Filter.Statuses, pb.OrderStatus(value))
+	}
+	times := []struct {
+		name   string
+		target *time.Time
+	}{
+		{"created_after", &request.Filter.CreatedAfter},
+		{"created_before", &request.Filter.CreatedBefore},
+	}
+	for _, t := range times {
+		if value := query.Get(t.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return request, InvalidArgument(t.name, "INVALID_TIME", "%s must be an RFC 3339 time", t.name)
+			}
+			*t.target = parsed
+		}
+	}
+	ints := []struct {
+		name string
+		set  func(int)
+	}{
+		{"product_id", func(v int) { request.Filter.ProductID = int32(v) }},
+		{"page_size", func(v int) { request.PageSize = v }},
+	}
+	for _, i := range ints {
+		if value := query.Get(i.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return request, InvalidArgument(i.name, "INVALID_NUMBER", "%s must be a number", i.name)
+			}
+			i.set(parsed)
+		}
+	}
+	return request, nil
+}
+
+func (g *Gateway) listOrders(w http.ResponseWriter, r *http.Request) error {
+	customerId, err := pathID(r, "customerId")
+	if err != nil {
+		return err
+	}
+	request, err := orderListRequest(r, customerId)
+	if err != nil {
+		return err
+	}
+	page, err := g.orderServer.ListOrders(r.Context(), request)
+	if err != nil {
+		return err
+	}
+	orders := make([]orderJSON, 0, len(page.Orders))
+	for _, order := range page.Orders {
+		orders = append(orders, orderToJSON(order))
+	}
+	return writeJSON(w, http.StatusOK, orderPageJSON{Orders: orders, NextCursor: page.NextCursor})
+}
+
+func (g *Gateway) getOrder(w http.ResponseWriter, r *http.Request) error {
+	orderId, err := pathID(r, "orderId")
+	if err != nil {
+		return err
+	}
+	order, err := g.orderServer.getOrder(r.Context(), orderId)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, orderToJSON(order))
+}
+
+// placeOrder streams the checkout progress as newline delimited JSON, one line per
+// status. Errors before the first status are reported as a JSON error body.
+func (g *Gateway) placeOrder(w http.ResponseWriter, r *http.Request) error {
+	customerId, err := pathID(r, "customerId")
+	if err != nil {
+		return err
+	}
+	var body placeOrderRequest
+	// The price token is only sent to accept changed prices, so the body is optional.
+	if r.ContentLength > 0 {
+		if err := decodeBody(r, &body); err != nil {
+			return err
+		}
+	}
+	ctx := r.Context()
+	if body.PriceToken != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+		md.Set(PriceTokenMetadata, body.PriceToken)
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	return g.orderServer.PlaceOrder(&pb.CustomerId{Id: customerId}, &httpOrderStream{w: w, ctx: ctx})
+}
+
+// httpOrderStream adapts an HTTP response to the PlaceOrder server stream.
+type httpOrderStream struct {
+	grpc.ServerStream
+	w       http.ResponseWriter
+	ctx     context.Context
+	started bool
+}
+
+func (s *httpOrderStream) Context() context.Context {
+	return s.ctx
+}
+
+// SetHeader adds the metadata to the HTTP headers, it fails once the first status is sent.
+func (s *httpOrderStream) SetHeader(md metadata.MD) error {
+	if s.started {
+		return fmt.Errorf("headers already sent")
+	}
+	for key, values := range md {
+		for _, value := range values {
+			s.w.Header().Add(key, value)
+		}
+	}
+	return nil
+}
+
+func (s *httpOrderStream) Send(processStatus *pb.ProcessStatus) error {
+	if !s.started {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	if err := json.NewEncoder(s.w).Encode(processStatusToJSON(processStatus)); err != nil {
+		return err
+	}
+	flush(s.w)
+	return nil
+}
+
+func parseReturnReason(name string) (ReturnReason, error) {
+	for reason := ReasonOther; reason <= ReasonNoLongerNeeded; reason++ {
+		if strings.EqualFold(reason.String(), name) {
+			return reason, nil
+		}
+	}
+	return ReasonOther, InvalidArgument("reason", "INVALID_REASON", "unknown return reason %q", name)
+}
+
+func (g *Gateway) requestReturn(w http.ResponseWriter, r *http.Request) error {
+	orderId, err := pathID(r, "orderId")
+	if err != nil {
+		return err
+	}
+	var body returnRequest
+	if err := decodeBody(r, &body); err != nil {
+		return err
+	}
+	reason, err := parseReturnReason(body.Reason)
+	if err != nil {
+		return err
+	}
+	items := make(map[int32]int32, len(body.Items))
+	for _, item := range body.Items {
+		items[item.LineID] += item.Quantity
+	}
+	ret, err := g.returnServer.RequestReturn(r.Context(), orderId, items, reason, body.Note)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, returnToJSON(ret))
+}
+
+func (g *Gateway) listOrderReturns(w http.ResponseWriter, r *http.Request) error {
+	orderId, err := pathID(r, "orderId")
+	if err != nil {
+		return err
+	}
+	returns, err := g.returnServer.GetOrderReturns(r.Context(), orderId)
+	if err != nil {
+		return err
+	}
+	body := make([]returnJSON, 0, len(returns))
+	for _, ret := range returns {
+		body = append(body, returnToJSON(ret))
+	}
+	return writeJSON(w, http.StatusOK, map[string][]returnJSON{"returns": body})
+}
+
+func (g *Gateway) getReturn(w http.ResponseWriter, r *http.Request) error {
+	returnId, err := pathID(r, "returnId")
+	if err != nil {
+		return err
+	}
+	ret, err := g.returnServer.GetReturn(r.Context(), returnId)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, returnToJSON(ret))
+}
+
+func (g *Gateway) returnTransition(transition func(ctx context.Context, returnId int32, note string) (*Return, error)) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		returnId, err := pathID(r, "returnId")
+		if err != nil {
+			return err
+		}
+		var body returnNoteRequest
+		// The note is optional, so is the body.
+		if r.ContentLength > 0 {
+			if err := decodeBody(r, &body); err != nil {
+				return err
+			}
+		}
+		ret, err := transition(r.Context(), returnId, body.Note)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, returnToJSON(ret))
+	}
+}
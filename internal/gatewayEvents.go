@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+)
+
+// sseHeartbeatInterval keeps idle event streams from being closed by proxies.
+var sseHeartbeatInterval = 15 * time.Second
+
+// lastEventID is the ID of the last event the client has seen, sent by EventSource on reconnect.
+func lastEventID(r *http.Request) (int, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return 0, InvalidArgument("Last-Event-ID", "INVALID_EVENT_ID", "invalid Last-Event-ID %q", value)
+	}
+	return id, nil
+}
+
+// orderEvents streams the checkout progress of an order as Server-Sent Events. Events
+// after the Last-Event-ID are replayed first, the stream ends with the checkout.
+func (g *Gateway) orderEvents(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderId, err := pathID(r, "orderId")
+	if err != nil {
+		return err
+	}
+	lastId, err := lastEventID(r)
+	if err != nil {
+		return err
+	}
+	update, tracked := g.orderServer.progress.since(orderId, lastId)
+	if tracked {
+		if authorizeCustomer(ctx, update.CustomerId) != nil {
+			return NotFound("ORDER_NOT_FOUND", "order with id %d not found", orderId)
+		}
+	} else {
+		// Orders placed before the server started have no progress, their status is the only event.
+		order, err := g.orderServer.getOrder(ctx, orderId)
+		if err != nil {
+			return err
+		}
+		update = progressUpdate{CustomerId: order.CustomerId, Done: true}
+		if lastId < 1 {
+			update.Events = []ProgressEvent{{ID: 1, Status: &pb.ProcessStatus{OrderId: orderId, Status: order.Status}}}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		for _, event := range update.Events {
+			if err := writeEvent(w, event); err != nil {
+				return err
+			}
+			lastId = event.ID
+		}
+		flush(w)
+		if update.Done {
+			return nil
+		}
+		select {
+		case <-update.Changed:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+		update, _ = g.orderServer.progress.since(orderId, lastId)
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event ProgressEvent) error {
+	data, err := json.Marshal(processStatusToJSON(event.Status))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", event.ID, data)
+	return err
+}
+
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
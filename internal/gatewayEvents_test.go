@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sseEvent struct {
+	id     string
+	event  string
+	status processStatusJSON
+}
+
+// readEvents reads the events of the stream until it ends, comments are skipped.
+func readEvents(t *testing.T, resp *http.Response, limit int) []sseEvent {
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for len(events) < limit && scanner.Scan() {
+		field, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch field {
+		case "id":
+			current.id = value
+		case "event":
+			current.event = value
+		case "data":
+			require.NoError(t, json.Unmarshal([]byte(value), &current.status))
+		case "":
+			if current.id != "" {
+				events = append(events, current)
+			}
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+func getEvents(t *testing.T, url string, lastEventId string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestGateway_OrderEventsReplay(t *testing.T) {
+	server := newTestGateway(t, nil, nil)
+	doJSON(t, http.MethodPost, server.URL+"/v1/customers/1/quote/items", "", `{"product_id": 101, "quantity": 1}`, nil)
+	resp, err := http.Post(server.URL+"/v1/customers/1/orders", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	resp = getEvents(t, server.URL+"/v1/orders/1/events", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := readEvents(t, resp, 10)
+	require.Len(t, events, 4)
+	assert.Equal(t, "1", events[0].id)
+	assert.Equal(t, "status", events[0].event)
+	assert.Equal(t, processStatusJSON{OrderID: 1, Status: "STARTED", Message: "Order processing started."}, events[0].status)
+	assert.Equal(t, "COMPLETED", events[3].status.Status)
+
+	resp = getEvents(t, server.URL+"/v1/orders/1/events", "2")
+	events = readEvents(t, resp, 10)
+	require.Len(t, events, 2)
+	assert.Equal(t, []string{"3", "4"}, []string{events[0].id, events[1].id})
+}
+
+func TestGateway_OrderEventsLive(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	orderServer.orders[1] = map[int32]*Order{5: {ID: 5, CustomerId: 1, Status: pb.OrderStatus_STARTED}}
+	orderServer.customerOrderMap[5] = 1
+	orderServer.progress.start(5, 1)
+	orderServer.progress.publish(&pb.ProcessStatus{OrderId: 5, Status: pb.OrderStatus_STARTED})
+	server := httptest.NewServer(NewGateway(DefaultConfig().Gateway, quoteServer, orderServer, NewReturnServer(orderServer), nil, nil, nil, nil))
+	t.Cleanup(server.Close)
+
+	resp := getEvents(t, server.URL+"/v1/orders/5/events", "")
+	events := readEvents(t, resp, 1)
+	require.Len(t, events, 1)
+	assert.Equal(t, "STARTED", events[0].status.Status)
+
+	orderServer.progress.publish(&pb.ProcessStatus{OrderId: 5, Status: pb.OrderStatus_PROCESSED})
+	orderServer.progress.publish(&pb.ProcessStatus{OrderId: 5, Status: pb.OrderStatus_COMPLETED})
+	events = readEvents(t, resp, 10)
+	require.Len(t, events, 2)
+	assert.Equal(t, []string{"2", "3"}, []string{events[0].id, events[1].id})
+	assert.Equal(t, "COMPLETED", events[1].status.Status)
+}
+
+func TestGateway_OrderEventsHeartbeat(t *testing.T) {
+	interval := sseHeartbeatInterval
+	sseHeartbeatInterval = 10 * time.Millisecond
+	defer func() { sseHeartbeatInterval = interval }()
+
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	orderServer.progress.start(5, 1)
+	server := httptest.NewServer(NewGateway(DefaultConfig().Gateway, quoteServer, orderServer, NewReturnServer(orderServer), nil, nil, nil, nil))
+	t.Cleanup(server.Close)
+
+	resp := getEvents(t, server.URL+"/v1/orders/5/events", "")
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ": keep-alive\n", line)
+}
+
+func TestGateway_OrderEventsUntrackedOrder(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	orderServer.orders[1] = map[int32]*Order{5: {ID: 5, CustomerId: 1, Status: pb.OrderStatus_COMPLETED}}
+	orderServer.customerOrderMap[5] = 1
+	server := httptest.NewServer(NewGateway(DefaultConfig().Gateway, quoteServer, orderServer, NewReturnServer(orderServer), nil, nil, nil, nil))
+	t.Cleanup(server.Close)
+
+	events := readEvents(t, getEvents(t, server.URL+"/v1/orders/5/events", ""), 10)
+	require.Len(t, events, 1)
+	assert.Equal(t, processStatusJSON{OrderID: 5, Status: "COMPLETED"}, events[0].status)
+
+	events = readEvents(t, getEvents(t, server.URL+"/v1/orders/5/events", "1"), 10)
+	assert.Empty(t, events)
+}
+
+func TestGateway_OrderEventsErrors(t *testing.T) {
+	authenticator, err := NewAuthenticator(AuthConfig{Enabled: true, HMACSecret: testHMACSecret})
+	require.NoError(t, err)
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	orderServer.progress.start(5, 2)
+	orderServer.progress.publish(&pb.ProcessStatus{OrderId: 5, Status: pb.OrderStatus_COMPLETED})
+	server := httptest.NewServer(NewGateway(DefaultConfig().Gateway, quoteServer, orderServer, NewReturnServer(orderServer), authenticator, nil, nil, nil))
+	t.Cleanup(server.Close)
+	token := customerToken(t, 2, time.Hour)
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventId string
+		wantStatus  int
+	}{
+		{"Token in query", "?access_token=" + token, "", http.StatusOK},
+		{"Missing token", "", "", http.StatusUnauthorized},
+		{"Other customer", "?access_token=" + customerToken(t, 1, time.Hour), "", http.StatusNotFound},
+		{"Invalid Last-Event-ID", "?access_token=" + token, "x", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := getEvents(t, server.URL+"/v1/orders/5/events"+test.query, test.lastEventId)
+			assert.Equal(t, test.wantStatus, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"sort"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+)
+
+// JSON bodies of the REST gateway, they are documented in openapi.yaml.
+
+type quoteItemJSON struct {
+	LineID    int32             `json:"line_id"`
+	ProductID int32             `json:"product_id"`
+	Variant   string            `json:"variant,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+	Quantity  int32             `json:"quantity"`
+	Price     float32           `json:"price"`
+}
+
+type quoteJSON struct {
+	CustomerID int32           `json:"customer_id"`
+	Items      []quoteItemJSON `json:"items"`
+}
+
+type addItemRequest struct {
+	ProductID int32             `json:"product_id"`
+	Variant   string            `json:"variant"`
+	Options   map[string]string `json:"options"`
+	Quantity  int32             `json:"quantity"`
+}
+
+type updateItemRequest struct {
+	Quantity int32 `json:"quantity"`
+}
+
+type orderItemJSON struct {
+	LineID    int32             `json:"line_id"`
+	ProductID int32             `json:"product_id"`
+	Variant   string            `json:"variant,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+	Quantity  int32             `json:"quantity"`
+	Price     float32           `json:"price"`
+}
+
+type orderJSON struct {
+	ID         int32           `json:"id"`
+	CustomerID int32           `json:"customer_id"`
+	Status     string          `json:"status"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Total      float32         `json:"total"`
+	Items      []orderItemJSON `json:"items"`
+}
+
+type orderPageJSON struct {
+	Orders     []orderJSON `json:"orders"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+type processStatusJSON struct {
+	OrderID int32  `json:"order_id"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type returnItemRequest struct {
+	LineID   int32 `json:"line_id"`
+	Quantity int32 `json:"quantity"`
+}
+
+type returnRequest struct {
+	Items  []returnItemRequest `json:"items"`
+	Reason string              `json:"reason"`
+	Note   string              `json:"note"`
+}
+
+type placeOrderRequest struct {
+	PriceToken string `json:"price_token"`
+}
+
+type returnNoteRequest struct {
+	Note string `json:"note"`
+}
+
+type returnItemJSON struct {
+	LineID    int32   `json:"line_id"`
+	ProductID int32   `json:"product_id"`
+	Quantity  int32   `json:"quantity"`
+	Price     float32 `json:"price"`
+}
+
+type returnEventJSON struct {
+	Status string    `json:"status"`
+	Note   string    `json:"note,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type returnJSON struct {
+	ID           int32             `json:"id"`
+	OrderID      int32             `json:"order_id"`
+	CustomerID   int32             `json:"customer_id"`
+	Reason       string            `json:"reason"`
+	Status       string            `json:"status"`
+	RefundAmount float32           `json:"refund_amount"`
+	Items        []returnItemJSON  `json:"items"`
+	History      []returnEventJSON `json:"history"`
+}
+
+type fieldViolationJSON struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+type errorJSON struct {
+	Code            string               `json:"code"`
+	Message         string               `json:"message"`
+	Reason          string               `json:"reason,omitempty"`
+	Metadata        map[string]string    `json:"metadata,omitempty"`
+	FieldViolations []fieldViolationJSON `json:"field_violations,omitempty"`
+}
+
+type errorResponse struct {
+	Error errorJSON `json:"error"`
+}
+
+func quoteToJSON(quote *Quote) quoteJSON {
+	items := make([]quoteItemJSON, 0, len(quote.Items))
+	for _, item := range quote.SortedItems() {
+		items = append(items, quoteItemJSON{
+			LineID:    item.LineID,
+			ProductID: item.ProductID,
+			Variant:   item.Variant,
+			Options:   item.Options,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
+	}
+	return quoteJSON{CustomerID: quote.CustomerId, Items: items}
+}
+
+func orderToJSON(order *Order) orderJSON {
+	items := make([]orderItemJSON, 0, len(order.Items))
+	for _, item := range order.SortedItems() {
+		items = append(items, orderItemJSON{
+			LineID:    item.LineID,
+			ProductID: item.ProductID,
+			Variant:   item.Variant,
+			Options:   item.Options,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
+	}
+	return orderJSON{
+		ID:         order.ID,
+		CustomerID: order.CustomerId,
+		Status:     order.Status.String(),
+		CreatedAt:  order.CreatedAt,
+		Total:      order.Total(),
+		Items:      items,
+	}
+}
+
+func processStatusToJSON(processStatus *pb.ProcessStatus) processStatusJSON {
+	return processStatusJSON{
+		OrderID: processStatus.OrderId,
+		Status:  processStatus.Status.String(),
+		Message: processStatus.Message,
+	}
+}
+
+func returnToJSON(ret *Return) returnJSON {
+	items := make([]returnItemJSON, 0, len(ret.Items))
+	for _, item := range ret.Items {
+		items = append(items, returnItemJSON{LineID: item.LineID, ProductID: item.ProductID, Quantity: item.Quantity, Price: item.Price})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].LineID < items[j].LineID
+	})
+	history := make([]returnEventJSON, 0, len(ret.History))
+	for _, event := range ret.History {
+		history = append(history, returnEventJSON{Status: event.Status.String(), Note: event.Note, At: event.At})
+	}
+	return returnJSON{
+		ID:           ret.ID,
+		OrderID:      ret.OrderID,
+		CustomerID:   ret.CustomerId,
+		Reason:       ret.Reason.String(),
+		Status:       ret.Status.String(),
+		RefundAmount: ret.RefundAmount,
+		Items:        items,
+		History:      history,
+	}
+}
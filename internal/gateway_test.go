@@ -0,0 +1,336 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func newTestGateway(t *testing.T, authenticator *Authenticator, authorizer *Authorizer) *httptest.Server {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	config := DefaultConfig().Gateway
+	config.CORS.AllowedOrigins = []string{"https://shop.example.com"}
+	gateway := NewGateway(config, quoteServer, orderServer, NewReturnServer(orderServer), authenticator, authorizer, nil, nil)
+	server := httptest.NewServer(gateway)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// doJSON sends the request with an optional JSON body and bearer token and decodes the JSON response into out.
+func doJSON(t *testing.T, method string, url string, token string, body string, out interface{}) *http.Response {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp
+}
+
+func TestGateway_Quote(t *testing.T) {
+	server := newTestGateway(t, nil, nil)
+	url := server.URL + "/v1/customers/1/quote"
+
+	var quote quoteJSON
+	resp := doJSON(t, http.MethodPost, url+"/items", "", `{"product_id": 101, "quantity": 2}`, &quote)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get(requestIDHeader))
+	assert.Equal(t, quoteJSON{CustomerID: 1, Items: []quoteItemJSON{{LineID: 1, ProductID: 101, Quantity: 2, Price: 10.0}}}, quote)
+
+	resp = doJSON(t, http.MethodPut, url+"/items/101", "", `{"quantity": 3}`, &quote)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), quote.Items[0].Quantity)
+
+	resp = doJSON(t, http.MethodPut, url+"/lines/1", "", `{"quantity": 4}`, &quote)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []quoteItemJSON{{LineID: 1, ProductID: 101, Quantity: 4, Price: 10.0}}, quote.Items)
+
+	resp = doJSON(t, http.MethodGet, url, "", "", &quote)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, quote.Items, 1)
+
+	resp = doJSON(t, http.MethodDelete, url+"/items/101", "", "", &quote)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, quote.Items)
+}
+
+func TestGateway_QuoteLines(t *testing.T) {
+	server := newTestGateway(t, nil, nil)
+	url := server.URL + "/v1/customers/1/quote"
+
+	var quote quoteJSON
+	doJSON(t, http.MethodPost, url+"/items", "", `{"product_id": 101, "variant": "red", "options": {"size": "M"}, "quantity": 1}`, nil)
+	doJSON(t, http.MethodPost, url+"/items", "", `{"product_id": 101, "variant": "blue", "quantity": 1}`, nil)
+	resp := doJSON(t, http.MethodPost, url+"/items", "", `{"product_id": 101, "variant": "red", "options": {"size": "M"}, "quantity": 2}`, &quote)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []quoteItemJSON{
+		{LineID: 1, ProductID: 101, Variant: "red", Options: map[string]string{"size": "M"}, Quantity: 3, Price: 10.0},
+		{LineID: 2, ProductID: 101, Variant: "blue", Quantity: 1, Price: 10.0},
+	}, quote.Items)
+
+	resp = doJSON(t, http.MethodPut, url+"/lines/2", "", `{"quantity": 5}`, &quote)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(5), quote.Items[1].Quantity)
+
+	quote = quoteJSON{}
+	resp = doJSON(t, http.MethodDelete, url+"/lines/1", "", "", &quote)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []quoteItemJSON{{LineID: 2, ProductID: 101, Variant: "blue", Quantity: 5, Price: 10.0}}, quote.Items)
+
+	resp = doJSON(t, http.MethodDelete, url+"/lines/1", "", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGateway_Errors(t *testing.T) {
+	server := newTestGateway(t, nil, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantReason string
+		wantField  string
+	}{
+		{"Invalid id", http.MethodGet, "/v1/customers/abc/quote", "", http.StatusBadRequest, "InvalidArgument", "INVALID_ID", "customerId"},
+		{"Unknown body field", http.MethodPost, "/v1/customers/1/quote/items", `{"product": 1}`, http.StatusBadRequest, "InvalidArgument", "INVALID_BODY", "body"},
+		{"Quote not found", http.MethodPut, "/v1/customers/1/quote/items/101", `{"quantity": 1}`, http.StatusNotFound, "NotFound", "QUOTE_NOT_FOUND", ""},
+		{"Quote line not found", http.MethodPut, "/v1/customers/1/quote/lines/7", `{"quantity": 1}`, http.StatusNotFound, "NotFound", "QUOTE_LINE_NOT_FOUND", ""},
+		{"Invalid page size", http.MethodGet, "/v1/customers/1/orders?page_size=x", "", http.StatusBadRequest, "InvalidArgument", "INVALID_NUMBER", "page_size"},
+		{"Invalid status filter", http.MethodGet, "/v1/customers/1/orders?status=lost", "", http.StatusBadRequest, "InvalidArgument", "INVALID_STATUS", "status"},
+		{"Order not found", http.MethodGet, "/v1/orders/42", "", http.StatusNotFound, "NotFound", "ORDER_NOT_FOUND", ""},
+		{"Return not found", http.MethodGet, "/v1/returns/42", "", http.StatusNotFound, "NotFound", "RETURN_NOT_FOUND", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body errorResponse
+			resp := doJSON(t, test.method, server.URL+test.path, "", test.body, &body)
+			assert.Equal(t, test.wantStatus, resp.StatusCode)
+			assert.Equal(t, test.wantCode, body.Error.Code)
+			assert.Equal(t, test.wantReason, body.Error.Reason)
+			if test.wantField == "" {
+				assert.Empty(t, body.Error.FieldViolations)
+				return
+			}
+			require.Len(t, body.Error.FieldViolations, 1)
+			assert.Equal(t, test.wantField, body.Error.FieldViolations[0].Field)
+		})
+	}
+}
+
+func TestGateway_OrdersAndReturns(t *testing.T) {
+	server := newTestGateway(t, nil, nil)
+
+	doJSON(t, http.MethodPost, server.URL+"/v1/customers/1/quote/items", "", `{"product_id": 101, "quantity": 2}`, nil)
+
+	resp, err := http.Post(server.URL+"/v1/customers/1/orders", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	var statuses []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var processStatus processStatusJSON
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &processStatus))
+		assert.Equal(t, int32(1), processStatus.OrderID)
+		statuses = append(statuses, processStatus.Status)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, "COMPLETED", statuses[len(statuses)-1])
+
+	var page orderPageJSON
+	doJSON(t, http.MethodGet, server.URL+"/v1/customers/1/orders?status=completed&product_id=101", "", "", &page)
+	require.Len(t, page.Orders, 1)
+	assert.Equal(t, float32(20.0), page.Orders[0].Total)
+	doJSON(t, http.MethodGet, server.URL+"/v1/customers/1/orders?status=started", "", "", &page)
+	assert.Empty(t, page.Orders)
+
+	var order orderJSON
+	resp = doJSON(t, http.MethodGet, server.URL+"/v1/orders/1", "", "", &order)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, order.Items, 1)
+	lineId := order.Items[0].LineID
+
+	var ret returnJSON
+	body := fmt.Sprintf(`{"items": [{"line_id": %d, "quantity": 1}], "reason": "damaged", "note": "broken"}`, lineId)
+	resp = doJSON(t, http.MethodPost, server.URL+"/v1/orders/1/returns", "", body, &ret)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "DAMAGED", ret.Reason)
+	assert.Equal(t, "REQUESTED", ret.Status)
+
+	resp = doJSON(t, http.MethodPost, server.URL+"/v1/returns/1/approve", "", `{"note": "ok"}`, &ret)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "APPROVED", ret.Status)
+	resp = doJSON(t, http.MethodPost, server.URL+"/v1/returns/1/receive", "", "", &ret)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "RECEIVED", ret.Status)
+
+	var returns map[string][]returnJSON
+	doJSON(t, http.MethodGet, server.URL+"/v1/orders/1/returns", "", "", &returns)
+	require.Len(t, returns["returns"], 1)
+	assert.Len(t, returns["returns"][0].History, 3)
+}
+
+func TestGateway_PlaceOrderEmptyQuote(t *testing.T) {
+	server := newTestGateway(t, nil, nil)
+
+	resp, err := http.Post(server.URL+"/v1/customers/1/orders", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	// The checkout has started streaming, so the failure is reported as the last status.
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var processStatus processStatusJSON
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&processStatus))
+	assert.Equal(t, processStatusJSON{Status: "ERROR", Message: "quote is empty"}, processStatus)
+}
+
+func TestGateway_PlaceOrderPriceToken(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(12.0), nil)
+	server := httptest.NewServer(NewGateway(DefaultConfig().Gateway, quoteServer, orderServer, NewReturnServer(orderServer), nil, nil, nil, nil))
+	t.Cleanup(server.Close)
+	doJSON(t, http.MethodPost, server.URL+"/v1/customers/1/quote/items", "", `{"product_id": 101, "quantity": 1}`, nil)
+
+	var processStatus processStatusJSON
+	resp := doJSON(t, http.MethodPost, server.URL+"/v1/customers/1/orders", "", "", &processStatus)
+	assert.Equal(t, "ERROR", processStatus.Status)
+	token := resp.Header.Get("Price-Token")
+	require.NotEmpty(t, token)
+	assert.Contains(t, processStatus.Message, token)
+
+	resp = doJSON(t, http.MethodPost, server.URL+"/v1/customers/1/orders", "", `{"price_token": "other"}`, &processStatus)
+	assert.Equal(t, "ERROR", processStatus.Status)
+	assert.Equal(t, token, resp.Header.Get("Price-Token"))
+
+	resp, err := http.Post(server.URL+"/v1/customers/1/orders", "application/json", strings.NewReader(`{"price_token": "`+token+`"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		require.NoError(t, decoder.Decode(&processStatus))
+	}
+	assert.Equal(t, "COMPLETED", processStatus.Status)
+}
+
+func TestGateway_AuthenticationAndAuthorization(t *testing.T) {
+	authenticator, err := NewAuthenticator(AuthConfig{Enabled: true, HMACSecret: testHMACSecret})
+	require.NoError(t, err)
+	authorizer, err := NewAuthorizer(DefaultPolicies(), nil)
+	require.NoError(t, err)
+	server := newTestGateway(t, authenticator, authorizer)
+	token := customerToken(t, 1, time.Hour)
+	serviceToken := signHMAC(t, customerClaims{
+		Roles: []string{RoleService},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "inventory",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"Missing token", "/v1/customers/1/quote/items", "", http.StatusUnauthorized},
+		{"Own quote", "/v1/customers/1/quote/items", token, http.StatusOK},
+		{"Other customer", "/v1/customers/2/quote/items", token, http.StatusForbidden},
+		{"Role without policy", "/v1/customers/1/quote/items", serviceToken, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body errorResponse
+			resp := doJSON(t, http.MethodPost, server.URL+test.path, test.token, `{"product_id": 101, "quantity": 1}`, &body)
+			assert.Equal(t, test.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestGateway_CORS(t *testing.T) {
+	server := newTestGateway(t, nil, nil)
+
+	preflight, err := http.NewRequest(http.MethodOptions, server.URL+"/v1/customers/1/quote/items", nil)
+	require.NoError(t, err)
+	preflight.Header.Set("Origin", "https://shop.example.com")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err := http.DefaultClient.Do(preflight)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "https://shop.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Authorization, Content-Type, X-Request-Id, Last-Event-ID", resp.Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", resp.Header.Get("Access-Control-Max-Age"))
+
+	tests := []struct {
+		name       string
+		origin     string
+		wantOrigin string
+	}{
+		{"Allowed origin", "https://shop.example.com", "https://shop.example.com"},
+		{"Other origin", "https://evil.example.com", ""},
+		{"No origin", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/orders/1", nil)
+			require.NoError(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.wantOrigin, resp.Header.Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestGateway_OpenAPIDocument(t *testing.T) {
+	server := newTestGateway(t, nil, nil)
+
+	resp, err := http.Get(server.URL + "/openapi.yaml")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var document struct {
+		Paths map[string]map[string]interface{} `yaml:"paths"`
+	}
+	require.NoError(t, yaml.NewDecoder(resp.Body).Decode(&document))
+	for _, route := range (&Gateway{}).routes() {
+		method, path, _ := strings.Cut(route.pattern, " ")
+		operations, exists := document.Paths[path]
+		if assert.True(t, exists, "%s is not documented", path) {
+			assert.Contains(t, operations, strings.ToLower(method), "%s is not documented", route.pattern)
+		}
+	}
+}
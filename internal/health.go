@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthCheck reports whether a dependency of the service is usable.
+type HealthCheck func(ctx context.Context) error
+
+type dependencyCheck struct {
+	name     string
+	check    HealthCheck
+	services []string
+}
+
+// HealthChecker periodically runs the dependency checks and publishes the result
+// on the standard grpc.health.v1 server. A service is SERVING only when all of its
+// dependencies pass, the overall ("") status requires every check to pass.
+type HealthChecker struct {
+	health   *health.Server
+	checks   []dependencyCheck
+	services map[string]bool
+	failing  map[string]bool
+	interval time.Duration
+	timeout  time.Duration
+	lock     sync.Mutex
+}
+
+func NewHealthChecker(healthServer *health.Server, interval time.Duration, timeout time.Duration) *HealthChecker {
+	return &HealthChecker{
+		health:   healthServer,
+		services: make(map[string]bool),
+		failing:  make(map[string]bool),
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+// AddCheck registers a dependency check for the given gRPC services.
+func (h *HealthChecker) AddCheck(name string, check HealthCheck, services ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.checks = append(h.checks, dependencyCheck{name, check, services})
+	for _, service := range services {
+		h.services[service] = true
+	}
+}
+
+// Run checks the dependencies until the context is cancelled.
+func (h *HealthChecker) Run(ctx context.Context) {
+	h.CheckOnce(ctx)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.CheckOnce(ctx)
+		}
+	}
+}
+
+// CheckOnce runs every check and updates the serving status of all services.
+func (h *HealthChecker) CheckOnce(ctx context.Context) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	unhealthy := make(map[string]bool)
+	overall := healthpb.HealthCheckResponse_SERVING
+	for _, dependency := range h.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		err := dependency.check(checkCtx)
+		cancel()
+
+		if err != nil {
+			if !h.failing[dependency.name] {
+				slog.Warn("health check failed", "dependency", dependency.name, "error", err)
+			}
+			overall = healthpb.HealthCheckResponse_NOT_SERVING
+			for _, service := range dependency.services {
+				unhealthy[service] = true
+			}
+		} else if h.failing[dependency.name] {
+			slog.Info("health check recovered", "dependency", dependency.name)
+		}
+		h.failing[dependency.name] = err != nil
+	}
+
+	for service := range h.services {
+		status := healthpb.HealthCheckResponse_SERVING
+		if unhealthy[service] {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		h.health.SetServingStatus(service, status)
+	}
+	h.health.SetServingStatus("", overall)
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func servingStatus(t *testing.T, healthServer *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	assert.NoError(t, err)
+	return resp.Status
+}
+
+func TestHealthChecker_CheckOnce(t *testing.T) {
+	var catalogErr error
+	healthServer := health.NewServer()
+	checker := NewHealthChecker(healthServer, time.Second, time.Second)
+	checker.AddCheck("catalog", func(ctx context.Context) error { return catalogErr }, "quote", "order")
+	checker.AddCheck("storage", func(ctx context.Context) error { return nil }, "order", "returns")
+
+	checker.CheckOnce(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, healthServer, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, healthServer, "quote"))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, healthServer, "order"))
+
+	catalogErr = fmt.Errorf("connection refused")
+	checker.CheckOnce(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, healthServer, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, healthServer, "quote"))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, healthServer, "order"))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, healthServer, "returns"))
+
+	catalogErr = nil
+	checker.CheckOnce(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, healthServer, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, healthServer, "quote"))
+}
+
+func TestHealthChecker_CheckTimeout(t *testing.T) {
+	healthServer := health.NewServer()
+	checker := NewHealthChecker(healthServer, time.Second, 10*time.Millisecond)
+	checker.AddCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, "quote")
+
+	checker.CheckOnce(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, healthServer, "quote"))
+}
+
+func TestHealthChecker_Run(t *testing.T) {
+	healthServer := health.NewServer()
+	checker := NewHealthChecker(healthServer, 5*time.Millisecond, time.Second)
+	checks := make(chan struct{}, 10)
+	checker.AddCheck("counter", func(ctx context.Context) error {
+		checks <- struct{}{}
+		return nil
+	}, "quote")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		checker.Run(ctx)
+		close(done)
+	}()
+
+	<-checks
+	<-checks
+	cancel()
+	<-done
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, healthServer, "quote"))
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDHeader is read from the incoming metadata and echoed in the response header,
+// a new ID is generated when the caller does not send one.
+const requestIDHeader = "x-request-id"
+
+// NewLogger creates the logger of the service writing text or JSON lines to w.
+func NewLogger(config LoggingConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", config.Level)
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch config.Format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", config.Format)
+}
+
+// requestLogger is the logger of a single RPC. Fields are added as they become known,
+// e.g. the order ID during a checkout, so later lines carry them too.
+type requestLogger struct {
+	logger *slog.Logger
+	lock   sync.Mutex
+}
+
+type requestLoggerKey struct{}
+
+func contextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, requestLoggerKey{}, &requestLogger{logger: logger})
+}
+
+// loggerFromContext returns the logger of the RPC, or the default logger outside of one.
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	rl, ok := ctx.Value(requestLoggerKey{}).(*requestLogger)
+	if !ok {
+		return slog.Default()
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return rl.logger
+}
+
+// addLogFields adds the key value pairs to every following line logged for the RPC.
+func addLogFields(ctx context.Context, args ...any) {
+	rl, ok := ctx.Value(requestLoggerKey{}).(*requestLogger)
+	if !ok {
+		return
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.logger = rl.logger.With(args...)
+}
+
+// requestFields are the customer and order IDs found in a request message.
+func requestFields(req interface{}) []any {
+	switch r := req.(type) {
+	case *pb.CustomerId:
+		return []any{"customer_id", r.Id}
+	case *pb.OrderId:
+		return []any{"order_id", r.Id}
+	case interface{ GetCustomerId() int32 }:
+		return []any{"customer_id", r.GetCustomerId()}
+	}
+	return nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(requestIDHeader); len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	return newRequestID()
+}
+
+// RequestLogger attaches a logger with the request ID and method to every RPC and
+// logs the outcome of the call with its duration and status code.
+type RequestLogger struct {
+	logger *slog.Logger
+}
+
+func NewRequestLogger(logger *slog.Logger) *RequestLogger {
+	return &RequestLogger{logger: logger}
+}
+
+func (l *RequestLogger) begin(ctx context.Context, fullMethod string) (context.Context, metadata.MD) {
+	id := requestID(ctx)
+	return contextWithLogger(ctx, l.logger.With("request_id", id, "method", fullMethod)), metadata.Pairs(requestIDHeader, id)
+}
+
+func (l *RequestLogger) finish(ctx context.Context, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK:
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	args := []any{"duration", time.Since(start), "code", code.String()}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	loggerFromContext(ctx).Log(ctx, level, "rpc finished", args...)
+}
+
+func (l *RequestLogger) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, header := l.begin(ctx, info.FullMethod)
+		_ = grpc.SetHeader(ctx, header)
+		addLogFields(ctx, requestFields(req)...)
+		resp, err := handler(ctx, req)
+		l.finish(ctx, start, err)
+		return resp, err
+	}
+}
+
+func (l *RequestLogger) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, header := l.begin(ss.Context(), info.FullMethod)
+		_ = ss.SetHeader(header)
+		err := handler(srv, &loggingServerStream{contextServerStream{ss, ctx}})
+		l.finish(ctx, start, err)
+		return err
+	}
+}
+
+// loggingServerStream adds the IDs of the received messages to the request logger.
+type loggingServerStream struct {
+	contextServerStream
+}
+
+func (s *loggingServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	addLogFields(s.ctx, requestFields(m)...)
+	return nil
+}
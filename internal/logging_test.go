@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	lines := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		entry := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      LoggingConfig
+		expected    string
+		expectError bool
+	}{
+		{"Text", LoggingConfig{Format: "text", Level: "info"}, "level=INFO msg=hello", false},
+		{"JSON", LoggingConfig{Format: "json", Level: "info"}, `"msg":"hello"`, false},
+		{"Level filters", LoggingConfig{Format: "text", Level: "warn"}, "", false},
+		{"Unknown format", LoggingConfig{Format: "xml", Level: "info"}, "", true},
+		{"Unknown level", LoggingConfig{Format: "text", Level: "loud"}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger, err := NewLogger(test.config, &out)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			logger.Info("hello")
+			if test.expected == "" {
+				assert.Empty(t, out.String())
+			} else {
+				assert.Contains(t, out.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestRequestLogger_UnaryServerInterceptor(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(LoggingConfig{Format: "json", Level: "info"}, &out)
+	require.NoError(t, err)
+	interceptor := NewRequestLogger(logger).UnaryServerInterceptor()
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDHeader, "req-1"))
+	_, err = interceptor(ctx, &pb.ProductRequest{CustomerId: 3}, &grpc.UnaryServerInfo{FullMethod: "/sale.QuoteService/AddProduct"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			loggerFromContext(ctx).Info("handling")
+			return nil, status.Error(codes.NotFound, "quote not found")
+		})
+	assert.Error(t, err)
+
+	lines := logLines(t, &out)
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.Equal(t, "req-1", line["request_id"])
+		assert.Equal(t, "/sale.QuoteService/AddProduct", line["method"])
+		assert.Equal(t, 3.0, line["customer_id"])
+	}
+	assert.Equal(t, "rpc finished", lines[1]["msg"])
+	assert.Equal(t, "WARN", lines[1]["level"])
+	assert.Equal(t, "NotFound", lines[1]["code"])
+	assert.Contains(t, lines[1], "duration")
+}
+
+func TestRequestLogger_StreamServerInterceptor(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(LoggingConfig{Format: "json", Level: "info"}, &out)
+	require.NoError(t, err)
+	interceptor := NewRequestLogger(logger).StreamServerInterceptor()
+
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(context.Background())
+	stream.On("SetHeader", mock.Anything).Return(nil)
+	stream.On("RecvMsg", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(0).(*pb.CustomerId).Id = 4
+	})
+
+	err = interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/sale.OrderService/PlaceOrder"}, func(srv interface{}, ss grpc.ServerStream) error {
+		if err := ss.RecvMsg(&pb.CustomerId{}); err != nil {
+			return err
+		}
+		addLogFields(ss.Context(), "order_id", 9)
+		loggerFromContext(ss.Context()).Info("sending order process status")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	lines := logLines(t, &out)
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.NotEmpty(t, line["request_id"])
+		assert.Equal(t, 4.0, line["customer_id"])
+		assert.Equal(t, 9.0, line["order_id"])
+	}
+	assert.Equal(t, "OK", lines[1]["code"])
+	assert.Equal(t, "INFO", lines[1]["level"])
+	header := stream.Calls[1].Arguments.Get(0).(metadata.MD)
+	assert.Equal(t, []string{lines[0]["request_id"].(string)}, header.Get(requestIDHeader))
+}
+
+func TestLoggerFromContext_Default(t *testing.T) {
+	assert.NotNil(t, loggerFromContext(context.Background()))
+	assert.NotPanics(t, func() {
+		addLogFields(context.Background(), "order_id", 1)
+	})
+}
@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Checkout failure reasons, the values of the reason label of sale_checkout_failures_total.
+const (
+	CheckoutShuttingDown       = "shutting_down"
+	CheckoutEmptyQuote         = "empty_quote"
+	CheckoutCatalogUnavailable = "catalog_unavailable"
+	CheckoutPriceChanged       = "price_changed"
+	CheckoutStreamBroken       = "stream_broken"
+	CheckoutTooManyCheckouts   = "too_many_checkouts"
+	CheckoutDeadlineExceeded   = "deadline_exceeded"
+)
+
+// Metrics holds the Prometheus collectors of the service. A nil *Metrics records
+// nothing, so servers built without metrics need no special casing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	grpcHandled  *prometheus.CounterVec
+	grpcDuration *prometheus.HistogramVec
+
+	cartsCreated     prometheus.Counter
+	itemsAdded       prometheus.Counter
+	ordersPlaced     prometheus.Counter
+	checkoutDuration *prometheus.HistogramVec
+	checkoutFailures *prometheus.CounterVec
+	orderValue       prometheus.Histogram
+
+	catalogDuration *prometheus.HistogramVec
+	catalogErrors   *prometheus.CounterVec
+
+	outboxPublished *prometheus.CounterVec
+	outboxFailures  *prometheus.CounterVec
+
+	permissionDenied *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		grpcHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "RPCs completed on the server, by method and status code.",
+		}, []string{"method", "code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time spent handling RPCs, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		cartsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sale_carts_created_total",
+			Help: "Quotes that got their first item.",
+		}),
+		itemsAdded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sale_quote_items_added_total",
+			Help: "Units of products added to quotes.",
+		}),
+		ordersPlaced: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sale_orders_placed_total",
+			Help: "Orders created from a quote.",
+		}),
+		checkoutDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "sale_checkout_duration_seconds",
+			Help:    "Duration of PlaceOrder, by outcome.",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"outcome"}),
+		checkoutFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sale_checkout_failures_total",
+			Help: "Failed checkouts, by reason.",
+		}, []string{"reason"}),
+		orderValue: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "sale_order_value",
+			Help:    "Total value of placed orders.",
+			Buckets: []float64{10, 25, 50, 100, 250, 500, 1000, 2500},
+		}),
+		catalogDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "catalog_client_request_duration_seconds",
+			Help:    "Latency of catalog calls, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		catalogErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "catalog_client_errors_total",
+			Help: "Failed catalog calls, by method and status code.",
+		}, []string{"method", "code"}),
+		outboxPublished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sale_outbox_published_total",
+			Help: "Outbox messages published, by subject.",
+		}, []string{"subject"}),
+		outboxFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sale_outbox_publish_failures_total",
+			Help: "Failed attempts to publish outbox messages, by subject.",
+		}, []string{"subject"}),
+		permissionDenied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sale_permission_denied_total",
+			Help: "Calls denied by the role policies, by method.",
+		}, []string{"method"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.grpcHandled, m.grpcDuration,
+		m.cartsCreated, m.itemsAdded, m.ordersPlaced, m.checkoutDuration, m.checkoutFailures, m.orderValue,
+		m.catalogDuration, m.catalogErrors,
+		m.outboxPublished, m.outboxFailures,
+		m.permissionDenied,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) CartCreated() {
+	if m == nil {
+		return
+	}
+	m.cartsCreated.Inc()
+}
+
+func (m *Metrics) ItemsAdded(quantity int32) {
+	if m == nil {
+		return
+	}
+	m.itemsAdded.Add(float64(quantity))
+}
+
+// OrderPlaced records a successful checkout that started at start.
+func (m *Metrics) OrderPlaced(value float32, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.ordersPlaced.Inc()
+	m.orderValue.Observe(float64(value))
+	m.checkoutDuration.WithLabelValues("completed").Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) OutboxPublished(subject string) {
+	if m == nil {
+		return
+	}
+	m.outboxPublished.WithLabelValues(subject).Inc()
+}
+
+func (m *Metrics) OutboxPublishFailed(subject string) {
+	if m == nil {
+		return
+	}
+	m.outboxFailures.WithLabelValues(subject).Inc()
+}
+
+func (m *Metrics) PermissionDenied(method string) {
+	if m == nil {
+		return
+	}
+	m.permissionDenied.WithLabelValues(method).Inc()
+}
+
+// CheckoutFailed records a checkout that started at start and failed for the reason.
+func (m *Metrics) CheckoutFailed(reason string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.checkoutFailures.WithLabelValues(reason).Inc()
+	m.checkoutDuration.WithLabelValues("failed").Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) observeRPC(method string, start time.Time, err error) {
+	m.grpcHandled.WithLabelValues(method, status.Code(err).String()).Inc()
+	m.grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observeRPC(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observeRPC(info.FullMethod, start, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor measures the calls of a client connection, it is installed
+// on the catalog connection.
+func (m *Metrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		m.catalogDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.catalogErrors.WithLabelValues(method, status.Code(err).String()).Inc()
+		}
+		return err
+	}
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pbc "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/catalog"
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetrics_ServerInterceptors(t *testing.T) {
+	metrics := NewMetrics()
+	unary := metrics.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/sale.QuoteService/GetQuote"}
+
+	_, _ = unary(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	_, _ = unary(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	stream := &MockOrderService_PlaceOrderServer{}
+	_ = metrics.StreamServerInterceptor()(nil, stream, &grpc.StreamServerInfo{FullMethod: "/sale.OrderService/PlaceOrder"}, func(srv interface{}, ss grpc.ServerStream) error {
+		return nil
+	})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.grpcHandled.WithLabelValues("/sale.QuoteService/GetQuote", "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.grpcHandled.WithLabelValues("/sale.QuoteService/GetQuote", "NotFound")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.grpcHandled.WithLabelValues("/sale.OrderService/PlaceOrder", "OK")))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.grpcDuration))
+}
+
+func TestMetrics_UnaryClientInterceptor(t *testing.T) {
+	metrics := NewMetrics()
+	interceptor := metrics.UnaryClientInterceptor()
+	method := "/catalog.ProductInfo/GetProductInfo"
+
+	_ = interceptor(context.Background(), method, nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	})
+	_ = interceptor(context.Background(), method, nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.Unavailable, "down")
+	})
+
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.catalogDuration))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.catalogErrors.WithLabelValues(method, "Unavailable")))
+}
+
+func TestMetrics_QuoteServer(t *testing.T) {
+	metrics := NewMetrics()
+	quoteServer, _ := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), metrics)
+
+	_, err := quoteServer.AddProduct(context.Background(), &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 2})
+	assert.NoError(t, err)
+	_, err = quoteServer.AddProduct(context.Background(), &pb.ProductRequest{CustomerId: 1, ProductId: 102, Quantity: 3})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.cartsCreated))
+	assert.Equal(t, 5.0, testutil.ToFloat64(metrics.itemsAdded))
+}
+
+func TestMetrics_CartCreatedOnceConcurrently(t *testing.T) {
+	metrics := NewMetrics()
+	quoteServer, _ := NewQuoteServer(QuoteConfig{}, fixedPriceCatalog{10}, metrics)
+
+	var wg sync.WaitGroup
+	for productId := int32(1); productId <= 8; productId++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := quoteServer.AddProduct(context.Background(), &pb.ProductRequest{CustomerId: 1, ProductId: productId, Quantity: 1})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.cartsCreated))
+}
+
+func TestMetrics_OrderServer(t *testing.T) {
+	metrics := NewMetrics()
+	catalogClient := &MockCatalogClient{}
+	catalogClient.On("GetProductInfo", uint64(1)).Return(&pbc.Product{Id: 1, Price: 10}, nil)
+	quoteStorage := &QuoteStorage{
+		quotes: map[int32]*Quote{
+			1: {CustomerId: 1, Items: map[int32]*QuoteItem{1: {LineID: 1, ProductID: 1, Quantity: 3, Price: 10}}},
+			2: {CustomerId: 2, Items: map[int32]*QuoteItem{1: {LineID: 1, ProductID: 1, Quantity: 1, Price: 8}}},
+		},
+	}
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, catalogClient, metrics)
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Send", mock.Anything).Return(nil)
+	stream.On("SetHeader", mock.Anything).Return(nil)
+	stream.On("Context").Return(context.Background())
+
+	assert.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream))
+	assert.Error(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 2}, stream))
+	assert.Error(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 3}, stream))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ordersPlaced))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.checkoutFailures.WithLabelValues(CheckoutPriceChanged)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.checkoutFailures.WithLabelValues(CheckoutEmptyQuote)))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.checkoutDuration))
+
+	expected := `
+		# HELP sale_order_value Total value of placed orders.
+		# TYPE sale_order_value histogram
+		sale_order_value_bucket{le="10"} 0
+		sale_order_value_bucket{le="25"} 0
+		sale_order_value_bucket{le="50"} 1
+		sale_order_value_bucket{le="100"} 1
+		sale_order_value_bucket{le="250"} 1
+		sale_order_value_bucket{le="500"} 1
+		sale_order_value_bucket{le="1000"} 1
+		sale_order_value_bucket{le="2500"} 1
+		sale_order_value_bucket{le="+Inf"} 1
+		sale_order_value_sum 30
+		sale_order_value_count 1
+	`
+	assert.NoError(t, testutil.CollectAndCompare(metrics.orderValue, strings.NewReader(expected)))
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.CartCreated()
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "sale_carts_created_total 1")
+	assert.Contains(t, recorder.Body.String(), "go_goroutines")
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var metrics *Metrics
+	assert.NotPanics(t, func() {
+		metrics.CartCreated()
+		metrics.ItemsAdded(1)
+		metrics.OrderPlaced(10, time.Now())
+		metrics.CheckoutFailed(CheckoutEmptyQuote, time.Now())
+	})
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSPublisher publishes the messages on their subjects to a NATS server. The message
+// key goes in the Nats-Msg-Id header, so JetStream streams drop the duplicates.
+type NATSPublisher struct {
+	conn   *nats.Conn
+	stream jetstream.JetStream
+	config NATSConfig
+}
+
+func NewNATSPublisher(config NATSConfig) (*NATSPublisher, error) {
+	conn, err := nats.Connect(config.URL,
+		nats.Name("sale"),
+		nats.Timeout(config.Timeout),
+		nats.MaxReconnects(-1),
+		// Keep failing the publishes while the server is away instead of buffering them,
+		// the outbox keeps the messages until they are acknowledged.
+		nats.ReconnectBufSize(-1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to NATS at %s: %v", config.URL, err)
+	}
+	p := &NATSPublisher{conn: conn, config: config}
+	if config.JetStream {
+		if p.stream, err = jetstream.New(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Publish waits for the JetStream acknowledgement, or with core NATS for the server to
+// have received the message. Core NATS subscribers that are offline miss it.
+func (p *NATSPublisher) Publish(ctx context.Context, message OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	msg := nats.NewMsg(message.Subject)
+	msg.Header.Set(jetstream.MsgIDHeader, message.Key())
+	msg.Data = message.Payload
+	if p.stream != nil {
+		_, err := p.stream.PublishMsg(ctx, msg)
+		return err
+	}
+	if err := p.conn.PublishMsg(msg); err != nil {
+		return err
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
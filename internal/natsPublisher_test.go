@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNATSServer runs an embedded NATS server with JetStream on a free port.
+func startNATSServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func TestNATSPublisher_Core(t *testing.T) {
+	s := startNATSServer(t)
+	conn, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer conn.Close()
+	subscription, err := conn.SubscribeSync("sale.order.>")
+	require.NoError(t, err)
+	require.NoError(t, conn.Flush())
+
+	publisher, err := NewNATSPublisher(NATSConfig{URL: s.ClientURL(), Timeout: time.Second})
+	require.NoError(t, err)
+	defer publisher.Close()
+	require.NoError(t, publisher.Publish(context.Background(), testMessage(7, SubjectOrderPlaced)))
+
+	msg, err := subscription.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, SubjectOrderPlaced, msg.Subject)
+	assert.Equal(t, "7", msg.Header.Get(jetstream.MsgIDHeader))
+	assert.JSONEq(t, `{"type":"OrderPlaced","order_id":1}`, string(msg.Data))
+}
+
+func TestNATSPublisher_JetStreamDropsDuplicates(t *testing.T) {
+	s := startNATSServer(t)
+	conn, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer conn.Close()
+	js, err := jetstream.New(conn)
+	require.NoError(t, err)
+	ctx := context.Background()
+	stream, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "SALE", Subjects: []string{"sale.order.>"}})
+	require.NoError(t, err)
+
+	publisher, err := NewNATSPublisher(NATSConfig{URL: s.ClientURL(), JetStream: true, Timeout: time.Second})
+	require.NoError(t, err)
+	defer publisher.Close()
+	require.NoError(t, publisher.Publish(ctx, testMessage(1, SubjectOrderPlaced)))
+	require.NoError(t, publisher.Publish(ctx, testMessage(2, SubjectOrderStatusChanged)))
+	// A message published again after a lost acknowledgement is stored once.
+	require.NoError(t, publisher.Publish(ctx, testMessage(1, SubjectOrderPlaced)))
+
+	info, err := stream.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+}
+
+func TestNATSPublisher_Unavailable(t *testing.T) {
+	s := startNATSServer(t)
+	publisher, err := NewNATSPublisher(NATSConfig{URL: s.ClientURL(), JetStream: true, Timeout: 200 * time.Millisecond})
+	require.NoError(t, err)
+	defer publisher.Close()
+
+	// Without a stream on the subject nothing acknowledges the message.
+	assert.Error(t, publisher.Publish(context.Background(), testMessage(1, SubjectOrderPlaced)))
+
+	s.Shutdown()
+	assert.Error(t, publisher.Publish(context.Background(), testMessage(1, SubjectOrderPlaced)))
+
+	_, err = NewNATSPublisher(NATSConfig{URL: s.ClientURL(), Timeout: 200 * time.Millisecond})
+	assert.Error(t, err)
+}
@@ -2,15 +2,23 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type OrderItem struct {
+	LineID    int32
 	ProductID int32
+	Variant   string
+	Options   map[string]string
 	Quantity  int32
 	Price     float32
 }
@@ -19,25 +27,73 @@ type Order struct {
 	ID         int32
 	Items      map[int32]*OrderItem
 	CustomerId int32
+	Status     pb.OrderStatus
+	CreatedAt  time.Time
 }
 
+// checkoutSteps is the number of statuses a successful checkout goes through.
+const checkoutSteps = 4
+
 type OrderServer struct {
 	pb.UnimplementedOrderServiceServer
 	orders           map[int32]map[int32]*Order
 	customerOrderMap map[int32]int32
-	orderLock        sync.RWMutex
-	quoteStorage     QuoteStorageInterface
-	catalogClient    CatalogClientInterface
+	// orderLock guards the order maps and statuses. It is held briefly, checkouts lock
+	// the quote of their customer instead.
+	orderLock     sync.RWMutex
+	quoteStorage  QuoteStorageInterface
+	catalogClient CatalogClientInterface
+	checkouts     sync.WaitGroup
+	checkoutLock  sync.Mutex
+	draining      bool
+	// customerCheckouts counts the in-flight checkouts of each customer.
+	customerCheckouts map[int32]int
+	config            OrderConfig
+	metrics           *Metrics
+	progress          *OrderProgress
+	// events records the placed orders and their status changes, nil without an event log.
+	events EventLog
+	// outbox holds the messages of the order changes for other services, nil without one.
+	outbox Outbox
+	// lastOrderID is the ID of the latest order placed, guarded by orderLock.
+	lastOrderID int32
 }
 
-func NewOrderServer(quoteStorage QuoteStorageInterface, catalogClient CatalogClientInterface) *OrderServer {
+func NewOrderServer(config OrderConfig, quoteStorage QuoteStorageInterface, catalogClient CatalogClientInterface, metrics *Metrics) *OrderServer {
 	return &OrderServer{
 		orders:           make(map[int32]map[int32]*Order),
 		customerOrderMap: make(map[int32]int32),
 		orderLock:        sync.RWMutex{},
 		quoteStorage:     quoteStorage,
 		catalogClient:    catalogClient,
+		config:           config,
+		metrics:          metrics,
+		progress:         NewOrderProgress(config.ProgressRetention),
+	}
+}
+
+// Total is the value of the order, the sum of the line prices times their quantities.
+func (o *Order) Total() float32 {
+	var total float32
+	for _, item := range o.Items {
+		total += item.Price * float32(item.Quantity)
 	}
+	return total
+}
+
+// SortedItems returns the order items in the order of the quote lines they were created from.
+func (o *Order) SortedItems() []*OrderItem {
+	items := make([]*OrderItem, 0, len(o.Items))
+	for _, item := range o.Items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].LineID != items[j].LineID {
+			return items[i].LineID < items[j].LineID
+		}
+		return items[i].ProductID < items[j].ProductID
+	})
+	return items
 }
 
 func orderToProto(order *Order) *pb.Order {
@@ -46,23 +102,13 @@ func orderToProto(order *Order) *pb.Order {
 	protoOrder.CustomerId = order.CustomerId
 	protoOrder.Items = make([]*pb.OrderItem, 0)
 
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	for _, item := range order.Items {
-		wg.Add(1)
-		go func(item *OrderItem) {
-			defer wg.Done()
-
-			mu.Lock()
-			protoOrder.Items = append(protoOrder.Items, &pb.OrderItem{
-				ProductId: item.ProductID,
-				Quantity:  item.Quantity,
-				Price:     item.Price,
-			})
-			mu.Unlock()
-		}(item)
+	for _, item := range order.SortedItems() {
+		protoOrder.Items = append(protoOrder.Items, &pb.OrderItem{
+			ProductId: item.ProductID,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
 	}
-	wg.Wait()
 
 	return protoOrder
 }
@@ -84,119 +130,362 @@ func (s *OrderServer) unlockOrderWrite() {
 }
 
 func (s *OrderServer) GetOrders(ctx context.Context, in *pb.CustomerId) (*pb.OrderList, error) {
+	if err := authorizeCustomer(ctx, in.Id); err != nil {
+		return nil, err
+	}
+
+	_, span := startSpan(ctx, "OrderStorage.GetOrders", customerAttribute(in.Id))
+	defer span.End()
 	s.lockOrderRead()
 	defer s.unlockOrderRead()
 
 	orders, exists := s.orders[in.Id]
 	if !exists {
-		return nil, fmt.Errorf("no orders found for customer %d", in.Id)
+		return nil, NotFound("ORDERS_NOT_FOUND", "no orders found for customer %d", in.Id)
 	}
 
 	orderList := make([]*pb.Order, 0, len(orders))
-	orderChan := make(chan *pb.Order)
-	var wg sync.WaitGroup
-
-	for _, order := range orders {
-		wg.Add(1)
-		go func(order *Order) {
-			defer wg.Done()
-			protoOrder := orderToProto(order)
-			orderChan <- protoOrder
-		}(order)
-	}
-
-	go func() {
-		wg.Wait()
-		close(orderChan)
-	}()
-
-	for protoOrder := range orderChan {
-		orderList = append(orderList, protoOrder)
+	for _, order := range sortOrders(orders) {
+		orderList = append(orderList, orderToProto(order))
 	}
 
 	return &pb.OrderList{Orders: orderList}, nil
 }
 
 func (s *OrderServer) GetOrder(ctx context.Context, in *pb.OrderId) (*pb.Order, error) {
+	_, span := startSpan(ctx, "OrderStorage.GetOrder", orderAttribute(in.Id))
+	defer span.End()
 	s.lockOrderRead()
 	defer s.unlockOrderRead()
 
 	customerId, exists := s.customerOrderMap[in.Id]
-	if !exists {
-		return nil, fmt.Errorf("order with id %d not found", in.Id)
+	// Orders of other customers are reported as missing so their IDs are not disclosed.
+	if !exists || authorizeCustomer(ctx, customerId) != nil {
+		return nil, NotFound("ORDER_NOT_FOUND", "order with id %d not found", in.Id)
 	}
 	pbOrder := orderToProto(s.orders[customerId][in.Id])
 	return pbOrder, nil
 }
 
-func (s *OrderServer) PlaceOrder(in *pb.CustomerId, stream pb.OrderService_PlaceOrderServer) error {
-	s.lockOrderWrite()
-	defer s.unlockOrderWrite()
+// getOrder returns a copy of the order so it can be inspected without holding orderLock.
+func (s *OrderServer) getOrder(ctx context.Context, orderId int32) (*Order, error) {
+	s.lockOrderRead()
+	defer s.unlockOrderRead()
 
-	s.quoteStorage.LockQuoteWrite()
-	defer s.quoteStorage.UnlockQuoteWrite()
+	customerId, exists := s.customerOrderMap[orderId]
+	if !exists || authorizeCustomer(ctx, customerId) != nil {
+		return nil, NotFound("ORDER_NOT_FOUND", "order with id %d not found", orderId)
+	}
+	return copyOrder(s.orders[customerId][orderId]), nil
+}
 
-	quote := s.quoteStorage.GetQuoteUnsafe(in.Id)
-	if len(quote.Items) == 0 {
-		return sendError(stream, 0, "quote is empty")
+func copyOrder(order *Order) *Order {
+	c := *order
+	c.Items = make(map[int32]*OrderItem, len(order.Items))
+	for lineId, item := range order.Items {
+		itemCopy := *item
+		itemCopy.Options = copyOptions(item.Options)
+		c.Items[lineId] = &itemCopy
 	}
+	return &c
+}
 
-	orderItems := make(map[int32]*OrderItem, 0)
-	for _, item := range quote.Items {
-		product, err := s.catalogClient.GetProductInfo(uint64(item.ProductID))
-		if err != nil {
-			fmt.Println(err)
-			return sendError(stream, 0, fmt.Sprintf("failed to get product info for product %d", item.ProductID))
-		}
-		orderItems[item.ProductID] = &OrderItem{
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     product.Price,
-		}
+// beginCheckout registers an in-flight checkout of the customer. It fails once the
+// server is draining or when the customer already runs the most checkouts allowed.
+func (s *OrderServer) beginCheckout(customerId int32) error {
+	s.checkoutLock.Lock()
+	defer s.checkoutLock.Unlock()
+
+	if s.draining {
+		return Unavailable("SHUTTING_DOWN", nil, "service is shutting down")
+	}
+	if limit := s.config.MaxCheckoutsPerCustomer; limit > 0 && s.customerCheckouts[customerId] >= limit {
+		// A checkout is over after its steps, so that is when a slot frees up at the latest.
+		retryAfter := max(checkoutSteps*s.config.StepDelay, time.Second)
+		return ResourceExhausted("TOO_MANY_CHECKOUTS", retryAfter, "customer %d already has %d checkouts in progress", customerId, limit)
+	}
+	if s.customerCheckouts == nil {
+		s.customerCheckouts = make(map[int32]int)
 	}
+	s.customerCheckouts[customerId]++
+	s.checkouts.Add(1)
+	return nil
+}
+
+func (s *OrderServer) endCheckout(customerId int32) {
+	s.checkoutLock.Lock()
+	defer s.checkoutLock.Unlock()
 
-	order := &Order{
-		Items:      orderItems,
-		CustomerId: in.Id,
+	s.customerCheckouts[customerId]--
+	if s.customerCheckouts[customerId] <= 0 {
+		delete(s.customerCheckouts, customerId)
+	}
+	s.checkouts.Done()
+}
+
+// Drain stops accepting new checkouts and waits until the in-flight ones finish
+// or the context expires.
+func (s *OrderServer) Drain(ctx context.Context) error {
+	s.checkoutLock.Lock()
+	s.draining = true
+	s.checkoutLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.checkouts.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("in-flight checkouts did not finish: %v", ctx.Err())
+	}
+}
+
+func (s *OrderServer) PlaceOrder(in *pb.CustomerId, stream pb.OrderService_PlaceOrderServer) error {
+	ctx := stream.Context()
+	if err := authorizeCustomer(ctx, in.Id); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := s.beginCheckout(in.Id); err != nil {
+		if IsKind(err, KindUnavailable) {
+			s.metrics.CheckoutFailed(CheckoutShuttingDown, start)
+		} else {
+			s.metrics.CheckoutFailed(CheckoutTooManyCheckouts, start)
+			_ = stream.SetHeader(retryAfterMetadata(err))
+		}
+		return sendError(stream, 0, err)
 	}
+	defer s.endCheckout(in.Id)
 
-	if s.orders[in.Id] == nil {
-		s.orders[in.Id] = make(map[int32]*Order)
+	order, err := s.convertQuote(ctx, stream, in.Id, start)
+	if err != nil {
+		return err
 	}
-	orderId := int32(len(s.orders[in.Id]) + 1)
-	order.ID = orderId
-	s.orders[in.Id][orderId] = order
-	s.quoteStorage.ClearQuoteUnsafe(in.Id)
+	orderId := order.ID
 
 	// Simulate order processing steps
 	orderSteps := []pb.ProcessStatus{
-		{OrderId: 1, Status: pb.OrderStatus_STARTED, Message: "Order processing started."},
-		{OrderId: 1, Status: pb.OrderStatus_PROCESSED, Message: "Collecting shipping information."},
-		{OrderId: 1, Status: pb.OrderStatus_PROCESSED, Message: "Collecting payment details."},
-		{OrderId: 1, Status: pb.OrderStatus_COMPLETED, Message: "Order has been completed."},
+		{OrderId: orderId, Status: pb.OrderStatus_STARTED, Message: "Order processing started."},
+		{OrderId: orderId, Status: pb.OrderStatus_PROCESSED, Message: "Collecting shipping information."},
+		{OrderId: orderId, Status: pb.OrderStatus_PROCESSED, Message: "Collecting payment details."},
+		{OrderId: orderId, Status: pb.OrderStatus_COMPLETED, Message: "Order has been completed."},
 	}
 
 	// Stream each step back to client
 	for i := range orderSteps {
+		_, stepSpan := startSpan(ctx, "checkout.step", orderAttribute(orderId),
+			attribute.String("sale.order_status", orderSteps[i].Status.String()),
+			attribute.String("sale.step", orderSteps[i].Message))
 		// Simulating delay between steps
-		time.Sleep(2 * time.Second)
-		fmt.Printf("Sending order process status: %s\n", orderSteps[i].Message)
+		if err := waitStep(ctx, s.config.StepDelay); err != nil {
+			endSpan(stepSpan, err)
+			return s.abortCheckout(stream, order, err, start)
+		}
+		loggerFromContext(ctx).Info("sending order process status", "status", orderSteps[i].Status.String(), "message", orderSteps[i].Message)
+		if err := s.setOrderStatus(order, orderSteps[i].Status, orderSteps[i].Message); err != nil {
+			endSpan(stepSpan, err)
+			return s.abortCheckout(stream, order, err, start)
+		}
+		s.progress.publish(&orderSteps[i])
 		if err := stream.Send(&orderSteps[i]); err != nil {
-			return fmt.Errorf("failed to send order process status: %v", err)
+			endSpan(stepSpan, err)
+			s.metrics.CheckoutFailed(CheckoutStreamBroken, start)
+			err = fmt.Errorf("failed to send order process status: %v", err)
+			loggerFromContext(ctx).Warn("checkout aborted", "status", orderSteps[i].Status.String(), "error", err)
+			// A completed order stays completed, the client only missed its last status.
+			if orderSteps[i].Status != pb.OrderStatus_COMPLETED {
+				_ = s.setOrderStatus(order, pb.OrderStatus_ERROR, err.Error())
+				s.progress.publish(&pb.ProcessStatus{OrderId: orderId, Status: pb.OrderStatus_ERROR, Message: err.Error()})
+			}
+			return err
 		}
+		stepSpan.End()
 	}
 
+	s.metrics.OrderPlaced(order.Total(), start)
 	return nil
 }
 
-func sendError(stream pb.OrderService_PlaceOrderServer, orderId int32, message string) error {
+// convertQuote turns the quote of the customer into a new order. The quote is consumed
+// in one transaction with the creation of the order. It stays when any price changed,
+// unless the client sent the price token of the change to accept the new prices.
+func (s *OrderServer) convertQuote(ctx context.Context, stream pb.OrderService_PlaceOrderServer, customerId int32, start time.Time) (*Order, error) {
+	// The wait for the lock is traced on its own to tell contention from slow steps.
+	_, lockSpan := startSpan(ctx, "checkout.lock", customerAttribute(customerId))
+	var placed *Event
+	err := s.quoteStorage.ConsumeQuote(customerId, func(quote *Quote) ([]*Event, error) {
+		lockSpan.End()
+		if len(quote.Items) == 0 {
+			s.metrics.CheckoutFailed(CheckoutEmptyQuote, start)
+			return nil, FailedPrecondition("QUOTE_EMPTY", "quote is empty")
+		}
+
+		priceCtx, priceSpan := startSpan(ctx, "checkout.price", customerAttribute(customerId), attribute.Int("sale.lines", len(quote.Items)))
+		orderItems := make(map[int32]*OrderItem, 0)
+		priceChanges := make([]PriceChange, 0)
+		for _, item := range quote.SortedItems() {
+			product, err := s.catalogClient.GetProductInfo(priceCtx, uint64(item.ProductID))
+			if err != nil {
+				loggerFromContext(ctx).Error("failed to get product info", "product_id", item.ProductID, "error", err)
+				endSpan(priceSpan, err)
+				s.metrics.CheckoutFailed(CheckoutCatalogUnavailable, start)
+				return nil, catalogError(item.ProductID, err)
+			}
+			if item.Price != product.Price {
+				priceChanges = append(priceChanges, PriceChange{
+					LineID:    item.LineID,
+					ProductID: item.ProductID,
+					OldPrice:  item.Price,
+					NewPrice:  product.Price,
+				})
+			}
+			orderItems[item.LineID] = &OrderItem{
+				LineID:    item.LineID,
+				ProductID: item.ProductID,
+				Variant:   item.Variant,
+				Options:   copyOptions(item.Options),
+				Quantity:  item.Quantity,
+				Price:     product.Price,
+			}
+		}
+		// The order takes the new prices only once the client confirmed exactly these changes.
+		if len(priceChanges) > 0 {
+			if token := priceToken(customerId, priceChanges); priceTokenFromContext(ctx) != token {
+				s.metrics.CheckoutFailed(CheckoutPriceChanged, start)
+				priceErr := &PriceChangedError{Changes: priceChanges, Token: token}
+				endSpan(priceSpan, priceErr)
+				return nil, priceErr
+			}
+		}
+		priceSpan.End()
+
+		// The order is recorded together with the clearing of the quote, one is never
+		// kept without the other.
+		placed = s.newOrder(customerId, orderItems)
+		return []*Event{placed}, nil
+	})
+
+	var priceErr *PriceChangedError
+	if errors.As(err, &priceErr) {
+		// The token also goes in the header, where clients find it without parsing the status.
+		_ = stream.SetHeader(metadata.Pairs(PriceTokenMetadata, priceErr.Token))
+		err := stream.Send(&pb.ProcessStatus{
+			OrderId: 0,
+			Status:  pb.OrderStatus_ERROR,
+			Message: priceErr.Error(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to send error message: %v", err)
+		}
+		return nil, priceErr
+	}
+	if err != nil {
+		return nil, sendError(stream, 0, err)
+	}
+	_, createSpan := startSpan(ctx, "checkout.create_order", customerAttribute(customerId), orderAttribute(placed.OrderId))
+	order := s.addOrder(placed)
+	createSpan.End()
+
+	s.progress.start(order.ID, customerId)
+	addLogFields(ctx, "order_id", order.ID)
+	return order, nil
+}
+
+// newOrder returns the event placing a new order of the customer under the next order
+// ID. The ID is skipped when the event is not recorded.
+func (s *OrderServer) newOrder(customerId int32, items map[int32]*OrderItem) *Event {
+	s.lockOrderWrite()
+	defer s.unlockOrderWrite()
+
+	s.lastOrderID++
+	return orderPlaced(s.lastOrderID, customerId, items)
+}
+
+// addOrder applies the recorded event placing an order and returns the order.
+func (s *OrderServer) addOrder(event *Event) *Order {
+	s.lockOrderWrite()
+	defer s.unlockOrderWrite()
+
+	if s.customerOrderMap == nil {
+		s.customerOrderMap = make(map[int32]int32)
+	}
+	s.apply(event)
+	return s.orders[event.CustomerId][event.OrderId]
+}
+
+// setOrderStatus records the new status of an order with the reason, readers hold orderLock.
+func (s *OrderServer) setOrderStatus(order *Order, status pb.OrderStatus, reason string) error {
+	s.lockOrderWrite()
+	defer s.unlockOrderWrite()
+
+	if order.Status == status {
+		return nil
+	}
+	return s.record(statusChanged(order, status, reason))
+}
+
+// record appends the event to the event log and applies it, the caller holds orderLock.
+// Nothing changes when the log fails.
+func (s *OrderServer) record(event *Event) error {
+	if s.events != nil {
+		if err := s.events.Append(event); err != nil {
+			return eventLogError(err)
+		}
+	}
+	s.apply(event)
+	return nil
+}
+
+// apply changes the orders as the recorded event says and hands it to the outbox, the
+// caller holds orderLock.
+func (s *OrderServer) apply(event *Event) {
+	if s.outbox != nil {
+		s.outbox.Record(event)
+	}
+	applyOrderEvent(s.orders, s.customerOrderMap, event)
+}
+
+// waitStep pauses between checkout steps, it returns early when the call is cancelled
+// or runs out of time.
+func waitStep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// abortCheckout fails an order whose processing was cut short by the end of the call.
+// The order is kept with the ERROR status, its quote has already been converted.
+func (s *OrderServer) abortCheckout(stream pb.OrderService_PlaceOrderServer, order *Order, cause error, start time.Time) error {
+	err := status.FromContextError(cause).Err()
+	loggerFromContext(stream.Context()).Warn("checkout aborted", "status", order.Status.String(), "error", cause)
+	if errors.Is(cause, context.DeadlineExceeded) {
+		s.metrics.CheckoutFailed(CheckoutDeadlineExceeded, start)
+	} else {
+		s.metrics.CheckoutFailed(CheckoutStreamBroken, start)
+	}
+	_ = s.setOrderStatus(order, pb.OrderStatus_ERROR, err.Error())
+	s.progress.publish(&pb.ProcessStatus{OrderId: order.ID, Status: pb.OrderStatus_ERROR, Message: err.Error()})
+	return sendError(stream, order.ID, err)
+}
+
+// sendError reports the failure in the stream and returns it as the status of the call.
+func sendError(stream pb.OrderService_PlaceOrderServer, orderId int32, cause error) error {
 	err := stream.Send(&pb.ProcessStatus{
 		OrderId: orderId,
 		Status:  pb.OrderStatus_ERROR,
-		Message: message,
+		Message: cause.Error(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to send error message: %v", err)
 	}
-	return fmt.Errorf(message)
+	return cause
 }
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+)
+
+const (
+	defaultOrderPageSize = 20
+	maxOrderPageSize     = 100
+)
+
+// OrderFilter narrows down the orders returned by ListOrders. Zero values mean "no filter".
+type OrderFilter struct {
+	Statuses      []pb.OrderStatus
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	ProductID     int32
+}
+
+type OrderListRequest struct {
+	CustomerId int32
+	Filter     OrderFilter
+	PageSize   int
+	Cursor     string
+}
+
+type OrderPage struct {
+	Orders     []*Order
+	NextCursor string
+}
+
+// ListOrders returns a page of customer orders sorted by creation time.
+// It has no gRPC binding yet, the sale protos have to be extended first.
+func (s *OrderServer) ListOrders(ctx context.Context, in OrderListRequest) (*OrderPage, error) {
+	if err := authorizeCustomer(ctx, in.CustomerId); err != nil {
+		return nil, err
+	}
+	pageSize := in.PageSize
+	if pageSize < 0 {
+		return nil, InvalidArgument("page_size", "INVALID_PAGE_SIZE", "invalid page size %d", pageSize)
+	}
+	if pageSize == 0 {
+		pageSize = defaultOrderPageSize
+	}
+	if pageSize > maxOrderPageSize {
+		pageSize = maxOrderPageSize
+	}
+
+	var after *orderCursor
+	if in.Cursor != "" {
+		cursor, err := decodeOrderCursor(in.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = cursor
+	}
+
+	s.lockOrderRead()
+	defer s.unlockOrderRead()
+
+	page := &OrderPage{Orders: make([]*Order, 0)}
+	for _, order := range sortOrders(s.orders[in.CustomerId]) {
+		if after != nil && !after.before(order) {
+			continue
+		}
+		if !in.Filter.matches(order) {
+			continue
+		}
+		if len(page.Orders) == pageSize {
+			last := page.Orders[len(page.Orders)-1]
+			page.NextCursor = orderCursor{last.CreatedAt, last.ID}.encode()
+			break
+		}
+		page.Orders = append(page.Orders, copyOrder(order))
+	}
+	return page, nil
+}
+
+// sortOrders returns the orders ordered by creation time, order ID breaks ties.
+func sortOrders(orders map[int32]*Order) []*Order {
+	sorted := make([]*Order, 0, len(orders))
+	for _, order := range orders {
+		sorted = append(sorted, order)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return orderCursor{sorted[i].CreatedAt, sorted[i].ID}.before(sorted[j])
+	})
+	return sorted
+}
+
+func (f OrderFilter) matches(order *Order) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			if order.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.CreatedAfter.IsZero() && order.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !order.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	if f.ProductID != 0 {
+		for _, item := range order.Items {
+			if item.ProductID == f.ProductID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// orderCursor points at the last order of a page.
+type orderCursor struct {
+	createdAt time.Time
+	id        int32
+}
+
+func (c orderCursor) before(order *Order) bool {
+	if !c.createdAt.Equal(order.CreatedAt) {
+		return c.createdAt.Before(order.CreatedAt)
+	}
+	return c.id < order.ID
+}
+
+func (c orderCursor) encode() string {
+	raw := fmt.Sprintf("%d:%d", c.createdAt.UnixNano(), c.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeOrderCursor(cursor string) (*orderCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, InvalidArgument("cursor", "INVALID_CURSOR", "invalid cursor %q", cursor)
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 {
+		return nil, InvalidArgument("cursor", "INVALID_CURSOR", "invalid cursor %q", cursor)
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, InvalidArgument("cursor", "INVALID_CURSOR", "invalid cursor %q", cursor)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return nil, InvalidArgument("cursor", "INVALID_CURSOR", "invalid cursor %q", cursor)
+	}
+	return &orderCursor{time.Unix(0, nanos), int32(id)}, nil
+}
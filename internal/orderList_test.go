@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+)
+
+var listTestStart = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+func newTestListOrderServer() *OrderServer {
+	orders := map[int32]*Order{}
+	for i := int32(1); i <= 5; i++ {
+		status := pb.OrderStatus_COMPLETED
+		if i%2 == 0 {
+			status = pb.OrderStatus_STARTED
+		}
+		orders[i] = &Order{
+			ID:         i,
+			CustomerId: 1,
+			Status:     status,
+			CreatedAt:  listTestStart.Add(time.Duration(i) * time.Hour),
+			Items: map[int32]*OrderItem{
+				100 + i%3: {ProductID: 100 + i%3, Quantity: 1, Price: 10.0},
+			},
+		}
+	}
+	return &OrderServer{
+		orders:    map[int32]map[int32]*Order{1: orders},
+		orderLock: sync.RWMutex{},
+	}
+}
+
+func orderIds(orders []*Order) []int32 {
+	ids := make([]int32, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+	}
+	return ids
+}
+
+func TestOrderServer_ListOrders(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  OrderListRequest
+		expected []int32
+	}{
+		{"All orders", OrderListRequest{CustomerId: 1}, []int32{1, 2, 3, 4, 5}},
+		{"Customer without orders", OrderListRequest{CustomerId: 2}, []int32{}},
+		{"Filter by status", OrderListRequest{CustomerId: 1, Filter: OrderFilter{Statuses: []pb.OrderStatus{pb.OrderStatus_STARTED}}}, []int32{2, 4}},
+		{"Filter by product", OrderListRequest{CustomerId: 1, Filter: OrderFilter{ProductID: 101}}, []int32{1, 4}},
+		{
+			"Filter by date range",
+			OrderListRequest{CustomerId: 1, Filter: OrderFilter{
+				CreatedAfter:  listTestStart.Add(2 * time.Hour),
+				CreatedBefore: listTestStart.Add(4 * time.Hour),
+			}},
+			[]int32{2, 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			orderServer := newTestListOrderServer()
+			page, err := orderServer.ListOrders(context.Background(), test.request)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, orderIds(page.Orders))
+			assert.Empty(t, page.NextCursor)
+		})
+	}
+}
+
+func TestOrderServer_ListOrdersPagination(t *testing.T) {
+	orderServer := newTestListOrderServer()
+
+	var pages [][]int32
+	cursor := ""
+	for {
+		page, err := orderServer.ListOrders(context.Background(), OrderListRequest{CustomerId: 1, PageSize: 2, Cursor: cursor})
+		assert.NoError(t, err)
+		pages = append(pages, orderIds(page.Orders))
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, [][]int32{{1, 2}, {3, 4}, {5}}, pages)
+}
+
+func TestOrderServer_ListOrdersInvalidRequest(t *testing.T) {
+	orderServer := newTestListOrderServer()
+
+	_, err := orderServer.ListOrders(context.Background(), OrderListRequest{CustomerId: 1, Cursor: "not a cursor"})
+	assert.Error(t, err)
+
+	_, err = orderServer.ListOrders(context.Background(), OrderListRequest{CustomerId: 1, PageSize: -1})
+	assert.Error(t, err)
+}
+
+func TestOrderCursor(t *testing.T) {
+	cursor := orderCursor{listTestStart, 3}
+	decoded, err := decodeOrderCursor(cursor.encode())
+	assert.NoError(t, err)
+	assert.True(t, cursor.createdAt.Equal(decoded.createdAt))
+	assert.Equal(t, cursor.id, decoded.id)
+}
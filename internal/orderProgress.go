@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProgressEvent is a checkout status of an order. IDs number the events of an order from 1.
+type ProgressEvent struct {
+	ID     int
+	Status *pb.ProcessStatus
+}
+
+type progressLog struct {
+	customerId int32
+	events     []ProgressEvent
+	done       bool
+	// changed is closed and replaced on every new event.
+	changed chan struct{}
+}
+
+// progressUpdate is what a subscriber has not seen yet. Changed is closed on the next event.
+type progressUpdate struct {
+	CustomerId int32
+	Events     []ProgressEvent
+	Done       bool
+	Changed    <-chan struct{}
+}
+
+// finishedLog is a checkout that is over, its log is evicted after the retention.
+type finishedLog struct {
+	orderId int32
+	at      time.Time
+}
+
+// OrderProgress keeps the status events of the checkouts, so they can be streamed to
+// other clients than the one placing the order and replayed after a reconnect.
+// Finished checkouts are dropped after the retention, their orders then only have a status.
+// A nil *OrderProgress tracks nothing.
+type OrderProgress struct {
+	logs map[int32]*progressLog
+	// finished holds the finished checkouts in the order they finished.
+	finished  []finishedLog
+	retention time.Duration
+	now       func() time.Time
+	lock      sync.Mutex
+}
+
+// NewOrderProgress keeps the finished checkouts for the retention, 0 keeps them forever.
+func NewOrderProgress(retention time.Duration) *OrderProgress {
+	return &OrderProgress{logs: make(map[int32]*progressLog), retention: retention, now: time.Now}
+}
+
+// start tracks the checkout of a newly created order and evicts the expired ones.
+func (p *OrderProgress) start(orderId int32, customerId int32) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.evict()
+	p.logs[orderId] = &progressLog{customerId: customerId, changed: make(chan struct{})}
+}
+
+// evict drops the logs of the checkouts finished longer than the retention ago.
+func (p *OrderProgress) evict() {
+	if p.retention == 0 {
+		return
+	}
+	expired := p.now().Add(-p.retention)
+	for len(p.finished) > 0 && !p.finished[0].at.After(expired) {
+		delete(p.logs, p.finished[0].orderId)
+		p.finished = p.finished[1:]
+	}
+}
+
+// end marks the checkout as over, the caller holds the lock.
+func (p *OrderProgress) end(orderId int32, log *progressLog) {
+	log.done = true
+	p.finished = append(p.finished, finishedLog{orderId: orderId, at: p.now()})
+}
+
+// publish appends a copy of the status to the events of its order, a completed or failed
+// order ends the checkout.
+func (p *OrderProgress) publish(processStatus *pb.ProcessStatus) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	log, exists := p.logs[processStatus.OrderId]
+	if !exists || log.done {
+		return
+	}
+	log.events = append(log.events, ProgressEvent{ID: len(log.events) + 1, Status: proto.Clone(processStatus).(*pb.ProcessStatus)})
+	if processStatus.Status == pb.OrderStatus_COMPLETED || processStatus.Status == pb.OrderStatus_ERROR {
+		p.end(processStatus.OrderId, log)
+	}
+	close(log.changed)
+	log.changed = make(chan struct{})
+}
+
+// since returns the events of the order after lastId, it reports false for untracked orders.
+func (p *OrderProgress) since(orderId int32, lastId int) (progressUpdate, bool) {
+	if p == nil {
+		return progressUpdate{}, false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	log, exists := p.logs[orderId]
+	if !exists {
+		return progressUpdate{}, false
+	}
+	update := progressUpdate{CustomerId: log.customerId, Done: log.done, Changed: log.changed}
+	if lastId < len(log.events) {
+		update.Events = append([]ProgressEvent(nil), log.events[max(lastId, 0):]...)
+	}
+	return update, true
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderProgress_Since(t *testing.T) {
+	progress := NewOrderProgress(0)
+	progress.start(1, 7)
+	progress.publish(&pb.ProcessStatus{OrderId: 1, Status: pb.OrderStatus_STARTED})
+	progress.publish(&pb.ProcessStatus{OrderId: 1, Status: pb.OrderStatus_PROCESSED})
+
+	update, tracked := progress.since(1, 0)
+	require.True(t, tracked)
+	assert.Equal(t, int32(7), update.CustomerId)
+	assert.False(t, update.Done)
+	require.Len(t, update.Events, 2)
+	assert.Equal(t, []int{1, 2}, []int{update.Events[0].ID, update.Events[1].ID})
+
+	update, _ = progress.since(1, 1)
+	require.Len(t, update.Events, 1)
+	assert.Equal(t, pb.OrderStatus_PROCESSED, update.Events[0].Status.Status)
+
+	update, _ = progress.since(1, 5)
+	assert.Empty(t, update.Events)
+
+	_, tracked = progress.since(2, 0)
+	assert.False(t, tracked)
+}
+
+func TestOrderProgress_Changed(t *testing.T) {
+	progress := NewOrderProgress(0)
+	progress.start(1, 7)
+	update, _ := progress.since(1, 0)
+
+	progress.publish(&pb.ProcessStatus{OrderId: 1, Status: pb.OrderStatus_COMPLETED})
+	select {
+	case <-update.Changed:
+	default:
+		t.Fatal("subscriber was not notified")
+	}
+	update, _ = progress.since(1, 0)
+	assert.True(t, update.Done)
+
+	// Nothing is recorded after the checkout is over.
+	progress.publish(&pb.ProcessStatus{OrderId: 1, Status: pb.OrderStatus_PROCESSED})
+	update, _ = progress.since(1, 0)
+	assert.Len(t, update.Events, 1)
+}
+
+func TestOrderProgress_EvictsFinished(t *testing.T) {
+	now := time.Now()
+	progress := NewOrderProgress(time.Minute)
+	progress.now = func() time.Time { return now }
+	progress.start(1, 7)
+	progress.start(2, 7)
+	progress.publish(&pb.ProcessStatus{OrderId: 1, Status: pb.OrderStatus_COMPLETED})
+
+	now = now.Add(30 * time.Second)
+	progress.publish(&pb.ProcessStatus{OrderId: 2, Status: pb.OrderStatus_ERROR})
+	progress.start(3, 7)
+	_, tracked := progress.since(1, 0)
+	assert.True(t, tracked, "kept within the retention")
+
+	now = now.Add(45 * time.Second)
+	progress.start(4, 7)
+	_, tracked = progress.since(1, 0)
+	assert.False(t, tracked)
+	_, tracked = progress.since(2, 0)
+	assert.True(t, tracked)
+	_, tracked = progress.since(3, 0)
+	assert.True(t, tracked, "running checkouts are never evicted")
+	assert.Len(t, progress.finished, 1)
+}
+
+func TestOrderProgress_Nil(t *testing.T) {
+	var progress *OrderProgress
+	progress.start(1, 7)
+	progress.publish(&pb.ProcessStatus{OrderId: 1})
+	_, tracked := progress.since(1, 0)
+	assert.False(t, tracked)
+}
+
+func TestOrderServer_PlaceOrderPublishesProgress(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	_, err := quoteServer.AddProduct(principalContext(1, RoleCustomer), &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 1})
+	require.NoError(t, err)
+
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(principalContext(1, RoleCustomer))
+	stream.On("Send", mock.Anything).Return(nil)
+	require.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream))
+
+	update, tracked := orderServer.progress.since(1, 0)
+	require.True(t, tracked)
+	assert.True(t, update.Done)
+	assert.Len(t, update.Events, 4)
+	assert.Equal(t, pb.OrderStatus_COMPLETED, update.Events[3].Status.Status)
+}
+
+func TestOrderServer_PlaceOrderBrokenStreamFinishesProgress(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	_, err := quoteServer.AddProduct(principalContext(1, RoleCustomer), &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 1})
+	require.NoError(t, err)
+
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(principalContext(1, RoleCustomer))
+	stream.On("Send", mock.Anything).Return(errors.New("connection reset"))
+	assert.Error(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream))
+
+	update, _ := orderServer.progress.since(1, 0)
+	assert.True(t, update.Done)
+	require.Len(t, update.Events, 2)
+	assert.Equal(t, pb.OrderStatus_ERROR, update.Events[1].Status.Status)
+}
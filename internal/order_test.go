@@ -2,19 +2,30 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	pbc "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/catalog"
 	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestNewOrderServer(t *testing.T) {
-	orderServer := NewOrderServer(nil, nil)
+	orderServer := NewOrderServer(OrderConfig{}, nil, nil, nil)
 	assert.IsType(t, &OrderServer{}, orderServer)
 }
 
@@ -40,6 +51,25 @@ func TestOrderToProto(t *testing.T) {
 	assert.Equal(t, order.Items[1].Price, protoOrder.Items[0].Price)
 }
 
+func TestOrderToProtoKeepsLineOrder(t *testing.T) {
+	order := &Order{
+		ID:         1,
+		CustomerId: 1,
+		Items: map[int32]*OrderItem{
+			101: {LineID: 3, ProductID: 101, Quantity: 1, Price: 10.0},
+			102: {LineID: 1, ProductID: 102, Quantity: 1, Price: 20.0},
+			103: {LineID: 2, ProductID: 103, Quantity: 1, Price: 30.0},
+		},
+	}
+
+	for i := 0; i < 10; i++ {
+		protoOrder := orderToProto(order)
+		assert.Equal(t, int32(102), protoOrder.Items[0].ProductId)
+		assert.Equal(t, int32(103), protoOrder.Items[1].ProductId)
+		assert.Equal(t, int32(101), protoOrder.Items[2].ProductId)
+	}
+}
+
 func TestOrderServer_GetOrders(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -191,6 +221,7 @@ func TestOrderServer_PlaceOrder(t *testing.T) {
 					1: {
 						ProductID: 1,
 						Quantity:  1,
+						Price:     100,
 					},
 				},
 			},
@@ -198,6 +229,26 @@ func TestOrderServer_PlaceOrder(t *testing.T) {
 			wantErr:    false,
 			err:        nil,
 		},
+		{
+			name: "price changed",
+			quote: &Quote{
+				CustomerId: 1,
+				Items: map[int32]*QuoteItem{
+					1: {
+						LineID:    1,
+						ProductID: 1,
+						Quantity:  1,
+						Price:     80,
+					},
+				},
+			},
+			customerId: 1,
+			wantErr:    true,
+			err: &PriceChangedError{
+				Changes: []PriceChange{{LineID: 1, ProductID: 1, OldPrice: 80, NewPrice: 100}},
+				Token:   priceToken(1, []PriceChange{{LineID: 1, NewPrice: 100}}),
+			},
+		},
 		{
 			name:       "empty quote",
 			quote:      &Quote{CustomerId: 1, Items: map[int32]*QuoteItem{}},
@@ -225,6 +276,8 @@ func TestOrderServer_PlaceOrder(t *testing.T) {
 			}
 			stream := &MockOrderService_PlaceOrderServer{}
 			stream.On("Send", mock.Anything).Return(nil)
+			stream.On("SetHeader", mock.Anything).Return(nil)
+			stream.On("Context").Return(context.Background())
 			err := orderServer.PlaceOrder(&pb.CustomerId{Id: tt.customerId}, stream)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("PlaceOrder() error = %v, wantErr %v", err, tt.wantErr)
@@ -232,9 +285,295 @@ func TestOrderServer_PlaceOrder(t *testing.T) {
 			}
 			if tt.wantErr {
 				assert.Equal(t, tt.err.Error(), err.Error())
+				assert.Empty(t, orderServer.orders[tt.customerId])
 				return
 			}
 			assert.Equal(t, 1, len(orderServer.orders[tt.customerId]))
+			assert.Equal(t, tt.customerId, orderServer.customerOrderMap[1])
+			assert.Equal(t, pb.OrderStatus_COMPLETED, orderServer.orders[tt.customerId][1].Status)
 		})
 	}
 }
+
+func TestOrderServer_PlaceOrderRequiresPriceToken(t *testing.T) {
+	mockCatalogClient := &MockCatalogClient{}
+	mockCatalogClient.On("GetProductInfo", uint64(1)).Return(&pbc.Product{Id: 1, Price: 15}, nil)
+	quoteStorage := &QuoteStorage{
+		quotes: map[int32]*Quote{
+			1: {
+				CustomerId: 1,
+				Items: map[int32]*QuoteItem{
+					1: {LineID: 1, ProductID: 1, Quantity: 2, Price: 10},
+				},
+			},
+		},
+	}
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, mockCatalogClient, nil)
+	placeOrder := func(token string) error {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(PriceTokenMetadata, token))
+		}
+		stream := &MockOrderService_PlaceOrderServer{}
+		stream.On("Send", mock.Anything).Return(nil)
+		stream.On("SetHeader", mock.Anything).Return(nil)
+		stream.On("Context").Return(ctx)
+		err := orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream)
+		var priceErr *PriceChangedError
+		if errors.As(err, &priceErr) {
+			stream.AssertCalled(t, "SetHeader", metadata.Pairs(PriceTokenMetadata, priceErr.Token))
+		}
+		return err
+	}
+
+	var priceErr *PriceChangedError
+	require.ErrorAs(t, placeOrder(""), &priceErr)
+	assert.Equal(t, []PriceChange{{LineID: 1, ProductID: 1, OldPrice: 10, NewPrice: 15}}, priceErr.Changes)
+	assert.NotEmpty(t, priceErr.Token)
+	// The quote keeps the prices the customer accepted so far.
+	assert.Equal(t, float32(10), storedQuote(quoteStorage, 1).Items[1].Price)
+
+	// A blind retry or a token of other prices does not accept the change.
+	var retryErr *PriceChangedError
+	require.ErrorAs(t, placeOrder(""), &retryErr)
+	assert.Equal(t, priceErr.Token, retryErr.Token)
+	assert.ErrorAs(t, placeOrder(priceToken(1, []PriceChange{{LineID: 1, NewPrice: 12}})), &retryErr)
+	assert.Empty(t, orderServer.orders[1])
+
+	require.NoError(t, placeOrder(priceErr.Token))
+	require.Len(t, orderServer.orders[1], 1)
+	assert.Equal(t, float32(15), orderServer.orders[1][1].Items[1].Price)
+	assert.Empty(t, storedQuote(quoteStorage, 1).Items)
+}
+
+func TestOrderServer_Drain(t *testing.T) {
+	orderServer := NewOrderServer(OrderConfig{}, nil, nil, nil)
+	assert.NoError(t, orderServer.beginCheckout(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, orderServer.Drain(ctx))
+
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Send", mock.Anything).Return(nil)
+	stream.On("Context").Return(context.Background())
+	err := orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream)
+	assert.EqualError(t, err, "service is shutting down")
+
+	orderServer.endCheckout(1)
+	assert.NoError(t, orderServer.Drain(context.Background()))
+}
+
+func TestOrderServer_MaxCheckoutsPerCustomer(t *testing.T) {
+	orderServer := NewOrderServer(OrderConfig{StepDelay: 2 * time.Second, MaxCheckoutsPerCustomer: 2}, nil, nil, nil)
+	assert.NoError(t, orderServer.beginCheckout(1))
+	assert.NoError(t, orderServer.beginCheckout(1))
+	assert.NoError(t, orderServer.beginCheckout(2))
+
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Send", mock.Anything).Return(nil)
+	stream.On("SetHeader", mock.Anything).Return(nil)
+	stream.On("Context").Return(context.Background())
+	err := orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, "TOO_MANY_CHECKOUTS", errorInfo(t, err).Reason)
+	stream.AssertCalled(t, "SetHeader", metadata.Pairs("retry-after", "8"))
+
+	orderServer.endCheckout(1)
+	assert.NoError(t, orderServer.beginCheckout(1))
+}
+
+func TestOrderServer_PlaceOrderDeadlineExceeded(t *testing.T) {
+	mockCatalogClient := &MockCatalogClient{}
+	mockCatalogClient.On("GetProductInfo", uint64(1)).Return(&pbc.Product{Id: 1, Price: 10}, nil)
+	quoteStorage := &QuoteStorage{
+		quotes: map[int32]*Quote{
+			1: {CustomerId: 1, Items: map[int32]*QuoteItem{1: {LineID: 1, ProductID: 1, Quantity: 1, Price: 10}}},
+		},
+	}
+	orderServer := NewOrderServer(OrderConfig{StepDelay: time.Hour}, quoteStorage, mockCatalogClient, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Send", mock.Anything).Return(nil)
+	stream.On("Context").Return(ctx)
+
+	err := orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	stream.AssertCalled(t, "Send", &pb.ProcessStatus{OrderId: 1, Status: pb.OrderStatus_ERROR, Message: err.Error()})
+	assert.Equal(t, pb.OrderStatus_ERROR, orderServer.orders[1][1].Status)
+	update, ok := orderServer.progress.since(1, 0)
+	require.True(t, ok)
+	assert.True(t, update.Done)
+}
+
+func TestOrderServer_PlaceOrderStreamBroken(t *testing.T) {
+	quoteStorage := &QuoteStorage{
+		quotes: map[int32]*Quote{
+			1: {CustomerId: 1, Items: map[int32]*QuoteItem{1: {LineID: 1, ProductID: 1, Quantity: 1, Price: 10}}},
+		},
+	}
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, fixedPriceCatalog{10}, nil)
+	outbox := NewMemoryOutbox()
+	AttachOutbox(outbox, orderServer)
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(context.Background())
+	stream.On("Send", mock.Anything).Return(nil).Once()
+	stream.On("Send", mock.Anything).Return(errors.New("connection reset"))
+
+	err := orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream)
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, pb.OrderStatus_ERROR, orderServer.orders[1][1].Status)
+	pending, err := outbox.Pending(10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{SubjectOrderPlaced, SubjectOrderStatusChanged, SubjectOrderStatusChanged}, subjects(pending))
+	var last Event
+	require.NoError(t, json.Unmarshal(pending[2].Payload, &last))
+	assert.Equal(t, "ERROR", last.Status)
+	update, ok := orderServer.progress.since(1, 0)
+	require.True(t, ok)
+	assert.True(t, update.Done)
+}
+
+func TestOrderServer_PlaceOrderConsumesQuoteOnce(t *testing.T) {
+	_, quoteStorage := NewQuoteServer(QuoteConfig{}, fixedPriceCatalog{10}, nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, fixedPriceCatalog{10}, nil)
+	quoteStorage.AddLine(1, 1, "", nil, 1, 10)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: context.Background()})
+		}()
+	}
+	first, second := <-errs, <-errs
+	assert.True(t, (first == nil) != (second == nil), "exactly one checkout succeeds: %v, %v", first, second)
+	assert.Len(t, orderServer.orders[1], 1)
+	assert.Empty(t, storedQuote(quoteStorage, 1).Items)
+}
+
+// TestOrderServer_ConcurrentQuotesAndCheckouts is meant for the race detector, quotes
+// change and orders are read while the same customers check out.
+func TestOrderServer_ConcurrentQuotesAndCheckouts(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, fixedPriceCatalog{10}, nil)
+	orderServer := NewOrderServer(OrderConfig{StepDelay: time.Millisecond}, quoteStorage, fixedPriceCatalog{10}, nil)
+	ctx := quietContext(context.Background())
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				customerId := int32(i%3 + 1)
+				request := &pb.ProductRequest{CustomerId: customerId, ProductId: int32(worker%2 + 1), Quantity: 1}
+				switch (worker + i) % 4 {
+				case 0:
+					_, _ = quoteServer.AddProduct(ctx, request)
+				case 1:
+					_, _ = quoteServer.UpdateQuantity(ctx, request)
+				case 2:
+					_ = orderServer.PlaceOrder(&pb.CustomerId{Id: customerId}, &discardStream{ctx: ctx})
+				case 3:
+					_, _ = orderServer.GetOrders(ctx, &pb.CustomerId{Id: customerId})
+					_, _ = orderServer.GetOrder(ctx, &pb.OrderId{Id: int32(i)})
+					_, _ = orderServer.ListOrders(ctx, OrderListRequest{CustomerId: customerId})
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	for orderId, customerId := range orderServer.customerOrderMap {
+		order := orderServer.orders[customerId][orderId]
+		assert.Equal(t, orderId, order.ID)
+		assert.NotEmpty(t, order.Items)
+		assert.Equal(t, pb.OrderStatus_COMPLETED, order.Status)
+	}
+}
+
+// fixedPriceCatalog prices every product the same. Unlike the mock it records no calls,
+// so it does not serialise the benchmarks.
+type fixedPriceCatalog struct {
+	price float32
+}
+
+func (c fixedPriceCatalog) GetProductList(ctx context.Context) (*pbc.ProductList, error) {
+	return &pbc.ProductList{}, nil
+}
+
+func (c fixedPriceCatalog) GetProductInfo(ctx context.Context, id uint64) (*pbc.Product, error) {
+	return &pbc.Product{Id: id, Price: c.price}, nil
+}
+
+// discardStream is a checkout stream dropping the statuses sent to it.
+type discardStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *discardStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *discardStream) Send(*pb.ProcessStatus) error {
+	return nil
+}
+
+func TestOrderServer_CheckoutDoesNotBlockOtherCustomers(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{MaxLines: 10}, fixedPriceCatalog{10}, nil)
+	orderServer := NewOrderServer(OrderConfig{StepDelay: time.Hour}, quoteStorage, fixedPriceCatalog{10}, nil)
+	ctx := context.Background()
+	_, err := quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 1, Quantity: 1})
+	require.NoError(t, err)
+
+	checkoutCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: checkoutCtx})
+	}()
+	require.Eventually(t, func() bool {
+		_, err := orderServer.getOrder(ctx, 1)
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		_, err := quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 2, ProductId: 1, Quantity: 1})
+		assert.NoError(t, err)
+		_, err = quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 2, Quantity: 1})
+		assert.NoError(t, err)
+		order, err := orderServer.GetOrder(ctx, &pb.OrderId{Id: 1})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), order.CustomerId)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("calls were blocked by the checkout")
+	}
+
+	cancel()
+	assert.Error(t, <-done)
+}
+
+// quietContext drops the log lines of the checkouts, they would drown the benchmark results.
+func quietContext(ctx context.Context) context.Context {
+	return contextWithLogger(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func BenchmarkOrderServer_PlaceOrderParallel(b *testing.B) {
+	_, quoteStorage := NewQuoteServer(QuoteConfig{}, fixedPriceCatalog{10}, nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, fixedPriceCatalog{10}, nil)
+	var customers atomic.Int32
+	b.RunParallel(func(p *testing.PB) {
+		customerId := nextCustomer(&customers)
+		stream := &discardStream{ctx: quietContext(context.Background())}
+		for p.Next() {
+			quoteStorage.AddLine(customerId, 1, "", nil, 1, 10)
+			if err := orderServer.PlaceOrder(&pb.CustomerId{Id: customerId}, stream); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Subjects of the order messages published through the outbox.
+const (
+	SubjectOrderPlaced        = "sale.order.placed"
+	SubjectOrderStatusChanged = "sale.order.status_changed"
+)
+
+// OutboxMessage is an order change waiting to be published. IDs grow with every message,
+// delivery is at least once so consumers drop the keys they have already seen.
+type OutboxMessage struct {
+	ID int64 `json:"id"`
+	// Origin is the boot of the process for the outboxes kept in memory, whose IDs start
+	// over on restart. It is empty for an event log file, its IDs survive restarts.
+	Origin  string    `json:"origin,omitempty"`
+	Subject string    `json:"subject"`
+	Time    time.Time `json:"time"`
+	// Payload is the event of the change as JSON.
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Outbox holds the messages of the order changes until the relay has published them.
+type Outbox interface {
+	// Record stores the message of the event, if it is an order change. OrderServer
+	// calls it under orderLock together with the change, so neither is seen without the other.
+	Record(event *Event)
+	// Pending returns up to limit messages not acknowledged yet, oldest first.
+	Pending(limit int) ([]OutboxMessage, error)
+	// Ack marks the messages up to and including the ID as published.
+	Ack(id int64) error
+	// Ready receives after messages were recorded.
+	Ready() <-chan struct{}
+}
+
+// Key identifies the message across restarts.
+func (m OutboxMessage) Key() string {
+	if m.Origin == "" {
+		return strconv.FormatInt(m.ID, 10)
+	}
+	return m.Origin + "-" + strconv.FormatInt(m.ID, 10)
+}
+
+// newOutboxOrigin returns a random origin for the IDs of this process.
+func newOutboxOrigin() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// outboxMessage returns the message of an order event, false for the quote events.
+func outboxMessage(origin string, id int64, event *Event) (OutboxMessage, bool, error) {
+	var subject string
+	switch event.Type {
+	case EventOrderPlaced:
+		subject = SubjectOrderPlaced
+	case EventStatusChanged:
+		subject = SubjectOrderStatusChanged
+	default:
+		return OutboxMessage{}, false, nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return OutboxMessage{}, false, err
+	}
+	return OutboxMessage{ID: id, Origin: origin, Subject: subject, Time: event.Time, Payload: payload}, true, nil
+}
+
+// outboxSignal wakes up the relay without blocking the writers, signals sent while the
+// relay is busy collapse into one.
+type outboxSignal chan struct{}
+
+func newOutboxSignal() outboxSignal {
+	return make(outboxSignal, 1)
+}
+
+func (s outboxSignal) notify() {
+	select {
+	case s <- struct{}{}:
+	default:
+	}
+}
+
+// MemoryOutbox keeps the messages in memory, the ones not published yet are lost on restart.
+type MemoryOutbox struct {
+	events []outboxEvent
+	origin string
+	lastID int64
+	ready  outboxSignal
+	lock   sync.Mutex
+}
+
+// outboxEvent is an order event waiting in the MemoryOutbox under its message ID.
+type outboxEvent struct {
+	id    int64
+	event Event
+}
+
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{origin: newOutboxOrigin(), ready: newOutboxSignal()}
+}
+
+func (o *MemoryOutbox) Record(event *Event) {
+	if event.Type != EventOrderPlaced && event.Type != EventStatusChanged {
+		return
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.lastID++
+	o.events = append(o.events, outboxEvent{id: o.lastID, event: *event})
+	o.ready.notify()
+}
+
+func (o *MemoryOutbox) Pending(limit int) ([]OutboxMessage, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	messages := make([]OutboxMessage, 0, min(limit, len(o.events)))
+	for _, e := range o.events[:min(limit, len(o.events))] {
+		message, _, err := outboxMessage(o.origin, e.id, &e.event)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func (o *MemoryOutbox) Ack(id int64) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for len(o.events) > 0 && o.events[0].id <= id {
+		o.events = o.events[1:]
+	}
+	return nil
+}
+
+func (o *MemoryOutbox) Ready() <-chan struct{} {
+	return o.ready
+}
+
+// errOutboxFull ends the replay of the log once Pending has enough messages.
+var errOutboxFull = errors.New("outbox full")
+
+// EventLogOutbox publishes the order events of the event log. The log is the outbox:
+// an order change and its message are the same append. The ID of a message is the
+// sequence number of its event, the last one acknowledged is kept in the cursor file.
+// Pending keeps the messages it has read until they are acknowledged and only reads the
+// log after the last event it has seen.
+type EventLogOutbox struct {
+	log    EventLog
+	origin string
+	// cursor is the path of the cursor file, without one every restart publishes the whole log again.
+	cursor string
+	acked  int64
+	// pending are the messages read and not acknowledged yet, read is the sequence
+	// number of the last event read.
+	pending []OutboxMessage
+	read    int64
+	ready   outboxSignal
+	lock    sync.Mutex
+}
+
+func NewEventLogOutbox(log EventLog, cursor string) (*EventLogOutbox, error) {
+	o := &EventLogOutbox{log: log, cursor: cursor, ready: newOutboxSignal()}
+	if _, ok := log.(*MemoryEventLog); ok {
+		o.origin = newOutboxOrigin()
+	}
+	if cursor == "" {
+		return o, nil
+	}
+	data, err := os.ReadFile(cursor)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox cursor: %v", err)
+	}
+	if o.acked, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err != nil {
+		return nil, fmt.Errorf("error parsing outbox cursor %s: %v", cursor, err)
+	}
+	o.read = o.acked
+	return o, nil
+}
+
+// Record only wakes up the relay, the event is already in the log.
+func (o *EventLogOutbox) Record(event *Event) {
+	if event.Type == EventOrderPlaced || event.Type == EventStatusChanged {
+		o.ready.notify()
+	}
+}
+
+func (o *EventLogOutbox) Pending(limit int) ([]OutboxMessage, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.pending) < limit {
+		err := o.log.Replay(o.read, func(event Event) error {
+			message, ok, err := outboxMessage(o.origin, event.Sequence, &event)
+			if err != nil {
+				return err
+			}
+			o.read = event.Sequence
+			if !ok {
+				return nil
+			}
+			o.pending = append(o.pending, message)
+			if len(o.pending) == limit {
+				return errOutboxFull
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errOutboxFull) {
+			return nil, err
+		}
+	}
+	return append([]OutboxMessage(nil), o.pending[:min(limit, len(o.pending))]...), nil
+}
+
+func (o *EventLogOutbox) Ack(id int64) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for len(o.pending) > 0 && o.pending[0].ID <= id {
+		o.pending = o.pending[1:]
+	}
+	if id <= o.acked {
+		return nil
+	}
+	if o.cursor != "" {
+		if err := writeFileAtomic(o.cursor, []byte(strconv.FormatInt(id, 10)+"\n")); err != nil {
+			return fmt.Errorf("error writing outbox cursor: %v", err)
+		}
+	}
+	o.acked = id
+	return nil
+}
+
+func (o *EventLogOutbox) Ready() <-chan struct{} {
+	return o.ready
+}
+
+// AttachOutbox makes the order server record the messages of its changes in the outbox.
+// It is called before the server starts.
+func AttachOutbox(outbox Outbox, orderServer *OrderServer) {
+	orderServer.outbox = outbox
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// OutboxRelay publishes the outbox messages in order. A message is acknowledged only
+// after it was published, so a crash in between publishes it again: at least once.
+// A failed message is retried with growing delays and holds back the messages after it.
+type OutboxRelay struct {
+	outbox    Outbox
+	publisher Publisher
+	config    OutboxConfig
+	metrics   *Metrics
+}
+
+func NewOutboxRelay(config OutboxConfig, outbox Outbox, publisher Publisher, metrics *Metrics) *OutboxRelay {
+	return &OutboxRelay{outbox: outbox, publisher: publisher, config: config, metrics: metrics}
+}
+
+// Run publishes the messages as they are recorded until ctx is done.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	failures := 0
+	for {
+		wait := r.config.PollInterval
+		if err := r.Flush(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			wait = r.retryDelay(failures)
+			slog.Warn("failed to publish outbox messages", "error", err, "attempt", failures, "retry_in", wait)
+		} else {
+			failures = 0
+		}
+		ready := r.outbox.Ready()
+		if failures > 0 {
+			// Messages recorded meanwhile wait for the retry, they would fail the same way.
+			ready = nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-ready:
+			timer.Stop()
+		}
+	}
+}
+
+// retryDelay is the delay after the failures in a row, doubled from RetryInitial up to RetryMax.
+func (r *OutboxRelay) retryDelay(failures int) time.Duration {
+	delay := r.config.RetryInitial
+	for i := 1; i < failures && delay < r.config.RetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, r.config.RetryMax)
+}
+
+// Flush publishes the pending messages until the outbox is empty or a publish fails.
+// It is also called on shutdown, after the last order change.
+func (r *OutboxRelay) Flush(ctx context.Context) error {
+	for {
+		messages, err := r.outbox.Pending(r.config.BatchSize)
+		if err != nil {
+			return fmt.Errorf("error reading outbox: %v", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		published := int64(-1)
+		for _, message := range messages {
+			if err = r.publisher.Publish(ctx, message); err != nil {
+				r.metrics.OutboxPublishFailed(message.Subject)
+				err = fmt.Errorf("message %d on %s: %v", message.ID, message.Subject, err)
+				break
+			}
+			r.metrics.OutboxPublished(message.Subject)
+			published = message.ID
+		}
+		if published >= 0 {
+			if ackErr := r.outbox.Ack(published); ackErr != nil && err == nil {
+				err = ackErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the publisher, after the last Flush.
+func (r *OutboxRelay) Close() error {
+	return r.publisher.Close()
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOutboxConfig = OutboxConfig{
+	BatchSize:    2,
+	PollInterval: time.Hour,
+	RetryInitial: time.Millisecond,
+	RetryMax:     4 * time.Millisecond,
+}
+
+// flakyPublisher fails the first publishes, or those of one message, and records the
+// others. Delivered publishes that fail anyway model a lost acknowledgement.
+type flakyPublisher struct {
+	MemoryPublisher
+	failures  int
+	failID    int64
+	delivered bool
+	lock      sync.Mutex
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, message OutboxMessage) error {
+	p.lock.Lock()
+	fail := p.failures > 0 || message.ID == p.failID
+	if fail {
+		p.failures--
+	}
+	p.lock.Unlock()
+	if fail && !p.delivered {
+		return errors.New("broker unavailable")
+	}
+	_ = p.MemoryPublisher.Publish(ctx, message)
+	if fail {
+		return errors.New("acknowledgement lost")
+	}
+	return nil
+}
+
+func recordOrders(t *testing.T, outbox Outbox, count int) {
+	for i := 1; i <= count; i++ {
+		outbox.Record(orderPlaced(int32(i), 1, nil))
+	}
+}
+
+func TestOutboxRelay_Flush(t *testing.T) {
+	tests := []struct {
+		name      string
+		publisher *flakyPublisher
+		expected  []int64
+		pending   []int64
+	}{
+		{"published", &flakyPublisher{}, []int64{1, 2, 3}, []int64{}},
+		{"stops at failure", &flakyPublisher{failID: 2}, []int64{1}, []int64{2, 3}},
+		{"lost acknowledgement", &flakyPublisher{failID: 2, delivered: true}, []int64{1, 2}, []int64{2, 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outbox := NewMemoryOutbox()
+			recordOrders(t, outbox, 3)
+			relay := NewOutboxRelay(testOutboxConfig, outbox, test.publisher, nil)
+
+			err := relay.Flush(context.Background())
+			assert.Equal(t, len(test.pending) > 0, err != nil)
+			assert.Equal(t, test.expected, ids(test.publisher.Messages()))
+			pending, err := outbox.Pending(10)
+			require.NoError(t, err)
+			assert.Equal(t, test.pending, ids(pending))
+		})
+	}
+}
+
+func TestOutboxRelay_RunRetriesInOrder(t *testing.T) {
+	outbox := NewMemoryOutbox()
+	publisher := &flakyPublisher{failures: 3}
+	relay := NewOutboxRelay(testOutboxConfig, outbox, publisher, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(stopped)
+	}()
+
+	recordOrders(t, outbox, 5)
+	require.Eventually(t, func() bool { return len(publisher.Messages()) == 5 }, time.Second, time.Millisecond)
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, ids(publisher.Messages()))
+
+	// Woken up by new messages, not by the poll interval of an hour.
+	order := &Order{ID: 1, CustomerId: 1}
+	outbox.Record(statusChanged(order, pb.OrderStatus_COMPLETED, "done"))
+	require.Eventually(t, func() bool { return len(publisher.Messages()) == 6 }, time.Second, time.Millisecond)
+
+	cancel()
+	<-stopped
+}
+
+func TestOutboxRelay_RetryDelay(t *testing.T) {
+	relay := NewOutboxRelay(OutboxConfig{RetryInitial: 100 * time.Millisecond, RetryMax: time.Second}, nil, nil, nil)
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, relay.retryDelay(test.failures))
+	}
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func subjects(messages []OutboxMessage) []string {
+	result := make([]string, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message.Subject)
+	}
+	return result
+}
+
+func ids(messages []OutboxMessage) []int64 {
+	result := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message.ID)
+	}
+	return result
+}
+
+func TestMemoryOutbox(t *testing.T) {
+	outbox := NewMemoryOutbox()
+	order := &Order{ID: 1, CustomerId: 2}
+	outbox.Record(quoteCleared(2, "checkout"))
+	outbox.Record(orderPlaced(1, 2, map[int32]*OrderItem{1: {LineID: 1, ProductID: 101, Quantity: 1, Price: 10}}))
+	outbox.Record(statusChanged(order, pb.OrderStatus_PROCESSED, "shipping"))
+	outbox.Record(statusChanged(order, pb.OrderStatus_COMPLETED, "done"))
+
+	select {
+	case <-outbox.Ready():
+	default:
+		t.Fatal("outbox not ready after recording messages")
+	}
+	pending, err := outbox.Pending(2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, ids(pending))
+	assert.Equal(t, []string{SubjectOrderPlaced, SubjectOrderStatusChanged}, subjects(pending))
+
+	var event Event
+	require.NoError(t, json.Unmarshal(pending[1].Payload, &event))
+	assert.Equal(t, EventStatusChanged, event.Type)
+	assert.Equal(t, "PROCESSED", event.Status)
+	assert.Equal(t, int32(1), event.OrderId)
+
+	require.NoError(t, outbox.Ack(2))
+	pending, err = outbox.Pending(10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3}, ids(pending))
+
+	// The IDs start over with the next process, the origin tells them apart.
+	restarted := NewMemoryOutbox()
+	restarted.Record(statusChanged(order, pb.OrderStatus_COMPLETED, "done"))
+	again, err := restarted.Pending(10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1}, ids(again))
+	assert.Equal(t, restarted.origin+"-1", again[0].Key())
+	assert.NotEqual(t, outbox.origin, restarted.origin)
+}
+
+func TestEventLogOutbox_ReadsOnlyNewEvents(t *testing.T) {
+	log := NewMemoryEventLog()
+	order := &Order{ID: 1, CustomerId: 1}
+	require.NoError(t, log.Append(
+		orderPlaced(1, 1, nil),
+		quoteCleared(1, "checkout"),
+		statusChanged(order, pb.OrderStatus_PROCESSED, "shipping"),
+		itemAdded(2, EventItem{LineId: 1, ProductId: 101, Quantity: 1, Price: 10}),
+	))
+	outbox, err := NewEventLogOutbox(log, "")
+	require.NoError(t, err)
+
+	pending, err := outbox.Pending(10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 3}, ids(pending))
+	assert.Equal(t, int64(4), outbox.read)
+	assert.NotEmpty(t, pending[0].Origin, "the IDs of a memory log start over on restart")
+
+	// Unacknowledged messages come from memory, the log is read after the last event seen.
+	require.NoError(t, outbox.Ack(1))
+	require.NoError(t, log.Append(statusChanged(order, pb.OrderStatus_COMPLETED, "done")))
+	pending, err = outbox.Pending(10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 5}, ids(pending))
+	assert.Equal(t, int64(5), outbox.read)
+}
+
+func TestEventLogOutbox_CursorSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenFileEventLog(filepath.Join(dir, "events.jsonl"))
+	require.NoError(t, err)
+	defer log.Close()
+	cursor := filepath.Join(dir, "outbox.cursor")
+	order := &Order{ID: 1, CustomerId: 1}
+	require.NoError(t, log.Append(
+		itemAdded(1, EventItem{LineId: 1, ProductId: 101, Quantity: 1, Price: 10}),
+		orderPlaced(1, 1, nil),
+		quoteCleared(1, "checkout"),
+		statusChanged(order, pb.OrderStatus_PROCESSED, "shipping"),
+		statusChanged(order, pb.OrderStatus_COMPLETED, "done"),
+	))
+
+	outbox, err := NewEventLogOutbox(log, cursor)
+	require.NoError(t, err)
+	pending, err := outbox.Pending(2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 4}, ids(pending))
+	require.NoError(t, outbox.Ack(4))
+
+	outbox, err = NewEventLogOutbox(log, cursor)
+	require.NoError(t, err)
+	pending, err = outbox.Pending(10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5}, ids(pending))
+}
+
+func TestOrderServer_RecordsOutboxMessages(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, fixedPriceCatalog{10}, nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, fixedPriceCatalog{10}, nil)
+	outbox := NewMemoryOutbox()
+	AttachOutbox(outbox, orderServer)
+	ctx := context.Background()
+
+	_, err := quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 2})
+	require.NoError(t, err)
+	require.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: ctx}))
+
+	pending, err := outbox.Pending(10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{SubjectOrderPlaced, SubjectOrderStatusChanged, SubjectOrderStatusChanged}, subjects(pending))
+	var placed Event
+	require.NoError(t, json.Unmarshal(pending[0].Payload, &placed))
+	assert.Equal(t, []EventItem{{LineId: 1, ProductId: 101, Quantity: 2, Price: 10}}, placed.Items)
+}
+
+func TestOrderServer_EventLogIsTheOutbox(t *testing.T) {
+	log := NewMemoryEventLog()
+	quoteServer, orderServer, _ := newEventServers(t, log)
+	outbox, err := NewEventLogOutbox(log, "")
+	require.NoError(t, err)
+	AttachOutbox(outbox, orderServer)
+	ctx := context.Background()
+
+	_, err = quoteServer.AddProduct(ctx, &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 1})
+	require.NoError(t, err)
+	require.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: ctx}))
+
+	<-outbox.Ready()
+	pending, err := outbox.Pending(10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 4, 5}, ids(pending))
+}
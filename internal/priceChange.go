@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// PriceTokenMetadata is the metadata key of the price token a client sends with
+// PlaceOrder to accept the changed prices.
+const PriceTokenMetadata = "price-token"
+
+// PriceChange describes a quote line whose catalog price differs from the price
+// the customer saw when the line was added.
+type PriceChange struct {
+	LineID    int32
+	ProductID int32
+	OldPrice  float32
+	NewPrice  float32
+}
+
+// PriceChangedError stops a checkout until the customer has confirmed the new prices.
+// Placing the order again with the token accepts them, a retry without it fails again.
+type PriceChangedError struct {
+	Changes []PriceChange
+	// Token names the new prices, see priceToken.
+	Token string
+}
+
+func (e *PriceChangedError) Error() string {
+	changes := make([]string, 0, len(e.Changes))
+	for _, change := range e.Changes {
+		changes = append(changes, fmt.Sprintf("line %d (product %d): %.2f -> %.2f", change.LineID, change.ProductID, change.OldPrice, change.NewPrice))
+	}
+	return fmt.Sprintf("prices changed, place the order again with price token %s to accept them: %s", e.Token, strings.Join(changes, ", "))
+}
+
+// GRPCStatus reports the price change as a failed precondition with the changed
+// lines in the error details, keyed by line since variants of a product share its ID.
+func (e *PriceChangedError) GRPCStatus() *status.Status {
+	metadata := make(map[string]string, len(e.Changes))
+	for _, change := range e.Changes {
+		metadata[fmt.Sprintf("line_%d", change.LineID)] = fmt.Sprintf("%.2f -> %.2f", change.OldPrice, change.NewPrice)
+	}
+	metadata["price_token"] = e.Token
+	domainErr := FailedPrecondition("PRICE_CHANGED", "%s", e.Error())
+	domainErr.Metadata = metadata
+	return domainErr.GRPCStatus()
+}
+
+// priceToken names the new prices of the changed lines of the customer. It changes with
+// any of them, so a token confirms exactly the prices the client was shown.
+func priceToken(customerId int32, changes []PriceChange) string {
+	hash := sha256.New()
+	_ = binary.Write(hash, binary.BigEndian, customerId)
+	for _, change := range changes {
+		_ = binary.Write(hash, binary.BigEndian, change.LineID)
+		_ = binary.Write(hash, binary.BigEndian, math.Float32bits(change.NewPrice))
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:12])
+}
+
+// priceTokenFromContext returns the price token the client sent, empty when none.
+func priceTokenFromContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(PriceTokenMetadata); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceChangedError_Error(t *testing.T) {
+	err := &PriceChangedError{Changes: []PriceChange{
+		{LineID: 1, ProductID: 101, OldPrice: 10, NewPrice: 15},
+		{LineID: 2, ProductID: 102, OldPrice: 20, NewPrice: 18.5},
+	}, Token: "abc"}
+	assert.Equal(t, "prices changed, place the order again with price token abc to accept them: line 1 (product 101): 10.00 -> 15.00, line 2 (product 102): 20.00 -> 18.50", err.Error())
+}
+
+func TestPriceToken(t *testing.T) {
+	changes := []PriceChange{{LineID: 1, ProductID: 101, OldPrice: 10, NewPrice: 15}}
+	token := priceToken(1, changes)
+	assert.Equal(t, token, priceToken(1, []PriceChange{{LineID: 1, ProductID: 101, OldPrice: 12, NewPrice: 15}}))
+	assert.NotEqual(t, token, priceToken(2, changes), "other customer")
+	assert.NotEqual(t, token, priceToken(1, []PriceChange{{LineID: 1, ProductID: 101, OldPrice: 10, NewPrice: 16}}), "other price")
+	assert.NotEqual(t, token, priceToken(1, []PriceChange{{LineID: 2, ProductID: 101, OldPrice: 10, NewPrice: 15}}), "other line")
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Publisher delivers the outbox messages to the other services. Publish returns once
+// the message is safely handed over, the relay retries it on an error.
+type Publisher interface {
+	Publish(ctx context.Context, message OutboxMessage) error
+	Close() error
+}
+
+// OpenPublisher connects the publisher of the configuration.
+func OpenPublisher(config OutboxConfig) (Publisher, error) {
+	switch config.Publisher {
+	case "file":
+		return OpenFilePublisher(config.File)
+	case "nats":
+		return NewNATSPublisher(config.NATS)
+	}
+	return NewMemoryPublisher(), nil
+}
+
+// MemoryPublisher keeps the published messages in memory, for tests and local runs.
+type MemoryPublisher struct {
+	messages []OutboxMessage
+	lock     sync.Mutex
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, message OutboxMessage) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+// Messages returns the messages published so far.
+func (p *MemoryPublisher) Messages() []OutboxMessage {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]OutboxMessage(nil), p.messages...)
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
+
+// FilePublisher appends the messages to a file as JSON lines, synced to disk one by one.
+type FilePublisher struct {
+	file *os.File
+	lock sync.Mutex
+}
+
+func OpenFilePublisher(path string) (*FilePublisher, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening outbox file: %v", err)
+	}
+	return &FilePublisher{file: file}, nil
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, message OutboxMessage) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, err := p.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+func (p *FilePublisher) Close() error {
+	return p.file.Close()
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMessage(id int64, subject string) OutboxMessage {
+	return OutboxMessage{
+		ID:      id,
+		Subject: subject,
+		Time:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Payload: json.RawMessage(`{"type":"OrderPlaced","order_id":1}`),
+	}
+}
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	publisher, err := OpenFilePublisher(path)
+	require.NoError(t, err)
+	require.NoError(t, publisher.Publish(context.Background(), testMessage(1, SubjectOrderPlaced)))
+	require.NoError(t, publisher.Close())
+
+	// Reopening appends after the messages already published.
+	publisher, err = OpenFilePublisher(path)
+	require.NoError(t, err)
+	require.NoError(t, publisher.Publish(context.Background(), testMessage(2, SubjectOrderStatusChanged)))
+	require.NoError(t, publisher.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	messages := make([]OutboxMessage, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var message OutboxMessage
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &message))
+		messages = append(messages, message)
+	}
+	assert.Equal(t, []OutboxMessage{testMessage(1, SubjectOrderPlaced), testMessage(2, SubjectOrderStatusChanged)}, messages)
+}
+
+func TestOpenPublisher(t *testing.T) {
+	publisher, err := OpenPublisher(OutboxConfig{Publisher: "memory"})
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryPublisher{}, publisher)
+
+	publisher, err = OpenPublisher(OutboxConfig{Publisher: "file", File: filepath.Join(t.TempDir(), "outbox.jsonl")})
+	require.NoError(t, err)
+	assert.IsType(t, &FilePublisher{}, publisher)
+	assert.NoError(t, publisher.Close())
+
+	_, err = OpenPublisher(OutboxConfig{Publisher: "file", File: filepath.Join(t.TempDir(), "missing", "outbox.jsonl")})
+	assert.Error(t, err)
+}
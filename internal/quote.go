@@ -2,166 +2,389 @@ package internal
 
 import (
 	"context"
-	"fmt"
+	"sort"
 	"sync"
 
 	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
 )
 
 type QuoteItem struct {
+	LineID    int32
 	ProductID int32
+	Variant   string
+	Options   map[string]string
 	Quantity  int32
+	// Price is the catalog price at the moment the customer last added or updated the line.
+	Price float32
+}
+
+// sameLine reports whether the item is the line for the given product, variant and options.
+// Items are merged into one line only when all of these attributes match.
+func (i *QuoteItem) sameLine(productId int32, variant string, options map[string]string) bool {
+	if i.ProductID != productId || i.Variant != variant || len(i.Options) != len(options) {
+		return false
+	}
+	for name, value := range options {
+		if current, exists := i.Options[name]; !exists || current != value {
+			return false
+		}
+	}
+	return true
 }
 
 type Quote struct {
 	Items      map[int32]*QuoteItem
 	CustomerId int32
+	lastLineID int32
+}
+
+// addItem appends a new line to the quote. Line IDs grow with every added line
+// and are never reused, so they also keep the insertion order of the items.
+func (q *Quote) addItem(productId int32, variant string, options map[string]string, quantity int32, price float32) *QuoteItem {
+	q.lastLineID = q.nextLineID()
+	item := &QuoteItem{
+		LineID:    q.lastLineID,
+		ProductID: productId,
+		Variant:   variant,
+		Options:   copyOptions(options),
+		Quantity:  quantity,
+		Price:     price,
+	}
+	q.Items[item.LineID] = item
+	return item
+}
+
+// nextLineID is the ID of the next line added to the quote.
+func (q *Quote) nextLineID() int32 {
+	lineId := q.lastLineID
+	for {
+		lineId++
+		if _, taken := q.Items[lineId]; !taken {
+			return lineId
+		}
+	}
+}
+
+func (q *Quote) findItem(productId int32, variant string, options map[string]string) (*QuoteItem, bool) {
+	_, item, exists := q.findLine(productId, variant, options)
+	return item, exists
+}
+
+// findLine is findItem also returning the line ID the item is stored under.
+func (q *Quote) findLine(productId int32, variant string, options map[string]string) (int32, *QuoteItem, bool) {
+	for lineId, item := range q.Items {
+		if item.sameLine(productId, variant, options) {
+			return lineId, item, true
+		}
+	}
+	return 0, nil, false
+}
+
+// SortedItems returns the quote items in the order they were added.
+func (q *Quote) SortedItems() []*QuoteItem {
+	items := make([]*QuoteItem, 0, len(q.Items))
+	for _, item := range q.Items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].LineID != items[j].LineID {
+			return items[i].LineID < items[j].LineID
+		}
+		return items[i].ProductID < items[j].ProductID
+	})
+	return items
+}
+
+func copyOptions(options map[string]string) map[string]string {
+	if len(options) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(options))
+	for name, value := range options {
+		c[name] = value
+	}
+	return c
+}
+
+// copyQuote returns a deep copy of the quote, changing it leaves the original untouched.
+func copyQuote(quote *Quote) *Quote {
+	c := *quote
+	c.Items = make(map[int32]*QuoteItem, len(quote.Items))
+	for lineId, item := range quote.Items {
+		itemCopy := *item
+		itemCopy.Options = copyOptions(item.Options)
+		c.Items[lineId] = &itemCopy
+	}
+	return &c
 }
 
 type QuoteServer struct {
 	pb.UnimplementedQuoteServiceServer
-	qouteStorage QuoteStorageInterface
+	qouteStorage  QuoteStorageInterface
+	catalogClient CatalogClientInterface
+	config        QuoteConfig
+	metrics       *Metrics
 }
 
-func NewQuoteServer() (*QuoteServer, QuoteStorageInterface) {
+func NewQuoteServer(config QuoteConfig, catalogClient CatalogClientInterface, metrics *Metrics) (*QuoteServer, QuoteStorageInterface) {
 	quoteStorage := &QuoteStorage{
-		make(map[int32]*Quote),
-		sync.RWMutex{},
+		quotes: make(map[int32]*Quote),
+		limits: config,
 	}
 
 	return &QuoteServer{
-		qouteStorage: quoteStorage,
+		qouteStorage:  quoteStorage,
+		catalogClient: catalogClient,
+		config:        config,
+		metrics:       metrics,
 	}, quoteStorage
 }
 
+// QuoteStorageInterface is implemented by the quote storage backends. The quotes they
+// return are copies, changing them does not change the storage. Every change checks the
+// quote limits in the same transaction, so concurrent requests cannot pass them together.
+// An empty quote is a missing one for the changes of existing lines.
 type QuoteStorageInterface interface {
-	GetQuote(int32) *Quote
-	GetQuoteUnsafe(int32) *Quote
-	AddProduct(customerId int32, productId int32, quantity int32) *Quote
+	// AddLine adds the quantity to the line of the product, variant and options, a new
+	// line is added when the quote has none.
+	AddLine(customerId int32, productId int32, variant string, options map[string]string, quantity int32, price float32) (*Quote, error)
 	RemoveProduct(customerId int32, productId int32) (*Quote, error)
-	UpdateQuantity(customerId int32, productId int32, quantity int32) (*Quote, error)
-	ClearQuote(customerId int32)
-	ClearQuoteUnsafe(customerId int32)
-	LockQuoteRead()
-	UnlockQuoteRead()
-	LockQuoteWrite()
-	UnlockQuoteWrite()
+	UpdateQuantity(customerId int32, productId int32, quantity int32, price float32) (*Quote, error)
+	RemoveLine(customerId int32, lineId int32) (*Quote, error)
+	UpdateLineQuantity(customerId int32, lineId int32, quantity int32, price float32) (*Quote, error)
+	// ViewQuote runs fn with the quote of the customer, an empty quote when it has none,
+	// while no change to it can happen. fn must not change the quote.
+	ViewQuote(customerId int32, fn func(quote *Quote))
+	// ConsumeQuote runs fn with a copy of the quote of the customer and deletes the quote
+	// when fn returns nil, no other change to the quote happens in between.
+	// The events fn returns, the order made from the quote, are recorded in the same
+	// append as the deletion, the caller applies them once ConsumeQuote succeeded.
+	ConsumeQuote(customerId int32, fn func(quote *Quote) ([]*Event, error)) error
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
 }
 
+// QuoteStorage keeps the quotes in memory. qouteLock guards the quotes map and is only
+// held to look up, add or delete a quote, the customer locks guard the content of the
+// quotes. A customer lock is always taken before qouteLock.
+//
+// Every change is made by recording events, with an event log they are appended to it
+// first so the quotes can be rebuilt from the log.
 type QuoteStorage struct {
-	quotes    map[int32]*Quote
-	qouteLock sync.RWMutex
+	quotes        map[int32]*Quote
+	qouteLock     sync.RWMutex
+	customerLocks stripedLock
+	events        EventLog
+	limits        QuoteConfig
 }
 
 /**
  * QuoteStorageImpl
  */
 
-func (s *QuoteStorage) GetQuote(customerId int32) *Quote {
-	s.LockQuoteRead()
+func (s *QuoteStorage) findQuote(customerId int32) (*Quote, bool) {
+	s.qouteLock.RLock()
+	defer s.qouteLock.RUnlock()
 	quote, exists := s.quotes[customerId]
-	s.UnlockQuoteRead()
+	return quote, exists
+}
 
+func (s *QuoteStorage) ViewQuote(customerId int32, fn func(quote *Quote)) {
+	lock := s.customerLocks.stripe(customerId)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	quote, exists := s.findQuote(customerId)
 	if !exists {
-		s.LockQuoteWrite()
-		defer s.UnlockQuoteWrite()
+		quote = &Quote{CustomerId: customerId, Items: make(map[int32]*QuoteItem)}
+	}
+	fn(quote)
+}
 
-		quote = &Quote{
-			CustomerId: customerId,
-			Items:      make(map[int32]*QuoteItem),
+// record appends the events to the event log and applies them to the quotes, the
+// caller holds the lock of the customer. Nothing changes when the log fails.
+func (s *QuoteStorage) record(events ...*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if s.events != nil {
+		if err := s.events.Append(events...); err != nil {
+			return eventLogError(err)
 		}
-		s.quotes[customerId] = quote
 	}
-	return quote
+	s.apply(events...)
+	return nil
 }
 
-func (s *QuoteStorage) GetQuoteUnsafe(customerId int32) *Quote {
-	quote, exists := s.quotes[customerId]
-	if !exists {
-		quote = &Quote{
-			CustomerId: customerId,
-			Items:      make(map[int32]*QuoteItem),
-		}
-		s.quotes[customerId] = quote
+// apply changes the quotes as the recorded events say, the caller holds the lock of the customer.
+func (s *QuoteStorage) apply(events ...*Event) {
+	s.qouteLock.Lock()
+	defer s.qouteLock.Unlock()
+	for _, event := range events {
+		applyQuoteEvent(s.quotes, event)
 	}
-	return quote
 }
 
-func (s *QuoteStorage) ClearQuote(customerId int32) {
-	s.LockQuoteWrite()
-	defer s.UnlockQuoteWrite()
+// withQuote runs fn with a copy of the quote of the customer, an empty quote when it has
+// none, and records the changes fn made to it. Nothing changes when fn fails.
+func (s *QuoteStorage) withQuote(customerId int32, fn func(quote *Quote) error) (*Quote, error) {
+	s.lockQuote(customerId)
+	defer s.unlockQuote(customerId)
 
-	s.ClearQuoteUnsafe(customerId)
+	quote, exists := s.findQuote(customerId)
+	if !exists {
+		quote = &Quote{CustomerId: customerId, Items: make(map[int32]*QuoteItem)}
+	}
+	changed := copyQuote(quote)
+	if err := fn(changed); err != nil {
+		return nil, err
+	}
+	if err := s.record(diffQuote(quote, changed)...); err != nil {
+		return nil, err
+	}
+	return copyQuote(changed), nil
 }
 
-func (s *QuoteStorage) ClearQuoteUnsafe(customerId int32) {
-	delete(s.quotes, customerId)
-}
+func (s *QuoteStorage) ConsumeQuote(customerId int32, fn func(quote *Quote) ([]*Event, error)) error {
+	s.lockQuote(customerId)
+	defer s.unlockQuote(customerId)
 
-func (s *QuoteStorage) LockQuoteRead() {
-	s.qouteLock.RLock()
+	quote, exists := s.findQuote(customerId)
+	if !exists {
+		quote = &Quote{CustomerId: customerId, Items: make(map[int32]*QuoteItem)}
+	}
+	events, err := fn(copyQuote(quote))
+	if err != nil {
+		return err
+	}
+	cleared := quoteCleared(customerId, "checkout")
+	if s.events != nil {
+		if err := s.events.Append(append(events, cleared)...); err != nil {
+			return eventLogError(err)
+		}
+	}
+	s.apply(cleared)
+	return nil
 }
 
-func (s *QuoteStorage) UnlockQuoteRead() {
-	s.qouteLock.RUnlock()
+func (s *QuoteStorage) lockQuote(customerId int32) {
+	s.customerLocks.stripe(customerId).Lock()
 }
 
-func (s *QuoteStorage) LockQuoteWrite() {
-	s.qouteLock.Lock()
+func (s *QuoteStorage) unlockQuote(customerId int32) {
+	s.customerLocks.stripe(customerId).Unlock()
 }
 
-func (s *QuoteStorage) UnlockQuoteWrite() {
-	s.qouteLock.Unlock()
+// Ping reports whether the storage is usable, with an event log whether the changes
+// can be recorded in it.
+func (s *QuoteStorage) Ping(ctx context.Context) error {
+	if s.events == nil {
+		return nil
+	}
+	return s.events.Ping()
 }
 
-func (s *QuoteStorage) AddProduct(customerId int32, productId int32, quantity int32) *Quote {
-	quote := s.GetQuote(customerId)
+// Close flushes the storage on shutdown, there is nothing to flush for the in-memory storage.
+func (s *QuoteStorage) Close(ctx context.Context) error {
+	return nil
+}
 
-	s.LockQuoteWrite()
-	defer s.UnlockQuoteWrite()
-	item, exexists := quote.Items[productId]
-	if exexists {
-		item.Quantity += quantity
-	} else {
-		quote.Items[productId] = &QuoteItem{
-			ProductID: productId,
-			Quantity:  quantity,
+func (s *QuoteStorage) AddLine(customerId int32, productId int32, variant string, options map[string]string, quantity int32, price float32) (*Quote, error) {
+	return s.withQuote(customerId, func(quote *Quote) error {
+		item, exists := quote.findItem(productId, variant, options)
+		newQuantity := quantity
+		if exists {
+			newQuantity += item.Quantity
 		}
-	}
-	return quote
+		if err := s.checkLimits(quote, item, productId, newQuantity); err != nil {
+			return err
+		}
+		if exists {
+			item.Quantity = newQuantity
+			item.Price = price
+		} else {
+			quote.addItem(productId, variant, options, quantity, price)
+		}
+		return nil
+	})
 }
 
+// RemoveProduct removes every line of the product, whatever its variant and options are.
 func (s *QuoteStorage) RemoveProduct(customerId int32, productId int32) (*Quote, error) {
-	s.LockQuoteWrite()
-	defer s.UnlockQuoteWrite()
-
-	quote, exists := s.quotes[customerId]
-	if !exists {
-		return nil, fmt.Errorf("quote not found")
-	}
-	delete(quote.Items, productId)
-	return quote, nil
+	return s.withQuote(customerId, func(quote *Quote) error {
+		if len(quote.Items) == 0 {
+			return NotFound("QUOTE_NOT_FOUND", "quote of customer %d not found", customerId)
+		}
+		for lineId, item := range quote.Items {
+			if item.ProductID == productId {
+				delete(quote.Items, lineId)
+			}
+		}
+		return nil
+	})
 }
 
-func (s *QuoteStorage) UpdateQuantity(customerId int32, productId int32, quantity int32) (*Quote, error) {
-	s.LockQuoteWrite()
-	defer s.UnlockQuoteWrite()
+// UpdateQuantity sets the quantity of the product line without variant and options.
+func (s *QuoteStorage) UpdateQuantity(customerId int32, productId int32, quantity int32, price float32) (*Quote, error) {
+	return s.withQuote(customerId, func(quote *Quote) error {
+		if len(quote.Items) == 0 {
+			return NotFound("QUOTE_NOT_FOUND", "quote of customer %d not found", customerId)
+		}
+		item, exists := quote.findItem(productId, "", nil)
+		if err := s.checkLimits(quote, item, productId, quantity); err != nil {
+			return err
+		}
+		if exists {
+			item.Quantity = quantity
+			item.Price = price
+		} else {
+			quote.addItem(productId, "", nil, quantity, price)
+		}
+		return nil
+	})
+}
 
-	quote, exists := s.quotes[customerId]
-	if !exists {
-		return nil, fmt.Errorf("quote not found")
-	}
+func (s *QuoteStorage) RemoveLine(customerId int32, lineId int32) (*Quote, error) {
+	return s.withQuote(customerId, func(quote *Quote) error {
+		if len(quote.Items) == 0 {
+			return NotFound("QUOTE_NOT_FOUND", "quote of customer %d not found", customerId)
+		}
+		if _, exists := quote.Items[lineId]; !exists {
+			return NotFound("QUOTE_LINE_NOT_FOUND", "quote line %d not found", lineId)
+		}
+		delete(quote.Items, lineId)
+		return nil
+	})
+}
 
-	item, exexists := quote.Items[productId]
-	if exexists {
-		item.Quantity = quantity
-	} else {
-		quote.Items[productId] = &QuoteItem{
-			ProductID: productId,
-			Quantity:  quantity,
+func (s *QuoteStorage) UpdateLineQuantity(customerId int32, lineId int32, quantity int32, price float32) (*Quote, error) {
+	return s.withQuote(customerId, func(quote *Quote) error {
+		if len(quote.Items) == 0 {
+			return NotFound("QUOTE_NOT_FOUND", "quote of customer %d not found", customerId)
+		}
+		item, exists := quote.Items[lineId]
+		if !exists {
+			return NotFound("QUOTE_LINE_NOT_FOUND", "quote line %d not found", lineId)
+		}
+		if err := s.checkLimits(quote, item, item.ProductID, quantity); err != nil {
+			return err
 		}
+		item.Quantity = quantity
+		item.Price = price
+		return nil
+	})
+}
+
+// checkLimits verifies that the line, nil for a new one, keeps the quote within the
+// configured limits with the new quantity.
+func (s *QuoteStorage) checkLimits(quote *Quote, item *QuoteItem, productId int32, quantity int32) error {
+	if item == nil && s.limits.MaxLines > 0 && len(quote.Items) >= s.limits.MaxLines {
+		return FailedPrecondition("QUOTE_LINE_LIMIT", "quote cannot have more than %d lines", s.limits.MaxLines)
 	}
-	return quote, nil
+	if s.limits.MaxLineQuantity > 0 && quantity > s.limits.MaxLineQuantity {
+		return InvalidArgument("quantity", "QUANTITY_LIMIT", "quantity of product %d cannot exceed %d", productId, s.limits.MaxLineQuantity)
+	}
+	return nil
 }
 
 /**
@@ -169,40 +392,182 @@ func (s *QuoteStorage) UpdateQuantity(customerId int32, productId int32, quantit
  */
 
 func (s *QuoteServer) AddProduct(ctx context.Context, in *pb.ProductRequest) (*pb.Quote, error) {
-	quote := s.qouteStorage.AddProduct(in.CustomerId, in.ProductId, in.Quantity)
-	protoQuote := quoteToProto(quote)
-	return protoQuote, nil
+	quote, err := s.addLine(ctx, in.CustomerId, in.ProductId, "", nil, in.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	return quoteToProto(quote), nil
 }
 
 func (s *QuoteServer) GetQuote(ctx context.Context, in *pb.CustomerId) (*pb.Quote, error) {
-	protoQuote := quoteToProto(s.qouteStorage.GetQuote(in.Id))
+	if err := authorizeCustomer(ctx, in.Id); err != nil {
+		return nil, err
+	}
+	_, span := startSpan(ctx, "QuoteStorage.GetQuote", customerAttribute(in.Id))
+	var protoQuote *pb.Quote
+	s.qouteStorage.ViewQuote(in.Id, func(quote *Quote) {
+		protoQuote = quoteToProto(quote)
+	})
+	span.End()
 	return protoQuote, nil
 }
 
 func (s *QuoteServer) RemoveProduct(ctx context.Context, in *pb.ProductRequest) (*pb.Quote, error) {
-	quote, err := s.qouteStorage.RemoveProduct(in.CustomerId, in.ProductId)
+	quote, err := s.removeProduct(ctx, in.CustomerId, in.ProductId)
 	if err != nil {
 		return nil, err
 	}
-	protoQuote := quoteToProto(quote)
-	return protoQuote, nil
+	return quoteToProto(quote), nil
 }
 
 func (s *QuoteServer) UpdateQuantity(ctx context.Context, in *pb.ProductRequest) (*pb.Quote, error) {
-	quote, err := s.qouteStorage.UpdateQuantity(in.CustomerId, in.ProductId, in.Quantity)
+	quote, err := s.updateQuantity(ctx, in.CustomerId, in.ProductId, in.Quantity)
 	if err != nil {
 		return nil, err
 	}
-	protoQuote := quoteToProto(quote)
-	return protoQuote, nil
+	return quoteToProto(quote), nil
+}
+
+// The unexported methods return the quote itself, with the line IDs the proto quote
+// does not have, for the gateway.
+
+// getQuote returns a copy of the quote of the customer.
+func (s *QuoteServer) getQuote(ctx context.Context, customerId int32) (*Quote, error) {
+	if err := authorizeCustomer(ctx, customerId); err != nil {
+		return nil, err
+	}
+	_, span := startSpan(ctx, "QuoteStorage.GetQuote", customerAttribute(customerId))
+	var quote *Quote
+	s.qouteStorage.ViewQuote(customerId, func(q *Quote) {
+		quote = copyQuote(q)
+	})
+	span.End()
+	return quote, nil
+}
+
+func (s *QuoteServer) addLine(ctx context.Context, customerId int32, productId int32, variant string, options map[string]string, quantity int32) (*Quote, error) {
+	if err := authorizeCustomer(ctx, customerId); err != nil {
+		return nil, err
+	}
+	if err := validateItem(productId, quantity); err != nil {
+		return nil, err
+	}
+	price, err := s.productPrice(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+	_, span := startSpan(ctx, "QuoteStorage.AddProduct", customerAttribute(customerId))
+	quote, err := s.qouteStorage.AddLine(customerId, productId, variant, options, quantity, price)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	// The quote was empty when its only line has just the added quantity.
+	if len(quote.Items) == 1 {
+		for _, item := range quote.Items {
+			if item.Quantity == quantity {
+				s.metrics.CartCreated()
+			}
+		}
+	}
+	s.metrics.ItemsAdded(quantity)
+	return quote, nil
+}
+
+func (s *QuoteServer) removeProduct(ctx context.Context, customerId int32, productId int32) (*Quote, error) {
+	if err := authorizeCustomer(ctx, customerId); err != nil {
+		return nil, err
+	}
+	_, span := startSpan(ctx, "QuoteStorage.RemoveProduct", customerAttribute(customerId))
+	quote, err := s.qouteStorage.RemoveProduct(customerId, productId)
+	endSpan(span, err)
+	return quote, err
+}
+
+func (s *QuoteServer) updateQuantity(ctx context.Context, customerId int32, productId int32, quantity int32) (*Quote, error) {
+	if err := authorizeCustomer(ctx, customerId); err != nil {
+		return nil, err
+	}
+	if err := validateItem(productId, quantity); err != nil {
+		return nil, err
+	}
+	price, err := s.productPrice(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+	_, span := startSpan(ctx, "QuoteStorage.UpdateQuantity", customerAttribute(customerId))
+	quote, err := s.qouteStorage.UpdateQuantity(customerId, productId, quantity, price)
+	endSpan(span, err)
+	return quote, err
+}
+
+// updateLineQuantity sets the quantity of a quote line, whatever its variant and options are.
+func (s *QuoteServer) updateLineQuantity(ctx context.Context, customerId int32, lineId int32, quantity int32) (*Quote, error) {
+	if err := authorizeCustomer(ctx, customerId); err != nil {
+		return nil, err
+	}
+	if quantity <= 0 {
+		return nil, InvalidArgument("quantity", "INVALID_QUANTITY", "quantity must be positive, got %d", quantity)
+	}
+	// The price is looked up outside the transaction, line IDs are never reused so the
+	// line still has the same product when it is there at all.
+	var productId int32
+	s.qouteStorage.ViewQuote(customerId, func(q *Quote) {
+		if item, exists := q.Items[lineId]; exists {
+			productId = item.ProductID
+		}
+	})
+	if productId == 0 {
+		return nil, NotFound("QUOTE_LINE_NOT_FOUND", "quote line %d not found", lineId)
+	}
+	price, err := s.productPrice(ctx, productId)
+	if err != nil {
+		return nil, err
+	}
+	_, span := startSpan(ctx, "QuoteStorage.UpdateLineQuantity", customerAttribute(customerId))
+	quote, err := s.qouteStorage.UpdateLineQuantity(customerId, lineId, quantity, price)
+	endSpan(span, err)
+	return quote, err
+}
+
+func (s *QuoteServer) removeLine(ctx context.Context, customerId int32, lineId int32) (*Quote, error) {
+	if err := authorizeCustomer(ctx, customerId); err != nil {
+		return nil, err
+	}
+	_, span := startSpan(ctx, "QuoteStorage.RemoveLine", customerAttribute(customerId))
+	quote, err := s.qouteStorage.RemoveLine(customerId, lineId)
+	endSpan(span, err)
+	return quote, err
+}
+
+// validateItem rejects the product IDs and quantities no line can have, before the
+// catalog is asked for the price. Lines are removed, not set to 0.
+func validateItem(productId int32, quantity int32) error {
+	if productId <= 0 {
+		return InvalidArgument("product_id", "INVALID_PRODUCT_ID", "invalid product id %d", productId)
+	}
+	if quantity <= 0 {
+		return InvalidArgument("quantity", "INVALID_QUANTITY", "quantity must be positive, got %d", quantity)
+	}
+	return nil
+}
+
+// productPrice looks up the current catalog price, it is stored with the quote line
+// so the checkout can detect price changes.
+func (s *QuoteServer) productPrice(ctx context.Context, productId int32) (float32, error) {
+	product, err := s.catalogClient.GetProductInfo(ctx, uint64(productId))
+	if err != nil {
+		return 0, catalogError(productId, err)
+	}
+	return product.Price, nil
 }
 
 func quoteToProto(quote *Quote) *pb.Quote {
 	protoQuote := &pb.Quote{}
 	protoQuote.CustomerId = quote.CustomerId
 	protoQuote.Items = make([]*pb.QuoteItem, 0)
-	for _, item := range quote.Items {
-		protoQuote.Items = append(protoQuote.Items, &pb.QuoteItem{ProductId: item.ProductID, Quantity: item.Quantity})
+	for _, item := range quote.SortedItems() {
+		protoQuote.Items = append(protoQuote.Items, &pb.QuoteItem{ProductId: item.ProductID, Quantity: item.Quantity, Price: item.Price})
 	}
 	return protoQuote
 }
@@ -2,20 +2,41 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	pbc "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/catalog"
 	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
+func newPriceCatalogClient(price float32) *MockCatalogClient {
+	catalogClient := NewMockCatalogClient()
+	catalogClient.On("GetProductInfo", mock.Anything).Return(&pbc.Product{Price: price}, nil)
+	return catalogClient
+}
+
+// storedQuote returns a copy of the quote of the customer in the storage.
+func storedQuote(quoteStorage QuoteStorageInterface, customerId int32) *Quote {
+	var quote *Quote
+	quoteStorage.ViewQuote(customerId, func(q *Quote) {
+		quote = copyQuote(q)
+	})
+	return quote
+}
+
 func TestNewQuoteServer(t *testing.T) {
-	quoteServer, quoteStorage := NewQuoteServer()
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, NewMockCatalogClient(), nil)
 	assert.IsType(t, &QuoteServer{}, quoteServer)
 	assert.IsType(t, &QuoteStorage{}, quoteStorage)
 }
 
-func TestQuoteStorageImpl_GetQuote(t *testing.T) {
+func TestQuoteStorageImpl_ViewQuoteOfCustomer(t *testing.T) {
 	tests := []struct {
 		name       string
 		customerId int32
@@ -31,32 +52,86 @@ func TestQuoteStorageImpl_GetQuote(t *testing.T) {
 			qouteLock: sync.RWMutex{},
 		}
 		t.Run(test.name, func(t *testing.T) {
-			quote := quoteStorage.GetQuote(test.customerId)
+			quote := storedQuote(quoteStorage, test.customerId)
 			assert.Equal(t, test.customerId, quote.CustomerId)
 		})
 	}
 }
 
-func TestQuoteStorageImpl_GetQuoteUnsafe(t *testing.T) {
-	tests := []struct {
-		name       string
-		customerId int32
-		quotes     map[int32]*Quote
-	}{
-		{"New customer", 1, make(map[int32]*Quote)},
-		{"Existing customer", 1, map[int32]*Quote{1: {CustomerId: 1, Items: make(map[int32]*QuoteItem)}}},
-	}
+func TestQuoteStorageImpl_WithQuote(t *testing.T) {
+	quoteStorage := &QuoteStorage{quotes: map[int32]*Quote{
+		1: {CustomerId: 1, Items: map[int32]*QuoteItem{1: {LineID: 1, ProductID: 101, Quantity: 1, Price: 10}}, lastLineID: 1},
+	}}
 
-	for _, test := range tests {
-		quoteStorage := &QuoteStorage{
-			quotes:    test.quotes,
-			qouteLock: sync.RWMutex{},
-		}
-		t.Run(test.name, func(t *testing.T) {
-			quote := quoteStorage.GetQuoteUnsafe(test.customerId)
-			assert.Equal(t, test.customerId, quote.CustomerId)
-		})
-	}
+	_, err := quoteStorage.withQuote(1, func(quote *Quote) error {
+		quote.Items[1].Quantity = 5
+		return errors.New("rolled back")
+	})
+	assert.EqualError(t, err, "rolled back")
+	assert.Equal(t, int32(1), storedQuote(quoteStorage, 1).Items[1].Quantity)
+
+	changed, err := quoteStorage.withQuote(1, func(quote *Quote) error {
+		quote.Items[1].Quantity = 5
+		quote.addItem(102, "", nil, 2, 20)
+		return nil
+	})
+	assert.NoError(t, err)
+	quote := storedQuote(quoteStorage, 1)
+	assert.Equal(t, changed, quote)
+	assert.Equal(t, int32(5), quote.Items[1].Quantity)
+	assert.Equal(t, int32(102), quote.Items[2].ProductID)
+
+	_, err = quoteStorage.withQuote(2, func(quote *Quote) error {
+		assert.Equal(t, int32(2), quote.CustomerId)
+		assert.Empty(t, quote.Items)
+		return nil
+	})
+	assert.NoError(t, err)
+	_, exists := quoteStorage.findQuote(2)
+	assert.False(t, exists)
+}
+
+func TestQuoteStorageImpl_ViewQuote(t *testing.T) {
+	quoteStorage := &QuoteStorage{quotes: make(map[int32]*Quote)}
+	_, err := quoteStorage.AddLine(1, 101, "", nil, 2, 10)
+	assert.NoError(t, err)
+
+	quoteStorage.ViewQuote(1, func(quote *Quote) {
+		assert.Len(t, quote.Items, 1)
+	})
+	quoteStorage.ViewQuote(2, func(quote *Quote) {
+		assert.Equal(t, int32(2), quote.CustomerId)
+		assert.Empty(t, quote.Items)
+	})
+	_, exists := quoteStorage.findQuote(2)
+	assert.False(t, exists)
+}
+
+func TestQuoteStorageImpl_ConsumeQuote(t *testing.T) {
+	quoteStorage := &QuoteStorage{quotes: map[int32]*Quote{
+		1: {CustomerId: 1, Items: map[int32]*QuoteItem{1: {LineID: 1, ProductID: 101, Quantity: 1, Price: 10}}},
+	}}
+
+	err := quoteStorage.ConsumeQuote(1, func(quote *Quote) ([]*Event, error) {
+		delete(quote.Items, 1)
+		return nil, errors.New("price changed")
+	})
+	assert.Error(t, err)
+	assert.Len(t, storedQuote(quoteStorage, 1).Items, 1)
+
+	err = quoteStorage.ConsumeQuote(1, func(quote *Quote) ([]*Event, error) {
+		assert.Len(t, quote.Items, 1)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, quoteStorage.quotes, int32(1))
+
+	err = quoteStorage.ConsumeQuote(2, func(quote *Quote) ([]*Event, error) {
+		assert.Empty(t, quote.Items)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, quoteStorage.quotes, int32(2))
 }
 
 func TestQuoteStorageImpl_AddProduct(t *testing.T) {
@@ -80,10 +155,11 @@ func TestQuoteStorageImpl_AddProduct(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			quoteStorage.AddProduct(test.customerId, test.productId, test.quantity)
-			quote := quoteStorage.GetQuote(test.customerId)
+			quoteStorage.AddLine(test.customerId, test.productId, "", nil, test.quantity, 10.0)
+			quote := storedQuote(quoteStorage, test.customerId)
 			assert.Equal(t, test.expectedItems, len(quote.Items))
-			assert.Equal(t, test.expectedQty, quote.Items[test.productId].Quantity)
+			item, _ := quote.findItem(test.productId, "", nil)
+			assert.Equal(t, test.expectedQty, item.Quantity)
 		})
 	}
 }
@@ -119,7 +195,7 @@ func TestQuoteStorageImpl_RemoveProduct(t *testing.T) {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				quote := quoteStorage.GetQuote(test.customerId)
+				quote := storedQuote(quoteStorage, test.customerId)
 				assert.Equal(t, test.expectedItems, len(quote.Items))
 			}
 		})
@@ -153,48 +229,19 @@ func TestQuoteStorageImpl_UpdateQuantity(t *testing.T) {
 			qouteLock: sync.RWMutex{},
 		}
 		t.Run(test.name, func(t *testing.T) {
-			_, err := quoteStorage.UpdateQuantity(test.customerId, test.productId, test.newQuantity)
+			_, err := quoteStorage.UpdateQuantity(test.customerId, test.productId, test.newQuantity, 10.0)
 			if test.expectError {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				quote := quoteStorage.GetQuote(test.customerId)
-				assert.Equal(t, test.expectedQty, quote.Items[test.productId].Quantity)
+				quote := storedQuote(quoteStorage, test.customerId)
+				item, _ := quote.findItem(test.productId, "", nil)
+				assert.Equal(t, test.expectedQty, item.Quantity)
 			}
 		})
 	}
 }
 
-func TestQuoteStorageImpl_ClearQuote(t *testing.T) {
-	tests := []struct {
-		name          string
-		customerId    int32
-		expectedItems int
-	}{
-		{"Clear existing quote", 1, 0},
-		{"Clear non-existing quote", 2, 0},
-	}
-
-	for _, test := range tests {
-		quoteStorage := &QuoteStorage{
-			quotes: map[int32]*Quote{
-				1: {
-					CustomerId: 1,
-					Items: map[int32]*QuoteItem{
-						101: {ProductID: 101, Quantity: 2},
-					},
-				},
-			},
-			qouteLock: sync.RWMutex{},
-		}
-		t.Run(test.name, func(t *testing.T) {
-			quoteStorage.ClearQuote(test.customerId)
-			quote := quoteStorage.GetQuote(test.customerId)
-			assert.Equal(t, test.expectedItems, len(quote.Items))
-		})
-	}
-}
-
 func TestQuoteServer_AddProduct(t *testing.T) {
 
 	tests := []struct {
@@ -228,11 +275,11 @@ func TestQuoteServer_AddProduct(t *testing.T) {
 
 	for _, test := range tests {
 		quoteStorage := &QuoteStorage{
-			test.initStorage,
-			sync.RWMutex{},
+			quotes: test.initStorage,
 		}
 		quoteServer := QuoteServer{
-			qouteStorage: quoteStorage,
+			qouteStorage:  quoteStorage,
+			catalogClient: newPriceCatalogClient(10.0),
 		}
 		t.Run(test.name, func(t *testing.T) {
 			req := &pb.ProductRequest{
@@ -280,11 +327,11 @@ func TestQuoteServer_GetQuote(t *testing.T) {
 
 	for _, test := range tests {
 		quoteStorage := &QuoteStorage{
-			test.initStorage,
-			sync.RWMutex{},
+			quotes: test.initStorage,
 		}
 		quoteServer := QuoteServer{
-			qouteStorage: quoteStorage,
+			qouteStorage:  quoteStorage,
+			catalogClient: newPriceCatalogClient(10.0),
 		}
 		t.Run(test.name, func(t *testing.T) {
 			req := &pb.CustomerId{Id: test.customerId}
@@ -344,11 +391,11 @@ func TestQuoteServer_RemoveProduct(t *testing.T) {
 
 	for _, test := range tests {
 		quoteStorage := &QuoteStorage{
-			test.initStorage,
-			sync.RWMutex{},
+			quotes: test.initStorage,
 		}
 		quoteServer := QuoteServer{
-			qouteStorage: quoteStorage,
+			qouteStorage:  quoteStorage,
+			catalogClient: newPriceCatalogClient(10.0),
 		}
 		t.Run(test.name, func(t *testing.T) {
 			req := &pb.ProductRequest{
@@ -415,11 +462,11 @@ func TestQuoteServer_UpdateQuantity(t *testing.T) {
 
 	for _, test := range tests {
 		quoteStorage := &QuoteStorage{
-			test.initStorage,
-			sync.RWMutex{},
+			quotes: test.initStorage,
 		}
 		quoteServer := QuoteServer{
-			qouteStorage: quoteStorage,
+			qouteStorage:  quoteStorage,
+			catalogClient: newPriceCatalogClient(10.0),
 		}
 		t.Run(test.name, func(t *testing.T) {
 			req := &pb.ProductRequest{
@@ -448,3 +495,412 @@ func TestQuoteToProto(t *testing.T) {
 	assert.Equal(t, quote.CustomerId, protoQuote.CustomerId)
 	assert.Equal(t, len(quote.Items), len(protoQuote.Items))
 }
+
+func TestQuoteToProtoKeepsInsertionOrder(t *testing.T) {
+	quote := &Quote{CustomerId: 1, Items: make(map[int32]*QuoteItem)}
+	for _, productId := range []int32{105, 101, 109, 103} {
+		quote.addItem(productId, "", nil, 1, 10.0)
+	}
+
+	for i := 0; i < 10; i++ {
+		protoQuote := quoteToProto(quote)
+		productIds := make([]int32, 0, len(protoQuote.Items))
+		for _, item := range protoQuote.Items {
+			productIds = append(productIds, item.ProductId)
+		}
+		assert.Equal(t, []int32{105, 101, 109, 103}, productIds)
+	}
+}
+
+func TestQuoteStorageImpl_LineIDs(t *testing.T) {
+	quoteStorage := &QuoteStorage{
+		quotes:    make(map[int32]*Quote),
+		qouteLock: sync.RWMutex{},
+	}
+	quoteStorage.AddLine(1, 101, "", nil, 1, 10.0)
+	quoteStorage.AddLine(1, 102, "", nil, 1, 10.0)
+	quoteStorage.AddLine(1, 101, "", nil, 1, 10.0)
+	_, err := quoteStorage.RemoveProduct(1, 102)
+	assert.NoError(t, err)
+	quote, err := quoteStorage.AddLine(1, 103, "", nil, 1, 10.0)
+	assert.NoError(t, err)
+
+	first, _ := quote.findItem(101, "", nil)
+	last, _ := quote.findItem(103, "", nil)
+	assert.Equal(t, int32(1), first.LineID)
+	assert.Equal(t, int32(3), last.LineID)
+}
+
+func TestQuoteStorageImpl_RemoveLine(t *testing.T) {
+	tests := []struct {
+		name          string
+		customerId    int32
+		lineId        int32
+		expectedItems int
+		expectError   bool
+	}{
+		{"Remove existing line", 1, 1, 0, false},
+		{"Remove non-existing line", 1, 2, 1, true},
+		{"Remove from non-existing quote", 2, 1, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quoteStorage := &QuoteStorage{
+				quotes: map[int32]*Quote{
+					1: {
+						CustomerId: 1,
+						Items: map[int32]*QuoteItem{
+							1: {LineID: 1, ProductID: 101, Quantity: 2},
+						},
+						lastLineID: 1,
+					},
+				},
+				qouteLock: sync.RWMutex{},
+			}
+			_, err := quoteStorage.RemoveLine(test.customerId, test.lineId)
+			if test.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				quote := storedQuote(quoteStorage, test.customerId)
+				assert.Equal(t, test.expectedItems, len(quote.Items))
+			}
+		})
+	}
+}
+
+func TestQuoteStorageImpl_UpdateLineQuantity(t *testing.T) {
+	tests := []struct {
+		name        string
+		customerId  int32
+		lineId      int32
+		newQuantity int32
+		expectError bool
+	}{
+		{"Update existing line", 1, 1, 5, false},
+		{"Update non-existing line", 1, 2, 3, true},
+		{"Update in non-existing quote", 2, 1, 1, true},
+	}
+
+	for _, test := range tests {
+		quoteStorage := &QuoteStorage{
+			quotes: map[int32]*Quote{
+				1: {
+					CustomerId: 1,
+					Items: map[int32]*QuoteItem{
+						1: {LineID: 1, ProductID: 101, Quantity: 2},
+					},
+					lastLineID: 1,
+				},
+			},
+			qouteLock: sync.RWMutex{},
+		}
+		t.Run(test.name, func(t *testing.T) {
+			quote, err := quoteStorage.UpdateLineQuantity(test.customerId, test.lineId, test.newQuantity, 10.0)
+			if test.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.newQuantity, quote.Items[1].Quantity)
+			}
+		})
+	}
+}
+
+func TestQuoteStorageImpl_AddLine(t *testing.T) {
+	engraving := map[string]string{"engraving": "For Anna"}
+	tests := []struct {
+		name          string
+		productId     int32
+		variant       string
+		options       map[string]string
+		quantity      int32
+		expectedLine  int32
+		expectedQty   int32
+		expectedItems int
+	}{
+		{"Add plain product", 101, "", nil, 1, 1, 1, 1},
+		{"Add variant of the same product", 101, "XL", nil, 1, 2, 1, 2},
+		{"Add product with options", 101, "XL", engraving, 1, 3, 1, 3},
+		{"Merge matching variant", 101, "XL", nil, 2, 2, 3, 3},
+		{"Merge matching options", 101, "XL", map[string]string{"engraving": "For Anna"}, 1, 3, 2, 3},
+		{"Different option value", 101, "XL", map[string]string{"engraving": "For Bob"}, 1, 4, 1, 4},
+	}
+
+	quoteStorage := &QuoteStorage{
+		quotes:    make(map[int32]*Quote),
+		qouteLock: sync.RWMutex{},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quote, err := quoteStorage.AddLine(1, test.productId, test.variant, test.options, test.quantity, 10.0)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedItems, len(quote.Items))
+			item := quote.Items[test.expectedLine]
+			assert.Equal(t, test.productId, item.ProductID)
+			assert.Equal(t, test.variant, item.Variant)
+			assert.Equal(t, test.expectedQty, item.Quantity)
+		})
+	}
+
+	engraving["engraving"] = "changed"
+	item, exists := storedQuote(quoteStorage, 1).findItem(101, "XL", map[string]string{"engraving": "For Anna"})
+	assert.True(t, exists)
+	assert.Equal(t, int32(3), item.LineID)
+}
+
+func TestQuoteStorageImpl_RemoveProductRemovesAllLines(t *testing.T) {
+	quoteStorage := &QuoteStorage{
+		quotes:    make(map[int32]*Quote),
+		qouteLock: sync.RWMutex{},
+	}
+	quoteStorage.AddLine(1, 101, "S", nil, 1, 10.0)
+	quoteStorage.AddLine(1, 101, "M", nil, 1, 10.0)
+	quoteStorage.AddLine(1, 102, "", nil, 1, 10.0)
+
+	quote, err := quoteStorage.RemoveProduct(1, 101)
+	assert.NoError(t, err)
+	assert.Len(t, quote.Items, 1)
+	assert.Equal(t, int32(102), quote.Items[3].ProductID)
+}
+
+func TestQuoteServer_AddProductStoresPrice(t *testing.T) {
+	quoteServer, _ := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(12.5), nil)
+
+	quote, err := quoteServer.AddProduct(context.Background(), &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, float32(12.5), quote.Items[0].Price)
+}
+
+func TestQuoteServer_AddProductCatalogError(t *testing.T) {
+	catalogClient := NewMockCatalogClient()
+	catalogClient.On("GetProductInfo", uint64(101)).Return(nil, fmt.Errorf("catalog is down"))
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, catalogClient, nil)
+
+	_, err := quoteServer.AddProduct(context.Background(), &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 1})
+	assert.Error(t, err)
+	assert.Empty(t, storedQuote(quoteStorage, 1).Items)
+}
+
+func TestQuoteServer_Limits(t *testing.T) {
+	tests := []struct {
+		name        string
+		update      bool
+		productId   int32
+		quantity    int32
+		expectError bool
+	}{
+		{"Add within limits", false, 101, 2, false},
+		{"Add exceeds line quantity", false, 101, 4, true},
+		{"Add exceeds number of lines", false, 103, 1, true},
+		{"Update within limits", true, 101, 5, false},
+		{"Update exceeds line quantity", true, 101, 6, true},
+		{"Update adds line over the limit", true, 103, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{MaxLines: 2, MaxLineQuantity: 5}, newPriceCatalogClient(10.0), nil)
+			quoteStorage.AddLine(1, 101, "", nil, 2, 10.0)
+			quoteStorage.AddLine(1, 102, "", nil, 1, 10.0)
+
+			req := &pb.ProductRequest{CustomerId: 1, ProductId: test.productId, Quantity: test.quantity}
+			var err error
+			if test.update {
+				_, err = quoteServer.UpdateQuantity(context.Background(), req)
+			} else {
+				_, err = quoteServer.AddProduct(context.Background(), req)
+			}
+			if test.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestQuoteServer_InvalidItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		update    bool
+		productId int32
+		quantity  int32
+		wantField string
+	}{
+		{"Add zero quantity", false, 101, 0, "quantity"},
+		{"Add negative quantity", false, 101, -2, "quantity"},
+		{"Add invalid product", false, 0, 1, "product_id"},
+		{"Update zero quantity", true, 101, 0, "quantity"},
+		{"Update invalid product", true, -1, 1, "product_id"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			catalogClient := NewMockCatalogClient()
+			quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, catalogClient, nil)
+			quoteStorage.AddLine(1, 101, "", nil, 2, 10.0)
+
+			req := &pb.ProductRequest{CustomerId: 1, ProductId: test.productId, Quantity: test.quantity}
+			var err error
+			if test.update {
+				_, err = quoteServer.UpdateQuantity(context.Background(), req)
+			} else {
+				_, err = quoteServer.AddProduct(context.Background(), req)
+			}
+			var domainErr *Error
+			if assert.ErrorAs(t, err, &domainErr) {
+				assert.Equal(t, KindInvalidArgument, domainErr.Kind)
+				assert.Equal(t, test.wantField, domainErr.Field)
+			}
+			assert.Equal(t, int32(2), storedQuote(quoteStorage, 1).Items[1].Quantity)
+			catalogClient.AssertNotCalled(t, "GetProductInfo", mock.Anything)
+		})
+	}
+}
+
+func TestQuoteStorageImpl_ReturnsCopies(t *testing.T) {
+	quoteStorage := &QuoteStorage{quotes: make(map[int32]*Quote)}
+	added, err := quoteStorage.AddLine(1, 101, "red", map[string]string{"size": "M"}, 1, 10)
+	assert.NoError(t, err)
+	added.Items[1].Quantity = 99
+	added.Items[1].Options["size"] = "XL"
+
+	quote := storedQuote(quoteStorage, 1)
+	assert.Equal(t, int32(1), quote.Items[1].Quantity)
+	assert.Equal(t, "M", quote.Items[1].Options["size"])
+	delete(quote.Items, 1)
+
+	updated, err := quoteStorage.UpdateLineQuantity(1, 1, 2, 10)
+	assert.NoError(t, err)
+	assert.Len(t, updated.Items, 1)
+	updated.Items[1].Price = 0
+	assert.Equal(t, float32(10), storedQuote(quoteStorage, 1).Items[1].Price)
+}
+
+// slowCatalog answers after a delay, so concurrent requests overlap between the price
+// lookup and the change of the quote.
+type slowCatalog struct {
+	fixedPriceCatalog
+	delay time.Duration
+}
+
+func (c slowCatalog) GetProductInfo(ctx context.Context, id uint64) (*pbc.Product, error) {
+	time.Sleep(c.delay)
+	return c.fixedPriceCatalog.GetProductInfo(ctx, id)
+}
+
+// TestQuoteServer_ConcurrentUpdates is meant for the race detector, customers share
+// their quotes between goroutines and spread over stripes. The quotes never have more
+// lines than allowed, however the requests interleave.
+func TestQuoteServer_ConcurrentUpdates(t *testing.T) {
+	tests := []struct {
+		name       string
+		catalog    CatalogClientInterface
+		maxLines   int
+		products   int
+		iterations int
+	}{
+		{"Fast catalog", fixedPriceCatalog{10}, 5, 3, 200},
+		{"Line limit with a slow catalog", slowCatalog{fixedPriceCatalog{10}, time.Millisecond}, 2, 6, 40},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{MaxLines: test.maxLines, MaxLineQuantity: 1000}, test.catalog, nil)
+			ctx := context.Background()
+			var wg sync.WaitGroup
+			for worker := 0; worker < 8; worker++ {
+				wg.Add(1)
+				go func(worker int) {
+					defer wg.Done()
+					for i := 0; i < test.iterations; i++ {
+						customerId := int32(i%4 + 1)
+						productId := int32((worker+i)%test.products + 1)
+						request := &pb.ProductRequest{CustomerId: customerId, ProductId: productId, Quantity: 1}
+						var quote *pb.Quote
+						var err error
+						switch i % 5 {
+						case 0, 1:
+							quote, err = quoteServer.AddProduct(ctx, request)
+						case 2:
+							quote, err = quoteServer.UpdateQuantity(ctx, request)
+						case 3:
+							quote, err = quoteServer.RemoveProduct(ctx, request)
+						case 4:
+							quote, err = quoteServer.GetQuote(ctx, &pb.CustomerId{Id: customerId})
+							if assert.NoError(t, err) {
+								assert.Equal(t, customerId, quote.CustomerId)
+							}
+						}
+						if err == nil {
+							assert.LessOrEqual(t, len(quote.Items), test.maxLines)
+						}
+					}
+				}(worker)
+			}
+			wg.Wait()
+
+			for customerId := int32(1); customerId <= 4; customerId++ {
+				quote := storedQuote(quoteStorage, customerId)
+				assert.LessOrEqual(t, len(quote.Items), min(test.maxLines, test.products))
+			}
+		})
+	}
+}
+
+// nextCustomer gives every goroutine of a parallel benchmark a customer of its own.
+func nextCustomer(counter *atomic.Int32) int32 {
+	return counter.Add(1)
+}
+
+func BenchmarkQuoteServer_AddProductParallel(b *testing.B) {
+	quoteServer, _ := NewQuoteServer(QuoteConfig{}, fixedPriceCatalog{10}, nil)
+	var customers atomic.Int32
+	b.RunParallel(func(p *testing.PB) {
+		customerId := nextCustomer(&customers)
+		request := &pb.ProductRequest{CustomerId: customerId, ProductId: 1, Quantity: 1}
+		for p.Next() {
+			if _, err := quoteServer.AddProduct(context.Background(), request); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// BenchmarkQuoteServer_AddProductDuringCheckouts measures quote updates while other
+// customers are checking out, with a global lock they would wait for the checkouts.
+func BenchmarkQuoteServer_AddProductDuringCheckouts(b *testing.B) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, fixedPriceCatalog{10}, nil)
+	orderServer := NewOrderServer(OrderConfig{StepDelay: time.Millisecond}, quoteStorage, fixedPriceCatalog{10}, nil)
+	ctx, cancel := context.WithCancel(quietContext(context.Background()))
+	var checkouts sync.WaitGroup
+	for customerId := int32(-1); customerId >= -8; customerId-- {
+		checkouts.Add(1)
+		go func(customerId int32) {
+			defer checkouts.Done()
+			for ctx.Err() == nil {
+				quoteStorage.AddLine(customerId, 1, "", nil, 1, 10)
+				_ = orderServer.PlaceOrder(&pb.CustomerId{Id: customerId}, &discardStream{ctx: ctx})
+			}
+		}(customerId)
+	}
+	defer func() {
+		cancel()
+		checkouts.Wait()
+	}()
+
+	var customers atomic.Int32
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		customerId := nextCustomer(&customers)
+		request := &pb.ProductRequest{CustomerId: customerId, ProductId: 1, Quantity: 1}
+		for p.Next() {
+			if _, err := quoteServer.AddProduct(context.Background(), request); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
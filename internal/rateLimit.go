@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// rateLimitSweepInterval is how often buckets that have filled up again are dropped.
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket holds up to Burst tokens and gains Rate tokens per second, a call takes one.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+}
+
+// wait is how long until the bucket has a token, zero when it has one.
+func (b *tokenBucket) wait(limit RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+type bucketKey struct {
+	method string
+	caller string
+}
+
+// RateLimiter throttles the calls of every principal and every client IP with a token
+// bucket per method. Health probes are not limited.
+type RateLimiter struct {
+	config  RateLimitConfig
+	buckets map[bucketKey]*tokenBucket
+	swept   time.Time
+	now     func() time.Time
+	lock    sync.Mutex
+}
+
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:  config,
+		buckets: make(map[bucketKey]*tokenBucket),
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+func (l *RateLimiter) limit(fullMethod string) RateLimit {
+	if limit, exists := l.config.Methods[fullMethod]; exists {
+		return limit
+	}
+	return l.config.Default
+}
+
+// callers are the keys a call counts against: the customer or subject of the principal
+// and the client IP.
+func callers(ctx context.Context, clientIP string) []string {
+	var keys []string
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		if principal.HasRole(RoleCustomer) {
+			keys = append(keys, fmt.Sprintf("customer:%d", principal.CustomerId))
+		} else {
+			keys = append(keys, "subject:"+principal.Subject)
+		}
+	}
+	if clientIP != "" {
+		keys = append(keys, "ip:"+clientIP)
+	}
+	return keys
+}
+
+// allow takes a token from the bucket of every caller of the call. When one of them is
+// empty nothing is taken and the error tells when to retry.
+func (l *RateLimiter) allow(ctx context.Context, fullMethod string, clientIP string) error {
+	if publicMethod(fullMethod) {
+		return nil
+	}
+	limit := l.limit(fullMethod)
+	now := l.now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sweep(now)
+
+	keys := callers(ctx, clientIP)
+	buckets := make([]*tokenBucket, 0, len(keys))
+	var retryAfter time.Duration
+	var limited string
+	for _, caller := range keys {
+		key := bucketKey{method: fullMethod, caller: caller}
+		bucket, exists := l.buckets[key]
+		if !exists {
+			bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+			l.buckets[key] = bucket
+		}
+		bucket.refill(limit, now)
+		if wait := bucket.wait(limit); wait > retryAfter {
+			retryAfter = wait
+			limited = caller
+		}
+		buckets = append(buckets, bucket)
+	}
+	if retryAfter > 0 {
+		loggerFromContext(ctx).Warn("rate limited", "caller", limited, "retry_after", retryAfter)
+		return ResourceExhausted("RATE_LIMITED", retryAfter, "too many calls of %s, retry in %s", fullMethod, retryAfter.Round(time.Millisecond))
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return nil
+}
+
+// sweep drops the buckets that are full again, a new bucket would be the same.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateLimitSweepInterval {
+		return
+	}
+	l.swept = now
+	for key, bucket := range l.buckets {
+		limit := l.limit(key.method)
+		bucket.refill(limit, now)
+		if bucket.tokens >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// peerIP is the IP address of the client of the call, without the port.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func (l *RateLimiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := l.allow(ctx, info.FullMethod, peerIP(ctx)); err != nil {
+			_ = grpc.SetHeader(ctx, retryAfterMetadata(err))
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (l *RateLimiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.allow(ss.Context(), info.FullMethod, peerIP(ss.Context())); err != nil {
+			_ = ss.SetHeader(retryAfterMetadata(err))
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// newTestRateLimiter allows 2 calls at once and 1 call per second, AddProduct has a
+// burst of 1. The clock only moves when the test advances it.
+func newTestRateLimiter() (*RateLimiter, *time.Time) {
+	limiter := NewRateLimiter(RateLimitConfig{
+		Enabled: true,
+		Default: RateLimit{Rate: 1, Burst: 2},
+		Methods: map[string]RateLimit{"/sale.QuoteService/AddProduct": {Rate: 1, Burst: 1}},
+	})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	limiter.swept = now
+	return limiter, &now
+}
+
+func retryDelay(t *testing.T, err error) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+	t.Fatalf("no retry info in %v", err)
+	return 0
+}
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	limiter, now := newTestRateLimiter()
+	ctx := principalContext(1, RoleCustomer)
+
+	assert.NoError(t, limiter.allow(ctx, "/sale.QuoteService/GetQuote", "10.0.0.1"))
+	assert.NoError(t, limiter.allow(ctx, "/sale.QuoteService/GetQuote", "10.0.0.1"))
+	err := limiter.allow(ctx, "/sale.QuoteService/GetQuote", "10.0.0.1")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, "RATE_LIMITED", errorInfo(t, err).Reason)
+	assert.Equal(t, time.Second, retryDelay(t, err))
+
+	*now = now.Add(500 * time.Millisecond)
+	err = limiter.allow(ctx, "/sale.QuoteService/GetQuote", "10.0.0.1")
+	assert.Equal(t, 500*time.Millisecond, retryDelay(t, err))
+
+	*now = now.Add(500 * time.Millisecond)
+	assert.NoError(t, limiter.allow(ctx, "/sale.QuoteService/GetQuote", "10.0.0.1"))
+}
+
+func TestRateLimiter_Keys(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   context.Context
+		firstIP string
+		second  context.Context
+		secIP   string
+		limited bool
+	}{
+		{"Same customer from another IP", principalContext(1, RoleCustomer), "10.0.0.1", principalContext(1, RoleCustomer), "10.0.0.2", true},
+		{"Other customer from the same IP", principalContext(1, RoleCustomer), "10.0.0.1", principalContext(2, RoleCustomer), "10.0.0.1", true},
+		{"Other customer from another IP", principalContext(1, RoleCustomer), "10.0.0.1", principalContext(2, RoleCustomer), "10.0.0.2", false},
+		{"Anonymous from another IP", context.Background(), "10.0.0.1", context.Background(), "10.0.0.2", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter, _ := newTestRateLimiter()
+			assert.NoError(t, limiter.allow(test.first, "/sale.QuoteService/AddProduct", test.firstIP))
+			err := limiter.allow(test.second, "/sale.QuoteService/AddProduct", test.secIP)
+			assert.Equal(t, test.limited, err != nil)
+		})
+	}
+}
+
+func TestRateLimiter_LimitedCallTakesNoToken(t *testing.T) {
+	limiter, _ := newTestRateLimiter()
+
+	assert.NoError(t, limiter.allow(principalContext(1, RoleCustomer), "/sale.QuoteService/AddProduct", "10.0.0.1"))
+	// Customer 2 is limited by the IP, its own bucket must stay full.
+	assert.Error(t, limiter.allow(principalContext(2, RoleCustomer), "/sale.QuoteService/AddProduct", "10.0.0.1"))
+	assert.NoError(t, limiter.allow(principalContext(2, RoleCustomer), "/sale.QuoteService/AddProduct", "10.0.0.2"))
+}
+
+func TestRateLimiter_PerMethodLimits(t *testing.T) {
+	limiter, _ := newTestRateLimiter()
+	ctx := principalContext(1, RoleCustomer)
+
+	assert.NoError(t, limiter.allow(ctx, "/sale.QuoteService/AddProduct", ""))
+	assert.Error(t, limiter.allow(ctx, "/sale.QuoteService/AddProduct", ""))
+	assert.NoError(t, limiter.allow(ctx, "/sale.QuoteService/GetQuote", ""))
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, limiter.allow(context.Background(), "/grpc.health.v1.Health/Check", "10.0.0.1"))
+	}
+}
+
+func TestRateLimiter_Sweep(t *testing.T) {
+	limiter, now := newTestRateLimiter()
+	assert.NoError(t, limiter.allow(principalContext(1, RoleCustomer), "/sale.QuoteService/GetQuote", "10.0.0.1"))
+	assert.Len(t, limiter.buckets, 2)
+
+	*now = now.Add(rateLimitSweepInterval)
+	assert.NoError(t, limiter.allow(principalContext(2, RoleCustomer), "/sale.QuoteService/GetQuote", "10.0.0.2"))
+	assert.Len(t, limiter.buckets, 2)
+	assert.NotContains(t, limiter.buckets, bucketKey{method: "/sale.QuoteService/GetQuote", caller: "customer:1"})
+}
+
+// headerStream records the headers set by unary interceptors.
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestRateLimiter_UnaryServerInterceptor(t *testing.T) {
+	limiter, _ := newTestRateLimiter()
+	interceptor := limiter.UnaryServerInterceptor()
+	transport := &headerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(principalContext(1, RoleCustomer), transport)
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4242}})
+	info := &grpc.UnaryServerInfo{FullMethod: "/sale.QuoteService/AddProduct"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	resp, err := interceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+	assert.Contains(t, limiter.buckets, bucketKey{method: info.FullMethod, caller: "ip:10.0.0.1"})
+
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1"}, transport.header.Get("retry-after"))
+}
+
+func TestRateLimiter_StreamServerInterceptor(t *testing.T) {
+	limiter, _ := newTestRateLimiter()
+	interceptor := limiter.StreamServerInterceptor()
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(principalContext(1, RoleCustomer))
+	stream.On("SetHeader", mock.Anything).Return(nil)
+	info := &grpc.StreamServerInfo{FullMethod: "/sale.OrderService/PlaceOrder"}
+	calls := 0
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		calls++
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		_ = interceptor(nil, stream, info, handler)
+	}
+	assert.Equal(t, 2, calls)
+	stream.AssertCalled(t, "SetHeader", metadata.Pairs("retry-after", "1"))
+}
+
+func TestGateway_RateLimited(t *testing.T) {
+	limiter, _ := newTestRateLimiter()
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, newPriceCatalogClient(10.0), nil)
+	gateway := NewGateway(DefaultConfig().Gateway, quoteServer, orderServer, NewReturnServer(orderServer), nil, nil, limiter, nil)
+	server := httptest.NewServer(gateway)
+	t.Cleanup(server.Close)
+
+	body := `{"product_id": 101, "quantity": 1}`
+	resp := doJSON(t, http.MethodPost, server.URL+"/v1/customers/1/quote/items", "", body, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var errBody errorResponse
+	resp = doJSON(t, http.MethodPost, server.URL+"/v1/customers/1/quote/items", "", body, &errBody)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "RATE_LIMITED", errBody.Error.Reason)
+
+	// The return routes count against buckets of their own, not the ones of GetOrder.
+	for i := 0; i < 2; i++ {
+		resp = doJSON(t, http.MethodGet, server.URL+"/v1/orders/1", "", "", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	resp = doJSON(t, http.MethodGet, server.URL+"/v1/orders/1", "", "", nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp = doJSON(t, http.MethodGet, server.URL+"/v1/returns/1", "", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
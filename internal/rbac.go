@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+	RoleService  = "service"
+)
+
+var knownRoles = map[string]bool{
+	RoleCustomer: true,
+	RoleSupport:  true,
+	RoleAdmin:    true,
+	RoleService:  true,
+}
+
+// staffRoles are the people running the shop, only they decide on returns.
+var staffRoles = []string{RoleSupport, RoleAdmin}
+
+// anyCustomerRoles may access the data of every customer, staff and other services.
+var anyCustomerRoles = append([]string{RoleService}, staffRoles...)
+
+// DefaultPolicies lists the roles allowed to call each RPC. The gateway only routes
+// GetOrderEvents and the ReturnService, they have no gRPC methods.
+func DefaultPolicies() map[string][]string {
+	return map[string][]string{
+		"/sale.QuoteService/GetQuote":         {RoleCustomer, RoleSupport, RoleAdmin},
+		"/sale.QuoteService/AddProduct":       {RoleCustomer, RoleSupport, RoleAdmin},
+		"/sale.QuoteService/RemoveProduct":    {RoleCustomer, RoleSupport, RoleAdmin},
+		"/sale.QuoteService/UpdateQuantity":   {RoleCustomer, RoleSupport, RoleAdmin},
+		"/sale.OrderService/GetOrders":        {RoleCustomer, RoleSupport, RoleAdmin, RoleService},
+		"/sale.OrderService/GetOrder":         {RoleCustomer, RoleSupport, RoleAdmin, RoleService},
+		"/sale.OrderService/GetOrderEvents":   {RoleCustomer, RoleSupport, RoleAdmin, RoleService},
+		"/sale.OrderService/PlaceOrder":       {RoleCustomer, RoleAdmin},
+		"/sale.ReturnService/GetOrderReturns": {RoleCustomer, RoleSupport, RoleAdmin, RoleService},
+		"/sale.ReturnService/GetReturn":       {RoleCustomer, RoleSupport, RoleAdmin, RoleService},
+		"/sale.ReturnService/RequestReturn":   {RoleCustomer, RoleSupport, RoleAdmin},
+		"/sale.ReturnService/ApproveReturn":   {RoleSupport, RoleAdmin},
+		"/sale.ReturnService/RejectReturn":    {RoleSupport, RoleAdmin},
+		"/sale.ReturnService/ReceiveReturn":   {RoleSupport, RoleAdmin},
+		"/sale.ReturnService/RefundReturn":    {RoleSupport, RoleAdmin},
+		// Server reflection, when enabled, is meant for debugging by staff.
+		"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":      {RoleSupport, RoleAdmin},
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": {RoleSupport, RoleAdmin},
+	}
+}
+
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, own := range p.Roles {
+			if own == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorizeStaff makes sure the caller is staff. Without a principal in the context
+// authentication is disabled and every call is allowed.
+func authorizeStaff(ctx context.Context) error {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.HasRole(staffRoles...) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "%s is not staff", principal.Subject)
+}
+
+// Authorizer enforces the per-RPC role policies. Methods without a policy are denied,
+// the denials are logged and counted in the metrics.
+type Authorizer struct {
+	policies map[string]map[string]bool
+	metrics  *Metrics
+}
+
+func NewAuthorizer(policies map[string][]string, metrics *Metrics) (*Authorizer, error) {
+	a := &Authorizer{
+		policies: make(map[string]map[string]bool, len(policies)),
+		metrics:  metrics,
+	}
+	for method, roles := range policies {
+		allowed := make(map[string]bool, len(roles))
+		for _, role := range roles {
+			if !knownRoles[role] {
+				return nil, fmt.Errorf("unknown role %q in policy of %s", role, method)
+			}
+			allowed[role] = true
+		}
+		a.policies[method] = allowed
+	}
+	return a, nil
+}
+
+func (a *Authorizer) authorize(ctx context.Context, fullMethod string) error {
+	if publicMethod(fullMethod) {
+		return nil
+	}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing principal")
+	}
+	for _, role := range principal.Roles {
+		if a.policies[fullMethod][role] {
+			return nil
+		}
+	}
+	loggerFromContext(ctx).Warn("permission denied", "subject", principal.Subject, "roles", principal.Roles)
+	a.metrics.PermissionDenied(fullMethod)
+	return status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s", principal.Subject, fullMethod)
+}
+
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
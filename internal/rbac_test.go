@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+)
+
+func principalContext(customerId int32, roles ...string) context.Context {
+	return ContextWithPrincipal(context.Background(), &Principal{Subject: "user", CustomerId: customerId, Roles: roles})
+}
+
+func TestAuthorizer_Policies(t *testing.T) {
+	authorizer, err := NewAuthorizer(DefaultPolicies(), nil)
+	require.NoError(t, err)
+	interceptor := authorizer.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		expected codes.Code
+	}{
+		{"Customer reads quote", principalContext(1, RoleCustomer), "/sale.QuoteService/GetQuote", codes.OK},
+		{"Service reads orders", principalContext(0, RoleService), "/sale.OrderService/GetOrders", codes.OK},
+		{"Service cannot edit quotes", principalContext(0, RoleService), "/sale.QuoteService/AddProduct", codes.PermissionDenied},
+		{"Support cannot place orders", principalContext(0, RoleSupport), "/sale.OrderService/PlaceOrder", codes.PermissionDenied},
+		{"Any of the roles is enough", principalContext(0, RoleSupport, RoleAdmin), "/sale.OrderService/PlaceOrder", codes.OK},
+		{"Customer requests a return", principalContext(1, RoleCustomer), "/sale.ReturnService/RequestReturn", codes.OK},
+		{"Customer cannot approve returns", principalContext(1, RoleCustomer), "/sale.ReturnService/ApproveReturn", codes.PermissionDenied},
+		{"Service cannot refund returns", principalContext(0, RoleService), "/sale.ReturnService/RefundReturn", codes.PermissionDenied},
+		{"Support refunds returns", principalContext(0, RoleSupport), "/sale.ReturnService/RefundReturn", codes.OK},
+		{"Method without policy", principalContext(0, RoleAdmin), "/sale.QuoteService/Unknown", codes.PermissionDenied},
+		{"Without principal", context.Background(), "/sale.QuoteService/GetQuote", codes.Unauthenticated},
+		{"Health probe", context.Background(), "/grpc.health.v1.Health/Check", codes.OK},
+		{"Support uses reflection", principalContext(0, RoleSupport), "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", codes.OK},
+		{"Customer cannot use reflection", principalContext(1, RoleCustomer), "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", codes.PermissionDenied},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := interceptor(test.ctx, nil, &grpc.UnaryServerInfo{FullMethod: test.method}, handler)
+			assert.Equal(t, test.expected, status.Code(err))
+		})
+	}
+}
+
+func TestAuthorizer_StreamServerInterceptor(t *testing.T) {
+	authorizer, err := NewAuthorizer(DefaultPolicies(), nil)
+	require.NoError(t, err)
+
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(principalContext(0, RoleService))
+	called := false
+	err = authorizer.StreamServerInterceptor()(nil, stream, &grpc.StreamServerInfo{FullMethod: "/sale.OrderService/PlaceOrder"}, func(srv interface{}, ss grpc.ServerStream) error {
+		called = true
+		return nil
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.False(t, called)
+}
+
+func TestAuthorizer_CountsDenials(t *testing.T) {
+	metrics := NewMetrics()
+	authorizer, err := NewAuthorizer(map[string][]string{"/sale.QuoteService/GetQuote": {RoleCustomer}}, metrics)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.Error(t, authorizer.authorize(principalContext(0, RoleService), "/sale.QuoteService/GetQuote"))
+	}
+	assert.NoError(t, authorizer.authorize(principalContext(1, RoleCustomer), "/sale.QuoteService/GetQuote"))
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.permissionDenied.WithLabelValues("/sale.QuoteService/GetQuote")))
+}
+
+func TestNewAuthorizer_UnknownRole(t *testing.T) {
+	_, err := NewAuthorizer(map[string][]string{"/sale.QuoteService/GetQuote": {"guest"}}, nil)
+	assert.Error(t, err)
+}
+
+func TestAuthorizeCustomer_Staff(t *testing.T) {
+	quoteServer, _ := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+
+	_, err := quoteServer.GetQuote(principalContext(0, RoleSupport), &pb.CustomerId{Id: 2})
+	assert.NoError(t, err)
+	_, err = quoteServer.GetQuote(principalContext(2, RoleService, RoleCustomer), &pb.CustomerId{Id: 3})
+	assert.NoError(t, err)
+	_, err = quoteServer.GetQuote(principalContext(2), &pb.CustomerId{Id: 2})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+)
+
+// recoverPanic turns a panic of the handler into an Internal error in err. It must be
+// deferred directly, the stack is logged since the caller only sees a generic message.
+func recoverPanic(ctx context.Context, fullMethod string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	loggerFromContext(ctx).Error("handler panicked", "method", fullMethod, "panic", r, "stack", string(debug.Stack()))
+	*err = Internal("PANIC", "internal error")
+}
+
+// RecoveryUnaryServerInterceptor keeps a panicking handler from crashing the server,
+// the call fails with codes.Internal instead.
+func RecoveryUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer recoverPanic(ctx, info.FullMethod, &err)
+		return handler(ctx, req)
+	}
+}
+
+// RecoveryStreamServerInterceptor is RecoveryUnaryServerInterceptor for streams.
+func RecoveryStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer recoverPanic(ss.Context(), info.FullMethod, &err)
+		return handler(srv, ss)
+	}
+}
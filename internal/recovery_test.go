@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func recoveryLogger(t *testing.T) (context.Context, *bytes.Buffer) {
+	var out bytes.Buffer
+	logger, err := NewLogger(LoggingConfig{Format: "json", Level: "info"}, &out)
+	require.NoError(t, err)
+	return contextWithLogger(context.Background(), logger), &out
+}
+
+func TestRecoveryUnaryServerInterceptor(t *testing.T) {
+	ctx, out := recoveryLogger(t)
+	interceptor := RecoveryUnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/sale.OrderService/GetOrder"}
+
+	resp, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		var order *Order
+		return order.ID, nil
+	})
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "internal error", status.Convert(err).Message())
+	assert.Equal(t, "PANIC", errorInfo(t, err).Reason)
+
+	lines := logLines(t, out)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "ERROR", lines[0]["level"])
+	assert.Equal(t, "/sale.OrderService/GetOrder", lines[0]["method"])
+	assert.Contains(t, lines[0]["panic"], "nil pointer dereference")
+	assert.Contains(t, lines[0]["stack"], "TestRecoveryUnaryServerInterceptor")
+
+	resp, err = interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
+
+func TestRecoveryStreamServerInterceptor(t *testing.T) {
+	ctx, out := recoveryLogger(t)
+	interceptor := RecoveryStreamServerInterceptor()
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Context").Return(ctx)
+
+	err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/sale.OrderService/PlaceOrder"}, func(srv interface{}, ss grpc.ServerStream) error {
+		panic("checkout broke")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	lines := logLines(t, out)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "checkout broke", lines[0]["panic"])
+}
+
+func TestGateway_RecoversPanics(t *testing.T) {
+	quoteServer, quoteStorage := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, nil, nil)
+	gateway := NewGateway(DefaultConfig().Gateway, quoteServer, orderServer, NewReturnServer(orderServer), nil, nil, nil, nil)
+	route := route{"GET /v1/panic", "/sale.OrderService/GetOrder", false, func(w http.ResponseWriter, r *http.Request) error {
+		panic("gateway broke")
+	}}
+
+	recorder := httptest.NewRecorder()
+	gateway.wrap(route).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "PANIC")
+	assert.NotContains(t, recorder.Body.String(), "gateway broke")
+}
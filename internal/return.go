@@ -0,0 +1,312 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+)
+
+type ReturnStatus int32
+
+const (
+	ReturnRequested ReturnStatus = iota
+	ReturnApproved
+	ReturnRejected
+	ReturnReceived
+	ReturnRefunded
+)
+
+func (s ReturnStatus) String() string {
+	switch s {
+	case ReturnRequested:
+		return "REQUESTED"
+	case ReturnApproved:
+		return "APPROVED"
+	case ReturnRejected:
+		return "REJECTED"
+	case ReturnReceived:
+		return "RECEIVED"
+	case ReturnRefunded:
+		return "REFUNDED"
+	}
+	return fmt.Sprintf("ReturnStatus(%d)", int32(s))
+}
+
+// parseReturnStatus is the inverse of String, unknown names are REQUESTED.
+func parseReturnStatus(name string) ReturnStatus {
+	for status := ReturnRequested; status <= ReturnRefunded; status++ {
+		if status.String() == name {
+			return status
+		}
+	}
+	return ReturnRequested
+}
+
+type ReturnReason int32
+
+const (
+	ReasonOther ReturnReason = iota
+	ReasonDamaged
+	ReasonWrongItem
+	ReasonNotAsDescribed
+	ReasonNoLongerNeeded
+)
+
+func (r ReturnReason) String() string {
+	switch r {
+	case ReasonOther:
+		return "OTHER"
+	case ReasonDamaged:
+		return "DAMAGED"
+	case ReasonWrongItem:
+		return "WRONG_ITEM"
+	case ReasonNotAsDescribed:
+		return "NOT_AS_DESCRIBED"
+	case ReasonNoLongerNeeded:
+		return "NO_LONGER_NEEDED"
+	}
+	return fmt.Sprintf("ReturnReason(%d)", int32(r))
+}
+
+type ReturnItem struct {
+	LineID    int32
+	ProductID int32
+	Quantity  int32
+	Price     float32
+}
+
+type ReturnEvent struct {
+	Status ReturnStatus
+	Note   string
+	At     time.Time
+}
+
+type Return struct {
+	ID           int32
+	OrderID      int32
+	CustomerId   int32
+	Items        map[int32]*ReturnItem
+	Reason       ReturnReason
+	Status       ReturnStatus
+	RefundAmount float32
+	History      []ReturnEvent
+}
+
+// Total is the value of the returned items, the amount refunded for them.
+func (r *Return) Total() float32 {
+	var total float32
+	for _, item := range r.Items {
+		total += item.Price * float32(item.Quantity)
+	}
+	return total
+}
+
+func returnLineIDs(items map[int32]*ReturnItem) []int32 {
+	ids := make([]int32, 0, len(items))
+	for lineId := range items {
+		ids = append(ids, lineId)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// ReturnServer keeps return merchandise authorisations (RMA) for delivered orders.
+// It has no gRPC binding yet, the sale protos have to be extended first.
+type ReturnServer struct {
+	returns      map[int32]*Return
+	orderReturns map[int32][]int32
+	returnLock   sync.RWMutex
+	orderServer  *OrderServer
+	now          func() time.Time
+	// events records the returns and their status changes, nil without an event log.
+	events EventLog
+	// lastReturnID is the ID of the latest return requested, guarded by returnLock.
+	lastReturnID int32
+}
+
+func NewReturnServer(orderServer *OrderServer) *ReturnServer {
+	return &ReturnServer{
+		returns:      make(map[int32]*Return),
+		orderReturns: make(map[int32][]int32),
+		returnLock:   sync.RWMutex{},
+		orderServer:  orderServer,
+		now:          time.Now,
+	}
+}
+
+// allowedTransitions lists the statuses a return may move to from the given one.
+var allowedTransitions = map[ReturnStatus][]ReturnStatus{
+	ReturnRequested: {ReturnApproved, ReturnRejected},
+	ReturnApproved:  {ReturnReceived},
+	ReturnReceived:  {ReturnRefunded},
+}
+
+func canTransition(from ReturnStatus, to ReturnStatus) bool {
+	for _, status := range allowedTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestReturn opens a return for the given quantities of the order lines, keyed by line ID.
+func (s *ReturnServer) RequestReturn(ctx context.Context, orderId int32, items map[int32]int32, reason ReturnReason, note string) (*Return, error) {
+	if len(items) == 0 {
+		return nil, InvalidArgument("items", "RETURN_EMPTY", "return has no items")
+	}
+
+	order, err := s.orderServer.getOrder(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != pb.OrderStatus_COMPLETED {
+		return nil, FailedPrecondition("ORDER_NOT_DELIVERED", "order with id %d is not delivered", orderId)
+	}
+
+	s.returnLock.Lock()
+	defer s.returnLock.Unlock()
+
+	returned := s.returnedQuantitiesUnsafe(orderId)
+	returnItems := make(map[int32]*ReturnItem, len(items))
+	for lineId, quantity := range items {
+		if quantity <= 0 {
+			return nil, InvalidArgument("items", "INVALID_QUANTITY", "invalid quantity %d for line %d", quantity, lineId)
+		}
+		orderItem, exists := order.Items[lineId]
+		if !exists {
+			return nil, InvalidArgument("items", "UNKNOWN_LINE", "line %d is not part of order %d", lineId, orderId)
+		}
+		if returned[lineId]+quantity > orderItem.Quantity {
+			return nil, InvalidArgument("items", "QUANTITY_EXCEEDS_ORDER", "cannot return %d of line %d, only %d left to return", quantity, lineId, orderItem.Quantity-returned[lineId])
+		}
+		returnItems[lineId] = &ReturnItem{
+			LineID:    lineId,
+			ProductID: orderItem.ProductID,
+			Quantity:  quantity,
+			Price:     orderItem.Price,
+		}
+	}
+
+	// The ID is skipped when the event is not recorded, like the order IDs.
+	s.lastReturnID++
+	event := returnRequested(&Return{
+		ID:         s.lastReturnID,
+		OrderID:    orderId,
+		CustomerId: order.CustomerId,
+		Items:      returnItems,
+		Reason:     reason,
+	}, note, s.now())
+	if err := s.record(event); err != nil {
+		return nil, err
+	}
+	return copyReturn(s.returns[event.ReturnId]), nil
+}
+
+// record appends the event to the event log and applies it, the caller holds returnLock.
+// Nothing changes when the log fails.
+func (s *ReturnServer) record(event *Event) error {
+	if s.events != nil {
+		if err := s.events.Append(event); err != nil {
+			return eventLogError(err)
+		}
+	}
+	applyReturnEvent(s.returns, s.orderReturns, event)
+	return nil
+}
+
+// returnedQuantitiesUnsafe sums the quantities per order line that are already covered
+// by non-rejected returns of the order. The caller must hold returnLock.
+func (s *ReturnServer) returnedQuantitiesUnsafe(orderId int32) map[int32]int32 {
+	returned := make(map[int32]int32)
+	for _, id := range s.orderReturns[orderId] {
+		ret := s.returns[id]
+		if ret.Status == ReturnRejected {
+			continue
+		}
+		for _, item := range ret.Items {
+			returned[item.LineID] += item.Quantity
+		}
+	}
+	return returned
+}
+
+func (s *ReturnServer) ApproveReturn(ctx context.Context, returnId int32, note string) (*Return, error) {
+	return s.transition(ctx, returnId, ReturnApproved, note)
+}
+
+func (s *ReturnServer) RejectReturn(ctx context.Context, returnId int32, note string) (*Return, error) {
+	return s.transition(ctx, returnId, ReturnRejected, note)
+}
+
+func (s *ReturnServer) ReceiveReturn(ctx context.Context, returnId int32, note string) (*Return, error) {
+	return s.transition(ctx, returnId, ReturnReceived, note)
+}
+
+func (s *ReturnServer) RefundReturn(ctx context.Context, returnId int32, note string) (*Return, error) {
+	return s.transition(ctx, returnId, ReturnRefunded, note)
+}
+
+// transition moves the return to the given status, only staff may decide on returns.
+func (s *ReturnServer) transition(ctx context.Context, returnId int32, status ReturnStatus, note string) (*Return, error) {
+	if err := authorizeStaff(ctx); err != nil {
+		return nil, err
+	}
+
+	s.returnLock.Lock()
+	defer s.returnLock.Unlock()
+
+	ret, exists := s.returns[returnId]
+	if !exists {
+		return nil, NotFound("RETURN_NOT_FOUND", "return with id %d not found", returnId)
+	}
+	if !canTransition(ret.Status, status) {
+		return nil, FailedPrecondition("INVALID_RETURN_TRANSITION", "return with id %d cannot move from %s to %s", returnId, ret.Status, status)
+	}
+
+	if err := s.record(returnStatusChanged(ret, status, note, s.now())); err != nil {
+		return nil, err
+	}
+	return copyReturn(ret), nil
+}
+
+func (s *ReturnServer) GetReturn(ctx context.Context, returnId int32) (*Return, error) {
+	s.returnLock.RLock()
+	defer s.returnLock.RUnlock()
+
+	ret, exists := s.returns[returnId]
+	if !exists || authorizeCustomer(ctx, ret.CustomerId) != nil {
+		return nil, NotFound("RETURN_NOT_FOUND", "return with id %d not found", returnId)
+	}
+	return copyReturn(ret), nil
+}
+
+func (s *ReturnServer) GetOrderReturns(ctx context.Context, orderId int32) ([]*Return, error) {
+	if _, err := s.orderServer.getOrder(ctx, orderId); err != nil {
+		return nil, err
+	}
+
+	s.returnLock.RLock()
+	defer s.returnLock.RUnlock()
+
+	returns := make([]*Return, 0, len(s.orderReturns[orderId]))
+	for _, id := range s.orderReturns[orderId] {
+		returns = append(returns, copyReturn(s.returns[id]))
+	}
+	return returns, nil
+}
+
+func copyReturn(ret *Return) *Return {
+	c := *ret
+	c.Items = make(map[int32]*ReturnItem, len(ret.Items))
+	for lineId, item := range ret.Items {
+		itemCopy := *item
+		c.Items[lineId] = &itemCopy
+	}
+	c.History = append([]ReturnEvent(nil), ret.History...)
+	return &c
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestReturnServer(status pb.OrderStatus) *ReturnServer {
+	orderServer := &OrderServer{
+		orders: map[int32]map[int32]*Order{
+			1: {
+				1: {
+					ID:         1,
+					CustomerId: 1,
+					Status:     status,
+					Items: map[int32]*OrderItem{
+						1: {LineID: 1, ProductID: 101, Quantity: 2, Price: 10.0},
+						2: {LineID: 2, ProductID: 102, Quantity: 1, Price: 25.0},
+					},
+				},
+			},
+		},
+		customerOrderMap: map[int32]int32{1: 1},
+		orderLock:        sync.RWMutex{},
+	}
+	return NewReturnServer(orderServer)
+}
+
+func TestReturnServer_RequestReturn(t *testing.T) {
+	tests := []struct {
+		name        string
+		orderId     int32
+		status      pb.OrderStatus
+		items       map[int32]int32
+		expectError bool
+	}{
+		{"Return part of the order", 1, pb.OrderStatus_COMPLETED, map[int32]int32{1: 1}, false},
+		{"Return whole order", 1, pb.OrderStatus_COMPLETED, map[int32]int32{1: 2, 2: 1}, false},
+		{"Order not delivered", 1, pb.OrderStatus_STARTED, map[int32]int32{1: 1}, true},
+		{"Order not found", 2, pb.OrderStatus_COMPLETED, map[int32]int32{1: 1}, true},
+		{"No items", 1, pb.OrderStatus_COMPLETED, map[int32]int32{}, true},
+		{"Line not in order", 1, pb.OrderStatus_COMPLETED, map[int32]int32{3: 1}, true},
+		{"Quantity exceeds order", 1, pb.OrderStatus_COMPLETED, map[int32]int32{1: 3}, true},
+		{"Non-positive quantity", 1, pb.OrderStatus_COMPLETED, map[int32]int32{1: 0}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			returnServer := newTestReturnServer(test.status)
+			ret, err := returnServer.RequestReturn(context.Background(), test.orderId, test.items, ReasonDamaged, "broken")
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.orderId, ret.OrderID)
+			assert.Equal(t, int32(1), ret.CustomerId)
+			assert.Equal(t, ReturnRequested, ret.Status)
+			assert.Equal(t, ReasonDamaged, ret.Reason)
+			assert.Len(t, ret.Items, len(test.items))
+			assert.Len(t, ret.History, 1)
+		})
+	}
+}
+
+func TestReturnServer_RequestReturnRemainingQuantity(t *testing.T) {
+	returnServer := newTestReturnServer(pb.OrderStatus_COMPLETED)
+
+	first, err := returnServer.RequestReturn(context.Background(), 1, map[int32]int32{1: 2}, ReasonOther, "")
+	assert.NoError(t, err)
+
+	_, err = returnServer.RequestReturn(context.Background(), 1, map[int32]int32{1: 1}, ReasonOther, "")
+	assert.Error(t, err)
+
+	_, err = returnServer.RejectReturn(context.Background(), first.ID, "outside of return window")
+	assert.NoError(t, err)
+
+	_, err = returnServer.RequestReturn(context.Background(), 1, map[int32]int32{1: 1}, ReasonOther, "")
+	assert.NoError(t, err)
+	returns, err := returnServer.GetOrderReturns(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Len(t, returns, 2)
+}
+
+func TestReturnServer_Workflow(t *testing.T) {
+	returnServer := newTestReturnServer(pb.OrderStatus_COMPLETED)
+
+	ret, err := returnServer.RequestReturn(context.Background(), 1, map[int32]int32{1: 2, 2: 1}, ReasonWrongItem, "")
+	assert.NoError(t, err)
+
+	_, err = returnServer.RefundReturn(context.Background(), ret.ID, "")
+	assert.Error(t, err)
+
+	for _, step := range []func(context.Context, int32, string) (*Return, error){
+		returnServer.ApproveReturn,
+		returnServer.ReceiveReturn,
+		returnServer.RefundReturn,
+	} {
+		ret, err = step(context.Background(), ret.ID, "")
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, ReturnRefunded, ret.Status)
+	assert.Equal(t, float32(45.0), ret.RefundAmount)
+	assert.Equal(t, []ReturnStatus{ReturnRequested, ReturnApproved, ReturnReceived, ReturnRefunded},
+		[]ReturnStatus{ret.History[0].Status, ret.History[1].Status, ret.History[2].Status, ret.History[3].Status})
+
+	_, err = returnServer.RejectReturn(context.Background(), ret.ID, "")
+	assert.Error(t, err)
+}
+
+func TestReturnServer_GetReturn(t *testing.T) {
+	returnServer := newTestReturnServer(pb.OrderStatus_COMPLETED)
+	ret, err := returnServer.RequestReturn(context.Background(), 1, map[int32]int32{1: 1}, ReasonOther, "")
+	assert.NoError(t, err)
+
+	got, err := returnServer.GetReturn(context.Background(), ret.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, ret, got)
+
+	got.Items[1].Quantity = 100
+	stored, _ := returnServer.GetReturn(context.Background(), ret.ID)
+	assert.Equal(t, int32(1), stored.Items[1].Quantity)
+	assert.Equal(t, int32(101), stored.Items[1].ProductID)
+
+	_, err = returnServer.GetReturn(context.Background(), 100)
+	assert.Error(t, err)
+}
+
+func TestReturnServer_OnlyStaffDecides(t *testing.T) {
+	returnServer := newTestReturnServer(pb.OrderStatus_COMPLETED)
+	ret, err := returnServer.RequestReturn(principalContext(1, RoleCustomer), 1, map[int32]int32{1: 1}, ReasonOther, "")
+	require.NoError(t, err)
+
+	_, err = returnServer.ApproveReturn(principalContext(1, RoleCustomer), ret.ID, "")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = returnServer.ApproveReturn(principalContext(0, RoleService), ret.ID, "")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ret, err = returnServer.ApproveReturn(principalContext(0, RoleSupport), ret.ID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, ReturnApproved, ret.Status)
+}
@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type snapshotJSON struct {
+	Sequence int64            `json:"sequence"`
+	Time     time.Time        `json:"time"`
+	Quotes   []snapshotQuote  `json:"quotes"`
+	Orders   []snapshotOrder  `json:"orders"`
+	Returns  []snapshotReturn `json:"returns"`
+}
+
+type snapshotQuote struct {
+	CustomerId int32       `json:"customer_id"`
+	LastLineId int32       `json:"last_line_id"`
+	Items      []EventItem `json:"items"`
+}
+
+type snapshotOrder struct {
+	Id         int32       `json:"id"`
+	CustomerId int32       `json:"customer_id"`
+	Status     string      `json:"status"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Items      []EventItem `json:"items"`
+}
+
+type snapshotReturn struct {
+	Id           int32                 `json:"id"`
+	OrderId      int32                 `json:"order_id"`
+	CustomerId   int32                 `json:"customer_id"`
+	Reason       string                `json:"reason"`
+	Status       string                `json:"status"`
+	RefundAmount float32               `json:"refund_amount,omitempty"`
+	Items        []EventItem           `json:"items"`
+	History      []snapshotReturnEvent `json:"history"`
+}
+
+type snapshotReturnEvent struct {
+	Status string    `json:"status"`
+	Note   string    `json:"note,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// SnapshotStore keeps the latest snapshot of the event state in a file, so a restart
+// only replays the events recorded after it.
+type SnapshotStore struct {
+	path string
+}
+
+func NewSnapshotStore(path string) *SnapshotStore {
+	return &SnapshotStore{path: path}
+}
+
+// Load returns the state of the snapshot, an empty one when there is no snapshot yet.
+func (s *SnapshotStore) Load() (*EventState, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewEventState(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot: %v", err)
+	}
+	var snapshot snapshotJSON
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing snapshot %s: %v", s.path, err)
+	}
+
+	state := NewEventState()
+	state.Sequence = snapshot.Sequence
+	for _, q := range snapshot.Quotes {
+		quote := &Quote{CustomerId: q.CustomerId, Items: make(map[int32]*QuoteItem, len(q.Items)), lastLineID: q.LastLineId}
+		for _, item := range q.Items {
+			quote.Items[item.LineId] = &QuoteItem{
+				LineID:    item.LineId,
+				ProductID: item.ProductId,
+				Variant:   item.Variant,
+				Options:   item.Options,
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			}
+		}
+		state.Quotes[q.CustomerId] = quote
+	}
+	for _, o := range snapshot.Orders {
+		// The orders are restored the way they were placed, with their current status.
+		applyOrderEvent(state.Orders, state.OrderCustomers, &Event{
+			Type:       EventOrderPlaced,
+			Time:       o.CreatedAt,
+			CustomerId: o.CustomerId,
+			OrderId:    o.Id,
+			Items:      o.Items,
+			Status:     o.Status,
+		})
+	}
+	for _, r := range snapshot.Returns {
+		reason, _ := parseReturnReason(r.Reason)
+		ret := &Return{
+			ID:           r.Id,
+			OrderID:      r.OrderId,
+			CustomerId:   r.CustomerId,
+			Items:        make(map[int32]*ReturnItem, len(r.Items)),
+			Reason:       reason,
+			Status:       parseReturnStatus(r.Status),
+			RefundAmount: r.RefundAmount,
+			History:      make([]ReturnEvent, 0, len(r.History)),
+		}
+		for _, item := range r.Items {
+			ret.Items[item.LineId] = &ReturnItem{
+				LineID:    item.LineId,
+				ProductID: item.ProductId,
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			}
+		}
+		for _, event := range r.History {
+			ret.History = append(ret.History, ReturnEvent{Status: parseReturnStatus(event.Status), Note: event.Note, At: event.At})
+		}
+		// The returns are saved in the order of their IDs, so each order lists its returns
+		// in the order they were requested.
+		state.Returns[ret.ID] = ret
+		state.OrderReturns[ret.OrderID] = append(state.OrderReturns[ret.OrderID], ret.ID)
+	}
+	return state, nil
+}
+
+// Save replaces the snapshot with the state, a crash while saving keeps the old one.
+func (s *SnapshotStore) Save(state *EventState) error {
+	snapshot := snapshotJSON{
+		Sequence: state.Sequence,
+		Time:     time.Now(),
+		Quotes:   make([]snapshotQuote, 0, len(state.Quotes)),
+		Orders:   make([]snapshotOrder, 0, len(state.OrderCustomers)),
+		Returns:  make([]snapshotReturn, 0, len(state.Returns)),
+	}
+	for _, quote := range state.Quotes {
+		q := snapshotQuote{CustomerId: quote.CustomerId, LastLineId: quote.lastLineID, Items: make([]EventItem, 0, len(quote.Items))}
+		for _, lineId := range lineIDs(quote.Items) {
+			item := quote.Items[lineId]
+			q.Items = append(q.Items, EventItem{
+				LineId:    lineId,
+				ProductId: item.ProductID,
+				Variant:   item.Variant,
+				Options:   item.Options,
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			})
+		}
+		snapshot.Quotes = append(snapshot.Quotes, q)
+	}
+	for _, orders := range state.Orders {
+		for _, order := range orders {
+			placed := orderPlaced(order.ID, order.CustomerId, order.Items)
+			snapshot.Orders = append(snapshot.Orders, snapshotOrder{
+				Id:         order.ID,
+				CustomerId: order.CustomerId,
+				Status:     order.Status.String(),
+				CreatedAt:  order.CreatedAt,
+				Items:      placed.Items,
+			})
+		}
+	}
+	for _, ret := range state.Returns {
+		requested := returnRequested(ret, "", ret.History[0].At)
+		r := snapshotReturn{
+			Id:           ret.ID,
+			OrderId:      ret.OrderID,
+			CustomerId:   ret.CustomerId,
+			Reason:       ret.Reason.String(),
+			Status:       ret.Status.String(),
+			RefundAmount: ret.RefundAmount,
+			Items:        requested.Items,
+			History:      make([]snapshotReturnEvent, 0, len(ret.History)),
+		}
+		for _, event := range ret.History {
+			r.History = append(r.History, snapshotReturnEvent{Status: event.Status.String(), Note: event.Note, At: event.At})
+		}
+		snapshot.Returns = append(snapshot.Returns, r)
+	}
+	sort.Slice(snapshot.Quotes, func(i, j int) bool { return snapshot.Quotes[i].CustomerId < snapshot.Quotes[j].CustomerId })
+	sort.Slice(snapshot.Orders, func(i, j int) bool { return snapshot.Orders[i].Id < snapshot.Orders[j].Id })
+	sort.Slice(snapshot.Returns, func(i, j int) bool { return snapshot.Returns[i].Id < snapshot.Returns[j].Id })
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("error writing snapshot: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file with the data. It is written next to the old file
+// and renamed over it, a crash leaves either of them intact.
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// LoadEventState rebuilds the state from the snapshot of the store, if any, and the
+// events of the log recorded after it.
+func LoadEventState(log EventLog, store *SnapshotStore) (*EventState, error) {
+	state := NewEventState()
+	if store != nil {
+		var err error
+		if state, err = store.Load(); err != nil {
+			return nil, err
+		}
+	}
+	if sequence := log.Sequence(); sequence < state.Sequence {
+		return nil, fmt.Errorf("snapshot at event %d is ahead of the event log at event %d", state.Sequence, sequence)
+	}
+	err := log.Replay(state.Sequence, func(event Event) error {
+		if event.Sequence != state.Sequence+1 {
+			return fmt.Errorf("event %d follows event %d", event.Sequence, state.Sequence)
+		}
+		state.Apply(&event)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error replaying event log: %v", err)
+	}
+	return state, nil
+}
+
+// AttachEventLog restores the quotes, orders and returns from the state and makes the
+// servers record every change in the log from now on. It is called before the servers start.
+func AttachEventLog(log EventLog, state *EventState, quoteServer *QuoteServer, orderServer *OrderServer, returnServer *ReturnServer) error {
+	quoteStorage, ok := quoteServer.qouteStorage.(*QuoteStorage)
+	if !ok {
+		return fmt.Errorf("the quote storage does not support an event log")
+	}
+	state = state.copy()
+	quoteStorage.quotes = state.Quotes
+	quoteStorage.events = log
+	orderServer.orders = state.Orders
+	orderServer.customerOrderMap = state.OrderCustomers
+	for orderId := range state.OrderCustomers {
+		orderServer.lastOrderID = max(orderServer.lastOrderID, orderId)
+	}
+	orderServer.events = log
+	returnServer.returns = state.Returns
+	returnServer.orderReturns = state.OrderReturns
+	for returnId := range state.Returns {
+		returnServer.lastReturnID = max(returnServer.lastReturnID, returnId)
+	}
+	returnServer.events = log
+	return nil
+}
+
+// Snapshotter saves snapshots of the state rebuilt from the event log. It keeps a state
+// of its own, so it never reads the storages while they change.
+type Snapshotter struct {
+	log      EventLog
+	store    *SnapshotStore
+	state    *EventState
+	interval time.Duration
+	// saved is the sequence number of the last snapshot saved, -1 before the first one.
+	saved int64
+	lock  sync.Mutex
+}
+
+func NewSnapshotter(log EventLog, store *SnapshotStore, state *EventState, interval time.Duration) *Snapshotter {
+	return &Snapshotter{log: log, store: store, state: state.copy(), interval: interval, saved: -1}
+}
+
+// Snapshot applies the events recorded since the last snapshot and saves the state.
+func (s *Snapshotter) Snapshot() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.log.Replay(s.state.Sequence, func(event Event) error {
+		s.state.Apply(&event)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error replaying event log: %v", err)
+	}
+	if s.state.Sequence == s.saved {
+		return nil
+	}
+	if err := s.store.Save(s.state); err != nil {
+		return err
+	}
+	s.saved = s.state.Sequence
+	return nil
+}
+
+// Run takes a snapshot every interval until ctx is done.
+func (s *Snapshotter) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				slog.Error("failed to save snapshot", "error", err)
+			}
+		}
+	}
+}
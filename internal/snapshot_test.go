@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotStore_SaveAndLoad(t *testing.T) {
+	store := NewSnapshotStore(filepath.Join(t.TempDir(), "snapshot.json"))
+	state, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, NewEventState(), state)
+
+	log := NewMemoryEventLog()
+	quoteServer, orderServer, returnServer := newEventServers(t, log)
+	ctx := context.Background()
+	_, err = quoteServer.qouteStorage.AddLine(1, 101, "red", map[string]string{"size": "M"}, 2, 10)
+	require.NoError(t, err)
+	require.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, &discardStream{ctx: ctx}))
+	ret, err := returnServer.RequestReturn(ctx, 1, map[int32]int32{1: 1}, ReasonWrongItem, "wrong color")
+	require.NoError(t, err)
+	_, err = returnServer.ApproveReturn(ctx, ret.ID, "")
+	require.NoError(t, err)
+	_, err = quoteServer.qouteStorage.AddLine(1, 102, "", nil, 1, 5)
+	require.NoError(t, err)
+	_, err = quoteServer.qouteStorage.RemoveProduct(1, 102)
+	require.NoError(t, err)
+
+	state, err = LoadEventState(log, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Save(state))
+	loaded, err := store.Load()
+	require.NoError(t, err)
+
+	assert.Equal(t, state.Sequence, loaded.Sequence)
+	assert.Equal(t, state.Quotes, loaded.Quotes)
+	assert.Equal(t, state.OrderCustomers, loaded.OrderCustomers)
+	order, restored := state.Orders[1][1], loaded.Orders[1][1]
+	assert.True(t, order.CreatedAt.Equal(restored.CreatedAt))
+	restored.CreatedAt = order.CreatedAt
+	assert.Equal(t, order, restored)
+
+	assert.Equal(t, state.OrderReturns, loaded.OrderReturns)
+	ret, restoredRet := state.Returns[1], loaded.Returns[1]
+	require.Len(t, restoredRet.History, len(ret.History))
+	for i := range ret.History {
+		assert.True(t, ret.History[i].At.Equal(restoredRet.History[i].At))
+		restoredRet.History[i].At = ret.History[i].At
+	}
+	assert.Equal(t, ret, restoredRet)
+}
+
+func TestSnapshotter_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenFileEventLog(filepath.Join(dir, "events.jsonl"))
+	require.NoError(t, err)
+	defer log.Close()
+	store := NewSnapshotStore(filepath.Join(dir, "snapshot.json"))
+	quoteServer, _, _ := newEventServers(t, log)
+
+	state, err := LoadEventState(log, store)
+	require.NoError(t, err)
+	snapshotter := NewSnapshotter(log, store, state, 0)
+	_, err = quoteServer.qouteStorage.AddLine(1, 101, "", nil, 1, 10)
+	require.NoError(t, err)
+	_, err = quoteServer.qouteStorage.AddLine(1, 102, "", nil, 1, 10)
+	require.NoError(t, err)
+	require.NoError(t, snapshotter.Snapshot())
+
+	saved, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), saved.Sequence)
+	assert.Len(t, saved.Quotes[1].Items, 2)
+
+	// The restart replays only the events after the snapshot on top of it.
+	_, err = quoteServer.qouteStorage.RemoveProduct(1, 101)
+	require.NoError(t, err)
+	restored, err := LoadEventState(log, store)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), restored.Sequence)
+	assert.Equal(t, storedQuote(quoteServer.qouteStorage, 1), restored.Quotes[1])
+}
+
+func TestLoadEventState_SnapshotAheadOfLog(t *testing.T) {
+	log := NewMemoryEventLog()
+	require.NoError(t, log.Append(quoteCleared(1, "cleared"), quoteCleared(2, "cleared")))
+	store := NewSnapshotStore(filepath.Join(t.TempDir(), "snapshot.json"))
+	require.NoError(t, store.Save(&EventState{Sequence: 5}))
+
+	// A snapshot newer than the log means the log was lost or replaced.
+	_, err := LoadEventState(log, store)
+	assert.ErrorContains(t, err, "ahead of the event log")
+}
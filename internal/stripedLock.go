@@ -0,0 +1,17 @@
+package internal
+
+import "sync"
+
+// lockStripes is the number of locks the customers are spread over. Customers sharing a
+// stripe wait for each other, with this many stripes that is rare.
+const lockStripes = 256
+
+// stripedLock is a fixed set of RW mutexes picked by customer ID. It gives most customers
+// a lock of their own without keeping, and cleaning up, a lock per customer.
+type stripedLock struct {
+	stripes [lockStripes]sync.RWMutex
+}
+
+func (l *stripedLock) stripe(customerId int32) *sync.RWMutex {
+	return &l.stripes[uint32(customerId)%lockStripes]
+}
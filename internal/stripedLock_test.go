@@ -0,0 +1,15 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripedLock_Stripe(t *testing.T) {
+	var locks stripedLock
+	assert.Same(t, locks.stripe(1), locks.stripe(1))
+	assert.Same(t, locks.stripe(1), locks.stripe(1+lockStripes))
+	assert.NotSame(t, locks.stripe(1), locks.stripe(2))
+	assert.NotPanics(t, func() { locks.stripe(-1).Lock() })
+}
@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader keeps a certificate and a CA pool loaded from disk. Files are checked
+// for changes at most once per interval, when a handshake needs them, so renewed
+// certificates are picked up without a restart.
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	caFile    string
+	interval  time.Duration
+	lock      sync.Mutex
+	checkedAt time.Time
+	modTimes  map[string]time.Time
+	cert      *tls.Certificate
+	caPool    *x509.CertPool
+	now       func() time.Time
+}
+
+func newCertReloader(config TLSConfig) (*certReloader, error) {
+	r := &certReloader{
+		certFile: config.CertFile,
+		keyFile:  config.KeyFile,
+		caFile:   config.CAFile,
+		interval: config.ReloadInterval,
+		modTimes: make(map[string]time.Time),
+		now:      time.Now,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := make([]string, 0, 3)
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// load reads the files unconditionally. The caller must hold lock or own the reloader.
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		loaded, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("error loading certificate %s: %v", r.certFile, err)
+		}
+		cert = &loaded
+	}
+
+	var caPool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("error reading CA file %s: %v", r.caFile, err)
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", r.caFile)
+		}
+	}
+
+	r.cert = cert
+	r.caPool = caPool
+	r.modTimes = modTimes
+	r.checkedAt = r.now()
+	return nil
+}
+
+// current returns the certificate and CA pool, reloading them first if a file changed.
+// A failed reload keeps the previous certificates.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.now().Sub(r.checkedAt) < r.interval {
+		return r.cert, r.caPool
+	}
+	r.checkedAt = r.now()
+
+	changed := false
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(r.modTimes[file]) {
+			changed = true
+		}
+	}
+	if changed {
+		if err := r.load(); err != nil {
+			slog.Warn("failed to reload certificates, keeping the previous ones", "error", err)
+		}
+	}
+	return r.cert, r.caPool
+}
+
+// NewServerTLSConfig builds the TLS configuration of the gRPC server. With client
+// authentication enabled callers must present a certificate signed by the CA.
+func NewServerTLSConfig(config TLSConfig) (*tls.Config, error) {
+	reloader, err := newCertReloader(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, caPool := reloader.current()
+			serverConfig := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				// The config returned here replaces the base one, so gRPC's h2 has to be offered again.
+				NextProtos: []string{"h2"},
+			}
+			if config.ClientAuth {
+				serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+				serverConfig.ClientCAs = caPool
+			}
+			return serverConfig, nil
+		},
+	}, nil
+}
+
+// NewClientTLSConfig builds the TLS configuration of an outgoing connection. The
+// server certificate is verified against the CA file, or the system roots if none is
+// set, and the client certificate is presented when configured.
+func NewClientTLSConfig(config TLSConfig) (*tls.Config, error) {
+	reloader, err := newCertReloader(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.ServerName,
+		// The chain is verified in VerifyConnection so a reloaded CA pool is used.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			_, caPool := reloader.current()
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("server did not present a certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       state.ServerName,
+				Roots:         caPool,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := reloader.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}, nil
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.writePEM(t, "ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) writePEM(t *testing.T, name string, blockType string, der []byte) string {
+	path := filepath.Join(ca.dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+// issue writes a certificate and key signed by the CA and returns their paths.
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return ca.writePEM(t, name+".pem", "CERTIFICATE", der), ca.writePEM(t, name+"-key.pem", "EC PRIVATE KEY", keyDer)
+}
+
+func startTLSServer(t *testing.T, config TLSConfig) string {
+	tlsConfig, err := NewServerTLSConfig(config)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func checkHealth(t *testing.T, addr string, config TLSConfig) error {
+	tlsConfig, err := NewClientTLSConfig(config)
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestTLS_ServerAndClient(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "client", 3, x509.ExtKeyUsageClientAuth)
+	caFile := filepath.Join(ca.dir, "ca.pem")
+
+	tlsAddr := startTLSServer(t, TLSConfig{Enabled: true, CertFile: serverCert, KeyFile: serverKey, ReloadInterval: time.Minute})
+	mtlsAddr := startTLSServer(t, TLSConfig{Enabled: true, CertFile: serverCert, KeyFile: serverKey, CAFile: caFile, ClientAuth: true, ReloadInterval: time.Minute})
+
+	otherCA := newTestCA(t)
+	otherCAFile := filepath.Join(otherCA.dir, "ca.pem")
+
+	tests := []struct {
+		name        string
+		addr        string
+		client      TLSConfig
+		expectError bool
+	}{
+		{"TLS", tlsAddr, TLSConfig{CAFile: caFile, ServerName: "localhost"}, false},
+		{"TLS with unknown CA", tlsAddr, TLSConfig{CAFile: otherCAFile, ServerName: "localhost"}, true},
+		{"TLS with wrong server name", tlsAddr, TLSConfig{CAFile: caFile, ServerName: "catalog"}, true},
+		{"mTLS", mtlsAddr, TLSConfig{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey, ServerName: "localhost"}, false},
+		{"mTLS without client certificate", mtlsAddr, TLSConfig{CAFile: caFile, ServerName: "localhost"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.client.ReloadInterval = time.Minute
+			err := checkHealth(t, test.addr, test.client)
+			if test.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTLS_ReloadCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	tlsConfig, err := NewServerTLSConfig(TLSConfig{Enabled: true, CertFile: serverCert, KeyFile: serverKey})
+	require.NoError(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	require.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	servedSerial := func() int64 {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	assert.Equal(t, int64(2), servedSerial())
+
+	// Make sure the modification time differs on file systems with a coarse resolution.
+	time.Sleep(10 * time.Millisecond)
+	ca.issue(t, "server", 4, x509.ExtKeyUsageServerAuth)
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(serverCert, later, later))
+	require.NoError(t, os.Chtimes(serverKey, later, later))
+
+	assert.Equal(t, int64(4), servedSerial())
+}
+
+func TestNewServerTLSConfig_NegotiatesHTTP2(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	tlsConfig, err := NewServerTLSConfig(TLSConfig{Enabled: true, CertFile: serverCert, KeyFile: serverKey})
+	require.NoError(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	require.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "h2", conn.ConnectionState().NegotiatedProtocol)
+}
+
+func TestNewServerTLSConfig_MissingFiles(t *testing.T) {
+	_, err := NewServerTLSConfig(TLSConfig{Enabled: true, CertFile: "/does/not/exist.pem", KeyFile: "/does/not/exist-key.pem"})
+	assert.Error(t, err)
+}
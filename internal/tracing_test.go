@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	pbc "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/catalog"
+	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	testSpans     = tracetest.NewInMemoryExporter()
+	testSpansOnce sync.Once
+)
+
+// recordSpans installs a global provider recording into testSpans. The package tracer
+// binds to the first global provider, so it is installed only once and reset per test.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	testSpansOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(testSpans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	testSpans.Reset()
+	return testSpans
+}
+
+func spanNames(exporter *tracetest.InMemoryExporter) []string {
+	names := make([]string, 0)
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+func TestTracing_PlaceOrderSpans(t *testing.T) {
+	spans := recordSpans(t)
+	catalogClient := &MockCatalogClient{}
+	catalogClient.On("GetProductInfo", uint64(1)).Return(&pbc.Product{Id: 1, Price: 10}, nil)
+	quoteStorage := &QuoteStorage{
+		quotes: map[int32]*Quote{
+			1: {CustomerId: 1, Items: map[int32]*QuoteItem{1: {LineID: 1, ProductID: 1, Quantity: 1, Price: 10}}},
+		},
+	}
+	orderServer := NewOrderServer(OrderConfig{}, quoteStorage, catalogClient, nil)
+
+	ctx, root := tracer.Start(context.Background(), "PlaceOrder")
+	stream := &MockOrderService_PlaceOrderServer{}
+	stream.On("Send", mock.Anything).Return(nil)
+	stream.On("Context").Return(ctx)
+	assert.NoError(t, orderServer.PlaceOrder(&pb.CustomerId{Id: 1}, stream))
+	root.End()
+
+	assert.Equal(t, []string{
+		"checkout.lock", "checkout.price", "checkout.create_order",
+		"checkout.step", "checkout.step", "checkout.step", "checkout.step",
+		"PlaceOrder",
+	}, spanNames(spans))
+	for _, span := range spans.GetSpans()[:7] {
+		assert.Equal(t, root.SpanContext().SpanID(), span.Parent.SpanID())
+	}
+}
+
+func TestTracing_QuoteStorageSpans(t *testing.T) {
+	spans := recordSpans(t)
+	quoteServer, _ := NewQuoteServer(QuoteConfig{}, newPriceCatalogClient(10.0), nil)
+
+	_, err := quoteServer.AddProduct(context.Background(), &pb.ProductRequest{CustomerId: 1, ProductId: 101, Quantity: 1})
+	assert.NoError(t, err)
+	_, err = quoteServer.RemoveProduct(context.Background(), &pb.ProductRequest{CustomerId: 2, ProductId: 101})
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"QuoteStorage.AddProduct", "QuoteStorage.RemoveProduct"}, spanNames(spans))
+	assert.Equal(t, "Error", spans.GetSpans()[1].Status.Code.String())
+}
+
+type traceCatalogServer struct {
+	pbc.UnimplementedProductInfoServer
+	traceparent chan string
+}
+
+func (s *traceCatalogServer) GetProductInfo(ctx context.Context, in *pbc.ProductId) (*pbc.Product, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.traceparent <- md.Get("traceparent")[0]
+	return &pbc.Product{Id: in.Id}, nil
+}
+
+func TestTracing_CatalogPropagation(t *testing.T) {
+	recordSpans(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	catalog := &traceCatalogServer{traceparent: make(chan string, 1)}
+	pbc.RegisterProductInfoServer(server, catalog)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	catalogClient, err := NewCatalogClient(CatalogConfig{Address: lis.Addr().String()}, grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	require.NoError(t, err)
+	defer catalogClient.Close()
+
+	ctx, span := tracer.Start(context.Background(), "checkout")
+	_, err = catalogClient.GetProductInfo(ctx, 1)
+	span.End()
+	require.NoError(t, err)
+
+	assert.Contains(t, <-catalog.traceparent, span.SpanContext().TraceID().String())
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	var out bytes.Buffer
+	provider, err := newTracerProvider(TracingConfig{ServiceName: "sale", SampleRatio: 1}, &out)
+	require.NoError(t, err)
+
+	for _, name := range []string{"exported", "second"} {
+		_, span := provider.Tracer("test").Start(context.Background(), name)
+		span.End()
+	}
+	require.NoError(t, provider.Shutdown(context.Background()))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2, "one span per line")
+	for _, line := range lines {
+		assert.True(t, json.Valid([]byte(line)))
+	}
+	assert.Contains(t, lines[0], `"Name":"exported"`)
+	assert.Contains(t, lines[0], `"Value":"sale"`)
+}
+
+func TestSetupTracing_UnknownExporter(t *testing.T) {
+	_, err := SetupTracing(TracingConfig{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
@@ -1,21 +1,25 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"sale/internal"
+	"syscall"
 
 	pb "github.com/akolpakov-somehash/headless-ecom-protos/gen/go/sale"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 
 	"google.golang.org/grpc"
-)
-
-var (
-	port = flag.Int("port", 50052, "The server port")
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 func loadEnv() error {
@@ -30,27 +34,297 @@ func loadEnv() error {
 func main() {
 	err := loadEnv()
 	if err != nil {
-		log.Fatalf("failed to load env: %v", err)
+		fatal("failed to load env", err)
+	}
+	config, err := internal.LoadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		fatal("failed to load config", err)
 	}
-	flag.Parse()
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	logger, err := internal.NewLogger(config.Logging, os.Stderr)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		fatal("failed to create logger", err)
 	}
-	s := grpc.NewServer()
-	qouteServer, quoteStorage := internal.NewQuoteServer()
-	catalogClient, err := internal.NewCatalogClient()
+	slog.SetDefault(logger)
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Server.Port))
 	if err != nil {
-		log.Fatalf("failed to create a new catalog client: %v", err)
+		fatal("failed to listen", err)
+	}
+	requestLogger := internal.NewRequestLogger(logger)
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(requestLogger.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(requestLogger.StreamServerInterceptor()),
+	}
+	var catalogOptions []grpc.DialOption
+	if config.Tracing.Enabled {
+		shutdownTracing, err := internal.SetupTracing(config.Tracing)
+		if err != nil {
+			fatal("failed to set up tracing", err)
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				slog.Error("failed to flush traces", "error", err)
+			}
+		}()
+		serverOptions = append(serverOptions, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+		catalogOptions = append(catalogOptions, grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	}
+	var metrics *internal.Metrics
+	if config.Metrics.Enabled {
+		metrics = internal.NewMetrics()
+		serverOptions = append(serverOptions,
+			grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(metrics.StreamServerInterceptor()),
+		)
+		catalogOptions = append(catalogOptions, grpc.WithChainUnaryInterceptor(metrics.UnaryClientInterceptor()))
 	}
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to create a new catalog client: %v", err)
+	if config.Server.TLS.Enabled {
+		tlsConfig, err := internal.NewServerTLSConfig(config.Server.TLS)
+		if err != nil {
+			fatal("failed to load TLS certificates", err)
+		}
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
+	// Panics and deadlines are handled inside logging and metrics, so they see the outcome.
+	deadlines := internal.NewDeadlines(config.Server)
+	serverOptions = append(serverOptions,
+		grpc.ChainUnaryInterceptor(internal.RecoveryUnaryServerInterceptor(), deadlines.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(internal.RecoveryStreamServerInterceptor(), deadlines.StreamServerInterceptor()),
+	)
+	var authenticator *internal.Authenticator
+	if config.Auth.Enabled {
+		authenticator, err = internal.NewAuthenticator(config.Auth)
+		if err != nil {
+			fatal("failed to create authenticator", err)
+		}
+		serverOptions = append(serverOptions,
+			grpc.ChainUnaryInterceptor(authenticator.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(authenticator.StreamServerInterceptor()),
+		)
+	}
+	var limiter *internal.RateLimiter
+	if config.RateLimit.Enabled {
+		limiter = internal.NewRateLimiter(config.RateLimit)
+		serverOptions = append(serverOptions,
+			grpc.ChainUnaryInterceptor(limiter.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(limiter.StreamServerInterceptor()),
+		)
+	}
+	var authorizer *internal.Authorizer
+	if config.RBAC.Enabled {
+		authorizer, err = internal.NewAuthorizer(config.RBAC.Policies, metrics)
+		if err != nil {
+			fatal("failed to create authorizer", err)
+		}
+		serverOptions = append(serverOptions,
+			grpc.ChainUnaryInterceptor(authorizer.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(authorizer.StreamServerInterceptor()),
+		)
+	}
+	s := grpc.NewServer(serverOptions...)
+	catalogClient, err := internal.NewCatalogClient(config.Catalog, catalogOptions...)
+	if err != nil {
+		fatal("failed to create a new catalog client", err)
+	}
+	qouteServer, quoteStorage := internal.NewQuoteServer(config.Quote, catalogClient, metrics)
 	pb.RegisterQuoteServiceServer(s, qouteServer)
-	orderServer := internal.NewOrderServer(quoteStorage, catalogClient)
+	orderServer := internal.NewOrderServer(config.Order, quoteStorage, catalogClient, metrics)
 	pb.RegisterOrderServiceServer(s, orderServer)
-	log.Printf("server listening at %v", lis.Addr())
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	returnServer := internal.NewReturnServer(orderServer)
+	var eventLog internal.EventLog
+	var snapshotter *internal.Snapshotter
+	if config.Storage.EventLog.Enabled {
+		eventLog, snapshotter, err = openEventLog(config.Storage.EventLog, qouteServer, orderServer, returnServer)
+		if err != nil {
+			fatal("failed to restore from event log", err)
+		}
+	}
+	var relay *internal.OutboxRelay
+	if config.Outbox.Enabled {
+		relay, err = openOutbox(config.Outbox, eventLog, orderServer, metrics)
+		if err != nil {
+			fatal("failed to set up outbox", err)
+		}
+	}
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	if config.Server.Reflection {
+		reflection.Register(s)
+	}
+	healthChecker := internal.NewHealthChecker(healthServer, config.Server.HealthCheckInterval, config.Server.HealthCheckTimeout)
+	healthChecker.AddCheck("catalog", catalogClient.Ping, pb.QuoteService_ServiceDesc.ServiceName, pb.OrderService_ServiceDesc.ServiceName)
+	healthChecker.AddCheck("storage", quoteStorage.Ping, pb.QuoteService_ServiceDesc.ServiceName, pb.OrderService_ServiceDesc.ServiceName)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	healthCtx, stopHealthChecks := context.WithCancel(ctx)
+	go healthChecker.Run(healthCtx)
+	if snapshotter != nil {
+		go snapshotter.Run(ctx)
+	}
+	relayStopped := make(chan struct{})
+	if relay != nil {
+		go func() {
+			relay.Run(ctx)
+			close(relayStopped)
+		}()
+	} else {
+		close(relayStopped)
+	}
+
+	serveErr := make(chan error, 3)
+	go func() {
+		slog.Info("server listening", "address", lis.Addr().String())
+		serveErr <- s.Serve(lis)
+	}()
+
+	var metricsServer *http.Server
+	if metrics != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{Addr: config.Metrics.Address, Handler: mux}
+		go func() {
+			slog.Info("metrics listening", "address", config.Metrics.Address)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				serveErr <- fmt.Errorf("metrics server: %v", err)
+			}
+		}()
+	}
+
+	var gatewayServer *http.Server
+	if config.Gateway.Enabled {
+		gateway := internal.NewGateway(config.Gateway, qouteServer, orderServer, returnServer, authenticator, authorizer, limiter, deadlines)
+		gatewayServer = &http.Server{
+			Addr:    config.Gateway.Address,
+			Handler: gateway,
+			// Clients that never finish their headers would hold a connection forever.
+			ReadHeaderTimeout: config.Server.RequestTimeout,
+		}
+		go func() {
+			slog.Info("gateway listening", "address", config.Gateway.Address)
+			if err := gatewayServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				serveErr <- fmt.Errorf("gateway server: %v", err)
+			}
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		fatal("failed to serve", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down, waiting for in-flight checkouts", "timeout", config.Server.ShutdownTimeout)
+	stopHealthChecks()
+	healthServer.Shutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+	// Draining first refuses new checkouts on both the gateway and gRPC before either stops.
+	if err := orderServer.Drain(shutdownCtx); err != nil {
+		slog.Error("failed to drain checkouts", "error", err)
+	}
+	if gatewayServer != nil {
+		if err := gatewayServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to stop gateway", "error", err)
+		}
 	}
+	shutdown(shutdownCtx, s, quoteStorage, catalogClient)
+	<-relayStopped
+	if relay != nil {
+		// The checkouts are drained, publish what they recorded before the relay goes.
+		if err := relay.Flush(shutdownCtx); err != nil {
+			slog.Error("failed to publish outbox messages", "error", err)
+		}
+		if err := relay.Close(); err != nil {
+			slog.Error("failed to close outbox publisher", "error", err)
+		}
+	}
+	if snapshotter != nil {
+		if err := snapshotter.Snapshot(); err != nil {
+			slog.Error("failed to save snapshot", "error", err)
+		}
+	}
+	if eventLog != nil {
+		if err := eventLog.Close(); err != nil {
+			slog.Error("failed to close event log", "error", err)
+		}
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to stop metrics server", "error", err)
+		}
+	}
+	slog.Info("server stopped")
+}
+
+// shutdown drains the gRPC server, flushes the storage and closes the catalog
+// connection. Whatever is still running at the deadline is cut off.
+func shutdown(ctx context.Context, s *grpc.Server, quoteStorage internal.QuoteStorageInterface, catalogClient *internal.CatalogClient) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Warn("graceful stop timed out, closing remaining connections")
+		s.Stop()
+	}
+
+	if err := quoteStorage.Close(ctx); err != nil {
+		slog.Error("failed to flush storage", "error", err)
+	}
+	if err := catalogClient.Close(); err != nil {
+		slog.Error("failed to close catalog connection", "error", err)
+	}
+}
+
+// openEventLog restores the quotes, orders and returns from the event log and its snapshot and
+// records the changes from now on. The snapshotter is nil without a snapshot file.
+func openEventLog(config internal.EventLogConfig, quoteServer *internal.QuoteServer, orderServer *internal.OrderServer, returnServer *internal.ReturnServer) (internal.EventLog, *internal.Snapshotter, error) {
+	eventLog, err := internal.OpenEventLog(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	var store *internal.SnapshotStore
+	if config.SnapshotFile != "" {
+		store = internal.NewSnapshotStore(config.SnapshotFile)
+	}
+	state, err := internal.LoadEventState(eventLog, store)
+	if err == nil {
+		err = internal.AttachEventLog(eventLog, state, quoteServer, orderServer, returnServer)
+	}
+	if err != nil {
+		_ = eventLog.Close()
+		return nil, nil, err
+	}
+	slog.Info("restored from event log", "sequence", state.Sequence, "quotes", len(state.Quotes), "orders", len(state.OrderCustomers), "returns", len(state.Returns))
+	if store == nil {
+		return eventLog, nil, nil
+	}
+	return eventLog, internal.NewSnapshotter(eventLog, store, state, config.SnapshotInterval), nil
+}
+
+// openOutbox makes the order server record the messages of its changes in an outbox,
+// the event log when there is one, and returns the relay publishing them.
+func openOutbox(config internal.OutboxConfig, eventLog internal.EventLog, orderServer *internal.OrderServer, metrics *internal.Metrics) (*internal.OutboxRelay, error) {
+	var outbox internal.Outbox = internal.NewMemoryOutbox()
+	if eventLog != nil {
+		var err error
+		if outbox, err = internal.NewEventLogOutbox(eventLog, config.CursorFile); err != nil {
+			return nil, err
+		}
+	}
+	publisher, err := internal.OpenPublisher(config)
+	if err != nil {
+		return nil, err
+	}
+	internal.AttachOutbox(outbox, orderServer)
+	slog.Info("publishing order changes", "publisher", config.Publisher)
+	return internal.NewOutboxRelay(config, outbox, publisher, metrics), nil
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }